@@ -0,0 +1,69 @@
+package capture
+
+import "time"
+
+// testCase mirrors Keploy's Http test-case YAML
+type testCase struct {
+	Version string   `yaml:"version"`
+	Kind    string   `yaml:"kind"`
+	Name    string   `yaml:"name"`
+	Spec    testSpec `yaml:"spec"`
+}
+
+type testSpec struct {
+	Metadata   map[string]string `yaml:"metadata"`
+	Req        testRequest       `yaml:"req"`
+	Resp       testResponse      `yaml:"resp"`
+	Objects    []interface{}     `yaml:"objects"`
+	Assertions struct {
+		Noise map[string][]string `yaml:"noise"`
+	} `yaml:"assertions"`
+	Created int64 `yaml:"created"`
+}
+
+type testRequest struct {
+	Method     string            `yaml:"method"`
+	ProtoMajor int               `yaml:"proto_major"`
+	ProtoMinor int               `yaml:"proto_minor"`
+	URL        string            `yaml:"url"`
+	Header     map[string]string `yaml:"header"`
+	Body       string            `yaml:"body"`
+	Timestamp  time.Time         `yaml:"timestamp"`
+}
+
+type testResponse struct {
+	StatusCode int               `yaml:"status_code"`
+	Header     map[string]string `yaml:"header"`
+	Body       string            `yaml:"body"`
+	Timestamp  time.Time         `yaml:"timestamp"`
+}
+
+func keployTestCase(name string, e Exchange) testCase {
+	tc := testCase{
+		Version: "api.keploy.io/v1beta1",
+		Kind:    "Http",
+		Name:    name,
+		Spec: testSpec{
+			Metadata: map[string]string{},
+			Req: testRequest{
+				Method:     e.Method,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				URL:        e.URL,
+				Header:     e.Headers,
+				Body:       e.Body,
+				Timestamp:  e.Timestamp,
+			},
+			Resp: testResponse{
+				StatusCode: e.Response.StatusCode,
+				Header:     e.Response.Headers,
+				Body:       e.Response.Body,
+				Timestamp:  e.Response.Timestamp,
+			},
+			Objects: []interface{}{},
+			Created: e.Timestamp.Unix(),
+		},
+	}
+	tc.Spec.Assertions.Noise = e.Noise
+	return tc
+}
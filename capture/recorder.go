@@ -0,0 +1,266 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sample-application/logging"
+	"sample-application/middleware"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats the recorder can write
+const (
+	// FormatYAML writes one Keploy Http test case per file under dir/tests,
+	// ready for `keploy test` or the Keploy CLI
+	FormatYAML = "yaml"
+	// FormatJSONL appends one JSON object per exchange to dir/requests.jsonl,
+	// which the load tester can replay
+	FormatJSONL = "jsonl"
+)
+
+// Options configures a Recorder
+type Options struct {
+	Dir    string
+	Format string
+	// SampleRate is the fraction of requests captured, from 0 to 1
+	SampleRate float64
+	// RedactFields are JSON fields and query parameters whose values are
+	// replaced; email addresses are pseudonymised wherever they appear
+	RedactFields  []string
+	RedactHeaders []string
+	// Exchanges with a larger request or response body aren't captured
+	MaxBodyBytes int
+	// ExcludePaths are never captured; a trailing * matches a prefix
+	ExcludePaths []string
+}
+
+// Recorder captures sampled request/response pairs as replayable test
+// cases
+type Recorder struct {
+	opts     Options
+	redactor *redactor
+
+	mu   sync.Mutex
+	next int // number of the next YAML test case
+}
+
+// New prepares dir for writing. YAML test cases are numbered on from the
+// highest already in dir/tests, so a test set can keep growing.
+func New(opts Options) (*Recorder, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	rec := &Recorder{
+		opts:     opts,
+		redactor: newRedactor(opts.RedactFields, opts.RedactHeaders, salt),
+		next:     1,
+	}
+
+	switch opts.Format {
+	case FormatYAML:
+		dir := filepath.Join(opts.Dir, "tests")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		existing, err := filepath.Glob(filepath.Join(dir, "test-*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range existing {
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "test-"), ".yaml"))
+			if err == nil && n >= rec.next {
+				rec.next = n + 1
+			}
+		}
+	case FormatJSONL:
+		if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown capture format %q", opts.Format)
+	}
+	return rec, nil
+}
+
+// Exchange is one captured request and response, after redaction
+type Exchange struct {
+	Timestamp time.Time           `json:"timestamp"`
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	Headers   map[string]string   `json:"headers"`
+	Body      string              `json:"body"`
+	Response  ExchangeResponse    `json:"response"`
+	Noise     map[string][]string `json:"noise"`
+}
+
+type ExchangeResponse struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+// bodyRecorder keeps a copy of the response body, up to limit+1 bytes so
+// an oversized one can be recognised
+type bodyRecorder struct {
+	*middleware.ResponseRecorder
+	body  bytes.Buffer
+	limit int
+}
+
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	if room := b.limit + 1 - b.body.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.body.Write(p[:room])
+	}
+	return b.ResponseRecorder.Write(p)
+}
+
+// Middleware captures a sample of the requests passing through it
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mathrand.Float64() >= rec.opts.SampleRate || rec.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := rec.opts.MaxBodyBytes
+		reqBody, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Hand the handler the whole body, including anything past the limit
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(reqBody), r.Body))
+		reqHeader := r.Header.Clone()
+		start := time.Now()
+
+		resp := &bodyRecorder{ResponseRecorder: middleware.NewResponseRecorder(w), limit: limit}
+		next.ServeHTTP(resp, r)
+
+		if len(reqBody) > limit || resp.body.Len() > limit {
+			return
+		}
+		exchange := rec.exchange(r, reqHeader, reqBody, start, resp)
+		if err := rec.write(exchange); err != nil {
+			logging.FromContext(r.Context()).Warn("capturing request failed", "error", err)
+		}
+	})
+}
+
+func (rec *Recorder) excluded(path string) bool {
+	for _, pattern := range rec.opts.ExcludePaths {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(path, prefix) || path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (rec *Recorder) exchange(r *http.Request, reqHeader http.Header, reqBody []byte, start time.Time, resp *bodyRecorder) Exchange {
+	red := rec.redactor
+	u := red.url(r.URL)
+	u.Scheme, u.Host = "http", r.Host
+
+	body := string(reqBody)
+	if body != "" {
+		body = red.body(reqBody)
+	}
+	respBody := resp.body.String()
+	if respBody != "" {
+		respBody = red.body(resp.body.Bytes())
+	}
+
+	headers := red.header(reqHeader)
+	fixContentLength(headers, body)
+	respHeaders := red.header(resp.Header())
+	fixContentLength(respHeaders, respBody)
+
+	return Exchange{
+		Timestamp: start.UTC(),
+		Method:    r.Method,
+		URL:       u.String(),
+		Headers:   headers,
+		Body:      body,
+		Response: ExchangeResponse{
+			Timestamp:  time.Now().UTC(),
+			StatusCode: resp.Status,
+			Headers:    respHeaders,
+			Body:       respBody,
+		},
+		Noise: noise(requestValues(u, []byte(body)), resp.Header(), []byte(respBody)),
+	}
+}
+
+// fixContentLength keeps a recorded Content-Length true to the redacted
+// body
+func fixContentLength(headers map[string]string, body string) {
+	if _, ok := headers["Content-Length"]; ok {
+		headers["Content-Length"] = strconv.Itoa(len(body))
+	}
+}
+
+func (rec *Recorder) write(e Exchange) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.opts.Format == FormatJSONL {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(rec.opts.Dir, "requests.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	// Another server sharing the directory may have taken the next number
+	for attempt := 0; ; attempt++ {
+		name := "test-" + strconv.Itoa(rec.next)
+		var buf bytes.Buffer
+		buf.WriteString("# Captured by ecommerce-api\n")
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(keployTestCase(name, e)); err != nil {
+			return err
+		}
+		file := filepath.Join(rec.opts.Dir, "tests", name+".yaml")
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		rec.next++
+		if errors.Is(err, fs.ErrExist) && attempt < 100 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(buf.Bytes())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
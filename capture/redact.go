@@ -0,0 +1,236 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	emailPattern      = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	wholeEmailPattern = regexp.MustCompile(`^` + emailPattern.String() + `$`)
+)
+
+// redactor scrubs personal data and credentials from captured traffic
+type redactor struct {
+	fields  map[string]bool
+	headers map[string]bool
+	// salt makes pseudonymised emails unguessable from outside the process
+	salt []byte
+}
+
+func newRedactor(fields, headers []string, salt []byte) *redactor {
+	r := &redactor{fields: map[string]bool{}, headers: map[string]bool{}, salt: salt}
+	for _, f := range fields {
+		r.fields[strings.ToLower(f)] = true
+	}
+	for _, h := range headers {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return r
+}
+
+// email replaces an address with a stable placeholder, so the same user
+// gets the same address in a request and its response, and unique
+// constraints still hold when the capture is replayed
+func (r *redactor) email(address string) string {
+	sum := sha256.Sum256(append(r.salt, strings.ToLower(address)...))
+	return "redacted-" + hex.EncodeToString(sum[:5]) + "@example.com"
+}
+
+func (r *redactor) emails(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, r.email)
+}
+
+// field scrubs the value of a JSON field or query parameter. Configured
+// fields are redacted, unless the value is nothing but an email address,
+// which is pseudonymised so it still matches the rest of the capture. Other
+// fields only have the email addresses in them pseudonymised.
+func (r *redactor) field(key, value string) string {
+	switch {
+	case !r.fields[strings.ToLower(key)]:
+		return r.emails(value)
+	case wholeEmailPattern.MatchString(value):
+		return r.email(value)
+	default:
+		return redacted
+	}
+}
+
+// header flattens h, blanking the configured headers
+func (r *redactor) header(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			value = redacted
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// url scrubs each query parameter with field
+func (r *redactor) url(u *url.URL) *url.URL {
+	scrubbed := *u
+	if u.RawQuery == "" {
+		return &scrubbed
+	}
+	query := u.Query()
+	for key, values := range query {
+		for i, v := range values {
+			values[i] = r.field(key, v)
+		}
+		query[key] = values
+	}
+	scrubbed.RawQuery = query.Encode()
+	return &scrubbed
+}
+
+// body scrubs a JSON body field by field, and any other body of email
+// addresses
+func (r *redactor) body(data []byte) string {
+	var doc interface{}
+	if !decodeJSON(data, &doc) {
+		return r.emails(string(data))
+	}
+	doc = r.value("", doc)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return r.emails(string(data))
+	}
+	return buf.String()
+}
+
+func (r *redactor) value(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = r.value(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.value(key, child)
+		}
+		return v
+	case string:
+		return r.field(key, v)
+	default:
+		if r.fields[strings.ToLower(key)] {
+			return redacted
+		}
+		return v
+	}
+}
+
+func decodeJSON(data []byte, v interface{}) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v) == nil && !decoder.More()
+}
+
+// Response headers that change on every request
+var volatileHeaders = []string{"Date", "X-Request-Id"}
+
+// requestValues collects the path segments, query values and JSON leaf
+// values of a request, so IDs the client supplied can be told apart from
+// ones the server generated
+func requestValues(u *url.URL, body []byte) map[string]bool {
+	values := map[string]bool{}
+	for _, segment := range strings.Split(u.Path, "/") {
+		values[segment] = true
+	}
+	for _, vs := range u.Query() {
+		for _, v := range vs {
+			values[v] = true
+		}
+	}
+	var doc interface{}
+	if !decodeJSON(body, &doc) {
+		return values
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		case string:
+			values[v] = true
+		case json.Number:
+			values[v.String()] = true
+		}
+	}
+	walk(doc)
+	return values
+}
+
+// noise lists the response fields a replay can't be expected to
+// reproduce: timestamps, IDs the server generated and per-request
+// headers. Paths drop array indexes, as the
+// Keploy noise format does.
+func noise(sent map[string]bool, header http.Header, body []byte) map[string][]string {
+	fields := map[string][]string{}
+	for _, name := range volatileHeaders {
+		if header.Get(name) != "" {
+			fields["header."+name] = []string{}
+		}
+	}
+
+	var doc interface{}
+	if !decodeJSON(body, &doc) {
+		return fields
+	}
+	var walk func(path, key string, v interface{})
+	walk = func(path, key string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				walk(path+"."+k, k, child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(path, key, child)
+			}
+		case string:
+			if isTimestamp(v) || isGeneratedID(key, v, sent) {
+				fields[path] = []string{}
+			}
+		case json.Number:
+			if isGeneratedID(key, v.String(), sent) {
+				fields[path] = []string{}
+			}
+		}
+	}
+	walk("body", "", doc)
+	return fields
+}
+
+func isTimestamp(s string) bool {
+	_, err := time.Parse(time.RFC3339Nano, s)
+	return err == nil
+}
+
+// isGeneratedID reports whether value is a document's own ID that the
+// client didn't send. References such as product_id come from existing
+// data, so they are left to be compared.
+func isGeneratedID(key, value string, sent map[string]bool) bool {
+	key = strings.ToLower(key)
+	return (key == "id" || key == "_id") && !sent[value]
+}
@@ -0,0 +1,208 @@
+package capture
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testRedactor() *redactor {
+	return newRedactor([]string{"password", "Email", "phone", "address"}, []string{"authorization"}, []byte("salt"))
+}
+
+func TestRedactorBody(t *testing.T) {
+	r := testRedactor()
+	ada, bob := r.email("ada@example.org"), r.email("bob@example.net")
+	if ada == bob || !strings.HasPrefix(ada, "redacted-") || r.email("ADA@example.org") != ada {
+		t.Fatalf("pseudonyms %q and %q should differ, be prefixed and ignore case", ada, bob)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "configured fields",
+			body: `{"name": "Ada", "password": "hunter2", "phone": 5551234, "address": null}`,
+			want: `{"address":"[REDACTED]","name":"Ada","password":"[REDACTED]","phone":"[REDACTED]"}`,
+		},
+		{
+			name: "field names ignore case",
+			body: `{"PASSWORD": "hunter2", "Phone": "555-1234"}`,
+			want: `{"PASSWORD":"[REDACTED]","Phone":"[REDACTED]"}`,
+		},
+		{
+			name: "a configured field holding an email is pseudonymised",
+			body: `{"email": "ada@example.org"}`,
+			want: `{"email":"` + ada + `"}`,
+		},
+		{
+			name: "a configured field containing an email is redacted",
+			body: `{"password": "hunter2 ada@example.org", "email": "Ada <ada@example.org>"}`,
+			want: `{"email":"[REDACTED]","password":"[REDACTED]"}`,
+		},
+		{
+			name: "emails in other fields are pseudonymised",
+			body: `{"comment": "mail ada@example.org or bob@example.net", "id": 7}`,
+			want: `{"comment":"mail ` + ada + ` or ` + bob + `","id":7}`,
+		},
+		{
+			name: "nested objects and arrays",
+			body: `[{"user": {"password": "x", "email": "bob@example.net"}}, {"phone": ["1", "2"]}]`,
+			want: `[{"user":{"email":"` + bob + `","password":"[REDACTED]"}},{"phone":["[REDACTED]","[REDACTED]"]}]`,
+		},
+		{
+			name: "objects under a configured field are scrubbed by their own keys",
+			body: `{"address": {"city": "Paris"}}`,
+			want: `{"address":{"city":"Paris"}}`,
+		},
+		{
+			name: "HTML isn't escaped",
+			body: `{"comment": "<b>&</b>"}`,
+			want: `{"comment":"<b>&</b>"}`,
+		},
+		{
+			name: "plain text",
+			body: "User ada@example.org not found\n",
+			want: "User " + ada + " not found\n",
+		},
+		{
+			name: "empty",
+			body: "",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.TrimSuffix(r.body([]byte(tt.body)), "\n"); got != strings.TrimSuffix(tt.want, "\n") {
+				t.Errorf("body(%s) =\n%s\nwant\n%s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorURL(t *testing.T) {
+	r := testRedactor()
+	ada := r.email("ada@example.org")
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "/api/products", want: "/api/products"},
+		{url: "/api/products?limit=10&q=laptop", want: "/api/products?limit=10&q=laptop"},
+		{url: "/api/login?password=hunter2", want: "/api/login?password=%5BREDACTED%5D"},
+		{url: "/api/users?email=ada@example.org", want: "/api/users?email=" + url.QueryEscape(ada)},
+		{url: "/api/login?password=hunter2+ada@example.org", want: "/api/login?password=%5BREDACTED%5D"},
+		{url: "/api/search?q=ada@example.org", want: "/api/search?q=" + url.QueryEscape(ada)},
+		{url: "/api/users?Phone=1&Phone=2", want: "/api/users?Phone=%5BREDACTED%5D&Phone=%5BREDACTED%5D"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.url(u).String(); got != tt.want {
+			t.Errorf("url(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+		if u.String() != tt.url {
+			t.Errorf("url(%s) modified its argument to %s", tt.url, u)
+		}
+	}
+}
+
+func TestRedactorHeader(t *testing.T) {
+	r := testRedactor()
+	got := r.header(http.Header{"Authorization": {"Bearer secret"}, "Accept": {"text/html", "application/json"}})
+	want := map[string]string{"Authorization": redacted, "Accept": "text/html, application/json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("header() = %v, want %v", got, want)
+	}
+}
+
+func TestNoise(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		url     string
+		header  http.Header
+		body    string
+		want    map[string][]string
+	}{
+		{
+			name:   "volatile headers",
+			url:    "/health",
+			header: http.Header{"Date": {"now"}, "X-Request-Id": {"abc"}, "Content-Type": {"application/json"}},
+			body:   `{"status": "healthy"}`,
+			want:   map[string][]string{"header.Date": {}, "header.X-Request-Id": {}},
+		},
+		{
+			name: "timestamps and generated IDs",
+			url:  "/api/users",
+			body: `{"id": 42, "name": "Ada", "created_at": "2025-12-11T12:05:18.69Z", "birthday": "1990-01-01"}`,
+			want: map[string][]string{"body.id": {}, "body.created_at": {}},
+		},
+		{
+			name: "IDs the client sent in the path aren't noise",
+			url:  "/api/users/42",
+			body: `{"id": 42, "name": "Ada"}`,
+			want: map[string][]string{},
+		},
+		{
+			name:    "IDs the client sent in the body or query aren't noise",
+			request: `{"review": {"_id": "abc"}}`,
+			url:     "/api/reviews?id=7",
+			body:    `[{"id": 7}, {"_id": "abc"}, {"_id": "def"}]`,
+			want:    map[string][]string{"body._id": {}},
+		},
+		{
+			name: "array indexes are dropped",
+			url:  "/api/orders",
+			body: `{"items": [{"id": 1, "product_id": "p1"}, {"id": 2, "product_id": "p2"}]}`,
+			want: map[string][]string{"body.items.id": {}},
+		},
+		{
+			name: "plain text",
+			url:  "/api/orders/9",
+			body: "Order not found\n",
+			want: map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := noise(requestValues(u, []byte(tt.request)), tt.header, []byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("noise() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsGeneratedID(t *testing.T) {
+	sent := map[string]bool{"42": true, "abc": true}
+	tests := []struct {
+		key, value string
+		want       bool
+	}{
+		{key: "id", value: "7", want: true},
+		{key: "_id", value: "6579a1b2c3d4e5f6a7b8c9d0", want: true},
+		{key: "ID", value: "7", want: true},
+		{key: "id", value: "42", want: false},
+		{key: "_id", value: "abc", want: false},
+		{key: "product_id", value: "7", want: false},
+		{key: "user_id", value: "99", want: false},
+		{key: "", value: "7", want: false},
+	}
+	for _, tt := range tests {
+		if got := isGeneratedID(tt.key, tt.value, sent); got != tt.want {
+			t.Errorf("isGeneratedID(%q, %q) = %v, want %v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
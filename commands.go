@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"sample-application/config"
+	"sample-application/consistency"
+	"sample-application/handlers"
+	"sample-application/keploytest"
+	"sample-application/logging"
+	"sample-application/migrations"
+	"sample-application/seed"
+)
+
+// runCommand dispatches the CLI subcommands that run instead of the server
+func runCommand(cfg *config.Config, name string, args []string) error {
+	ctx := context.Background()
+
+	switch name {
+	case "config":
+		return config.RunCommand(cfg, args, os.Stdout)
+	case "migrate":
+		config.InitDatabases(cfg)
+		defer config.CloseDatabases()
+
+		migrators, err := newMigrators()
+		if err != nil {
+			return err
+		}
+		return migrations.RunCommand(ctx, args, migrators, os.Stdout)
+	case "ratings":
+		if len(args) != 1 || args[0] != "repair" {
+			return fmt.Errorf("usage: ratings repair")
+		}
+		config.InitDatabases(cfg)
+		defer config.CloseDatabases()
+
+		n, err := handlers.RepairRatings(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "recomputed ratings for %d products\n", n)
+		return nil
+	case "seed":
+		config.InitDatabases(cfg)
+		defer config.CloseDatabases()
+
+		stores := seed.Stores{Postgres: config.PostgresDB, MySQL: config.MySQLDB, Mongo: config.GetMongoDatabase()}
+		if err := seed.RunCommand(ctx, args, stores, os.Stdout); err != nil {
+			return err
+		}
+		n, err := handlers.RepairRatings(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "recomputed ratings for %d products\n", n)
+		return nil
+	case "consistency":
+		config.InitDatabases(cfg)
+		defer config.CloseDatabases()
+
+		return consistency.RunCommand(ctx, args, handlers.NewConsistencyChecker(), os.Stdout)
+	case "keploy":
+		// Replays recorded test cases against the routes in process, so the
+		// databases must hold the data they were recorded against
+		config.InitDatabases(cfg)
+		defer config.CloseDatabases()
+
+		return keploytest.RunCommand(args, logging.RequestIDMiddleware(newRouter(cfg)), os.Stdout)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+func newMigrators() ([]migrations.Migrator, error) {
+	postgres, err := migrations.NewPostgres(config.PostgresDB)
+	if err != nil {
+		return nil, err
+	}
+	mysql, err := migrations.NewMySQL(config.MySQLDB)
+	if err != nil {
+		return nil, err
+	}
+	return []migrations.Migrator{postgres, mysql, migrations.NewMongo(config.GetMongoDatabase())}, nil
+}
+
+// migrateUp brings every store to the latest schema version
+func migrateUp(ctx context.Context) error {
+	migrators, err := newMigrators()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrators {
+		if err := m.Up(ctx); err != nil {
+			return fmt.Errorf("%s: %w", m.Store(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,664 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the application configuration. It is built from defaults, then
+// an optional YAML or TOML file, then environment variables, then command-line
+// flags, each layer overriding the previous one.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Postgres    PostgresConfig    `yaml:"postgres"`
+	MySQL       MySQLConfig       `yaml:"mysql"`
+	Mongo       MongoConfig       `yaml:"mongo"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Features    FeatureConfig     `yaml:"features"`
+	Inventory   InventoryConfig   `yaml:"inventory"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Moderation  ModerationConfig  `yaml:"moderation"`
+	Capture     CaptureConfig     `yaml:"capture"`
+}
+
+type ServerConfig struct {
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// PoolConfig holds database/sql connection pool settings
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+}
+
+type PostgresConfig struct {
+	Host     string     `yaml:"host"`
+	Port     int        `yaml:"port"`
+	User     string     `yaml:"user"`
+	Password Secret     `yaml:"password"`
+	Database string     `yaml:"database"`
+	SSLMode  string     `yaml:"sslmode"`
+	Pool     PoolConfig `yaml:"pool"`
+}
+
+type MySQLConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+	Database string `yaml:"database"`
+	// TLS is passed to the driver's tls parameter: false, true, skip-verify or preferred
+	TLS  string     `yaml:"tls"`
+	Pool PoolConfig `yaml:"pool"`
+}
+
+type MongoConfig struct {
+	Host           string        `yaml:"host"`
+	Port           int           `yaml:"port"`
+	User           string        `yaml:"user"`
+	Password       Secret        `yaml:"password"`
+	Database       string        `yaml:"database"`
+	TLS            bool          `yaml:"tls"`
+	MaxPoolSize    int           `yaml:"max_pool_size"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"service_name"`
+}
+
+type FeatureConfig struct {
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+	Metrics        bool `yaml:"metrics"`
+	AccessLog      bool `yaml:"access_log"`
+}
+
+// InventoryConfig tunes low-stock alerting and reorder suggestions
+type InventoryConfig struct {
+	LowStockMonitor  bool          `yaml:"low_stock_monitor"`
+	LowStockInterval time.Duration `yaml:"low_stock_interval"`
+	// LowStockWebhook, when set, receives each alert as a JSON POST
+	LowStockWebhook string `yaml:"low_stock_webhook"`
+	// Reorder suggestions cover the supplier lead time plus CoverDays of
+	// demand, at the sales velocity seen over the last VelocityWindowDays
+	LeadTimeDays       int `yaml:"lead_time_days"`
+	CoverDays          int `yaml:"cover_days"`
+	VelocityWindowDays int `yaml:"velocity_window_days"`
+}
+
+// IdempotencyConfig controls how long responses to requests sent with an
+// Idempotency-Key are kept for replay
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// ModerationConfig controls the screening of new and edited reviews.
+// Reviews that trip the screen are flagged for a moderator; the rest are
+// approved straight away if AutoApprove is set, and otherwise wait as pending.
+type ModerationConfig struct {
+	AutoApprove bool `yaml:"auto_approve"`
+	// BlockedWords are matched as whole words, ignoring case
+	BlockedWords []string `yaml:"blocked_words"`
+	// BlockedPatterns are regular expressions; they can only be set in the
+	// config file, since they may contain commas
+	BlockedPatterns []string `yaml:"blocked_patterns"`
+	// MaxLinks is the number of URLs a review may contain
+	MaxLinks int `yaml:"max_links"`
+	// An approved review is flagged again once this many customers report it
+	ReportThreshold int `yaml:"report_threshold"`
+}
+
+// CaptureConfig controls recording of sampled live traffic as replayable
+// test cases, e.g. to grow a Keploy test set from staging
+type CaptureConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir is the test-set directory for yaml, or where requests.jsonl goes
+	Dir        string  `yaml:"dir"`
+	Format     string  `yaml:"format"`
+	SampleRate float64 `yaml:"sample_rate"`
+	// RedactFields are JSON fields and query parameters whose values are
+	// blanked; email addresses are always pseudonymised
+	RedactFields  []string `yaml:"redact_fields"`
+	RedactHeaders []string `yaml:"redact_headers"`
+	MaxBodyBytes  int      `yaml:"max_body_bytes"`
+	// ExcludePaths are never captured; a trailing * matches a prefix
+	ExcludePaths []string `yaml:"exclude_paths"`
+}
+
+// Secret is a string that is redacted whenever it is printed or marshalled
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// Value returns the underlying secret
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// Default returns the built-in defaults. Credentials have no defaults and
+// must be supplied explicitly.
+func Default() *Config {
+	pool := PoolConfig{
+		MaxOpenConns:    25,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+		ConnectTimeout:  10 * time.Second,
+	}
+	return &Config{
+		Server: ServerConfig{
+			Port:            8080,
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Postgres: PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Database: "ecommerce",
+			SSLMode:  "disable",
+			Pool:     pool,
+		},
+		MySQL: MySQLConfig{
+			Host:     "localhost",
+			Port:     3306,
+			User:     "root",
+			Database: "ecommerce",
+			TLS:      "false",
+			Pool:     pool,
+		},
+		Mongo: MongoConfig{
+			Host:           "localhost",
+			Port:           27017,
+			Database:       "ecommerce",
+			MaxPoolSize:    100,
+			ConnectTimeout: 10 * time.Second,
+		},
+		Logging: LoggingConfig{Level: "info", Format: "json"},
+		Tracing: TracingConfig{Exporter: "none", ServiceName: "ecommerce-api"},
+		Features: FeatureConfig{
+			MigrateOnStart: true,
+			Metrics:        true,
+			AccessLog:      true,
+		},
+		Inventory: InventoryConfig{
+			LowStockMonitor:    true,
+			LowStockInterval:   time.Minute,
+			LeadTimeDays:       7,
+			CoverDays:          14,
+			VelocityWindowDays: 30,
+		},
+		Idempotency: IdempotencyConfig{TTL: 24 * time.Hour},
+		Moderation: ModerationConfig{
+			AutoApprove:     true,
+			ReportThreshold: 3,
+		},
+		Capture: CaptureConfig{
+			Dir:           "keploy/test-set-captured",
+			Format:        "yaml",
+			SampleRate:    0.01,
+			RedactFields:  []string{"password", "email", "phone", "address", "shipping_address"},
+			RedactHeaders: []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"},
+			MaxBodyBytes:  64 << 10,
+			ExcludePaths:  []string{"/metrics", "/health"},
+		},
+	}
+}
+
+// binding ties one config field to its environment variable and flag
+type binding struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"PORT", "port", "HTTP listen port", intValue{&c.Server.Port}},
+		{"SERVER_READ_TIMEOUT", "server-read-timeout", "HTTP read timeout", durationValue{&c.Server.ReadTimeout}},
+		{"SERVER_WRITE_TIMEOUT", "server-write-timeout", "HTTP write timeout", durationValue{&c.Server.WriteTimeout}},
+		{"SERVER_IDLE_TIMEOUT", "server-idle-timeout", "HTTP keep-alive idle timeout", durationValue{&c.Server.IdleTimeout}},
+		{"SERVER_SHUTDOWN_TIMEOUT", "server-shutdown-timeout", "graceful shutdown timeout", durationValue{&c.Server.ShutdownTimeout}},
+
+		{"POSTGRES_HOST", "postgres-host", "PostgreSQL host", stringValue{&c.Postgres.Host}},
+		{"POSTGRES_PORT", "postgres-port", "PostgreSQL port", intValue{&c.Postgres.Port}},
+		{"POSTGRES_USER", "postgres-user", "PostgreSQL user", stringValue{&c.Postgres.User}},
+		{"POSTGRES_PASSWORD", "postgres-password", "PostgreSQL password", secretValue{&c.Postgres.Password}},
+		{"POSTGRES_DB", "postgres-db", "PostgreSQL database", stringValue{&c.Postgres.Database}},
+		{"POSTGRES_SSLMODE", "postgres-sslmode", "PostgreSQL sslmode", stringValue{&c.Postgres.SSLMode}},
+		{"POSTGRES_MAX_OPEN_CONNS", "postgres-max-open-conns", "PostgreSQL max open connections", intValue{&c.Postgres.Pool.MaxOpenConns}},
+		{"POSTGRES_MAX_IDLE_CONNS", "postgres-max-idle-conns", "PostgreSQL max idle connections", intValue{&c.Postgres.Pool.MaxIdleConns}},
+		{"POSTGRES_CONN_MAX_LIFETIME", "postgres-conn-max-lifetime", "PostgreSQL connection max lifetime", durationValue{&c.Postgres.Pool.ConnMaxLifetime}},
+		{"POSTGRES_CONNECT_TIMEOUT", "postgres-connect-timeout", "PostgreSQL connect timeout", durationValue{&c.Postgres.Pool.ConnectTimeout}},
+
+		{"MYSQL_HOST", "mysql-host", "MySQL host", stringValue{&c.MySQL.Host}},
+		{"MYSQL_PORT", "mysql-port", "MySQL port", intValue{&c.MySQL.Port}},
+		{"MYSQL_USER", "mysql-user", "MySQL user", stringValue{&c.MySQL.User}},
+		{"MYSQL_PASSWORD", "mysql-password", "MySQL password", secretValue{&c.MySQL.Password}},
+		{"MYSQL_DB", "mysql-db", "MySQL database", stringValue{&c.MySQL.Database}},
+		{"MYSQL_TLS", "mysql-tls", "MySQL TLS mode", stringValue{&c.MySQL.TLS}},
+		{"MYSQL_MAX_OPEN_CONNS", "mysql-max-open-conns", "MySQL max open connections", intValue{&c.MySQL.Pool.MaxOpenConns}},
+		{"MYSQL_MAX_IDLE_CONNS", "mysql-max-idle-conns", "MySQL max idle connections", intValue{&c.MySQL.Pool.MaxIdleConns}},
+		{"MYSQL_CONN_MAX_LIFETIME", "mysql-conn-max-lifetime", "MySQL connection max lifetime", durationValue{&c.MySQL.Pool.ConnMaxLifetime}},
+		{"MYSQL_CONNECT_TIMEOUT", "mysql-connect-timeout", "MySQL connect timeout", durationValue{&c.MySQL.Pool.ConnectTimeout}},
+
+		{"MONGO_HOST", "mongo-host", "MongoDB host", stringValue{&c.Mongo.Host}},
+		{"MONGO_PORT", "mongo-port", "MongoDB port", intValue{&c.Mongo.Port}},
+		{"MONGO_USER", "mongo-user", "MongoDB user", stringValue{&c.Mongo.User}},
+		{"MONGO_PASSWORD", "mongo-password", "MongoDB password", secretValue{&c.Mongo.Password}},
+		{"MONGO_DB", "mongo-db", "MongoDB database", stringValue{&c.Mongo.Database}},
+		{"MONGO_TLS", "mongo-tls", "use TLS for MongoDB", boolValue{&c.Mongo.TLS}},
+		{"MONGO_MAX_POOL_SIZE", "mongo-max-pool-size", "MongoDB max pool size", intValue{&c.Mongo.MaxPoolSize}},
+		{"MONGO_CONNECT_TIMEOUT", "mongo-connect-timeout", "MongoDB connect timeout", durationValue{&c.Mongo.ConnectTimeout}},
+
+		{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", stringValue{&c.Logging.Level}},
+		{"LOG_FORMAT", "log-format", "log format: json or text", stringValue{&c.Logging.Format}},
+
+		{"OTEL_TRACES_EXPORTER", "trace-exporter", "trace exporter: otlp, stdout or none", stringValue{&c.Tracing.Exporter}},
+		{"OTEL_SERVICE_NAME", "service-name", "service name reported on spans", stringValue{&c.Tracing.ServiceName}},
+
+		{"MIGRATE_ON_START", "migrate-on-start", "apply pending migrations at startup", boolValue{&c.Features.MigrateOnStart}},
+		{"FEATURE_METRICS", "metrics", "serve Prometheus metrics on /metrics", boolValue{&c.Features.Metrics}},
+		{"FEATURE_ACCESS_LOG", "access-log", "write an access log line per request", boolValue{&c.Features.AccessLog}},
+
+		{"LOW_STOCK_MONITOR", "low-stock-monitor", "run the background low-stock monitor", boolValue{&c.Inventory.LowStockMonitor}},
+		{"LOW_STOCK_INTERVAL", "low-stock-interval", "how often the low-stock monitor checks inventory", durationValue{&c.Inventory.LowStockInterval}},
+		{"LOW_STOCK_WEBHOOK_URL", "low-stock-webhook", "URL to POST low-stock alerts to", stringValue{&c.Inventory.LowStockWebhook}},
+		{"REORDER_LEAD_TIME_DAYS", "reorder-lead-time-days", "supplier lead time used for reorder suggestions", intValue{&c.Inventory.LeadTimeDays}},
+		{"REORDER_COVER_DAYS", "reorder-cover-days", "days of demand a reorder should cover beyond the lead time", intValue{&c.Inventory.CoverDays}},
+		{"REORDER_VELOCITY_WINDOW_DAYS", "reorder-velocity-window-days", "days of sales used to estimate velocity", intValue{&c.Inventory.VelocityWindowDays}},
+
+		{"IDEMPOTENCY_TTL", "idempotency-ttl", "how long idempotent responses are kept for replay", durationValue{&c.Idempotency.TTL}},
+
+		{"REVIEW_AUTO_APPROVE", "review-auto-approve", "publish reviews that pass screening without a moderator", boolValue{&c.Moderation.AutoApprove}},
+		{"REVIEW_BLOCKED_WORDS", "review-blocked-words", "comma-separated words that flag a review for moderation", listValue{&c.Moderation.BlockedWords}},
+		{"REVIEW_MAX_LINKS", "review-max-links", "links a review may contain before it is flagged", intValue{&c.Moderation.MaxLinks}},
+		{"REVIEW_REPORT_THRESHOLD", "review-report-threshold", "customer reports that flag an approved review", intValue{&c.Moderation.ReportThreshold}},
+
+		{"CAPTURE_ENABLED", "capture", "record sampled traffic as replayable test cases", boolValue{&c.Capture.Enabled}},
+		{"CAPTURE_DIR", "capture-dir", "directory captured test cases are written to", stringValue{&c.Capture.Dir}},
+		{"CAPTURE_FORMAT", "capture-format", "capture format: yaml (Keploy test set) or jsonl", stringValue{&c.Capture.Format}},
+		{"CAPTURE_SAMPLE_RATE", "capture-sample-rate", "fraction of requests to capture, from 0 to 1", floatValue{&c.Capture.SampleRate}},
+		{"CAPTURE_REDACT_FIELDS", "capture-redact-fields", "comma-separated JSON fields and query parameters to redact", listValue{&c.Capture.RedactFields}},
+		{"CAPTURE_REDACT_HEADERS", "capture-redact-headers", "comma-separated headers to redact", listValue{&c.Capture.RedactHeaders}},
+		{"CAPTURE_MAX_BODY_BYTES", "capture-max-body-bytes", "skip exchanges with a larger request or response body", intValue{&c.Capture.MaxBodyBytes}},
+		{"CAPTURE_EXCLUDE_PATHS", "capture-exclude-paths", "comma-separated paths never captured; a trailing * matches a prefix", listValue{&c.Capture.ExcludePaths}},
+	}
+}
+
+// Load builds the configuration from defaults, the config file, environment
+// variables and flags in args, then validates it. It returns the arguments
+// left over after flag parsing (the subcommand, if any).
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	bindings := cfg.bindings()
+
+	// Flags are parsed first to find -config, but only recorded here and
+	// applied after the file and environment so that they take precedence
+	fs := flag.NewFlagSet("ecommerce-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file (env CONFIG_FILE)")
+	var flagValues []func() error
+	for _, b := range bindings {
+		b := b
+		record := func(raw string) error {
+			flagValues = append(flagValues, func() error {
+				if err := b.value.Set(raw); err != nil {
+					return fmt.Errorf("flag -%s: %w", b.flag, err)
+				}
+				return nil
+			})
+			return nil
+		}
+		usage := b.usage + " (env " + b.env + ")"
+		if _, ok := b.value.(boolValue); ok {
+			fs.BoolFunc(b.flag, usage, record)
+		} else {
+			fs.Func(b.flag, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, b := range bindings {
+		if raw := os.Getenv(b.env); raw != "" {
+			if err := b.value.Set(raw); err != nil {
+				return nil, nil, fmt.Errorf("environment variable %s: %w", b.env, err)
+			}
+		}
+	}
+
+	for _, apply := range flagValues {
+		if err := apply(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// yamlLine matches the line references in YAML decoding errors
+var yamlLine = regexp.MustCompile(`line \d+: `)
+
+// loadFile reads a YAML file, or a TOML file if its name ends in .toml.
+// TOML is converted to YAML first so both formats share the yaml tags, and
+// unknown keys are rejected the same way.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	isTOML := strings.EqualFold(filepath.Ext(path), ".toml")
+	if isTOML {
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		if isTOML {
+			// Line numbers would point into the converted YAML
+			return fmt.Errorf("parsing config file %s: %s", path, yamlLine.ReplaceAllString(err.Error(), ""))
+		}
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(c.Server.Port), "server.port: %d is not a valid port", c.Server.Port)
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+
+	check(c.Postgres.Host != "", "postgres.host is required")
+	check(validPort(c.Postgres.Port), "postgres.port: %d is not a valid port", c.Postgres.Port)
+	check(c.Postgres.User != "", "postgres.user is required")
+	check(c.Postgres.Password != "", "postgres.password is required (set POSTGRES_PASSWORD)")
+	check(c.Postgres.Database != "", "postgres.database is required")
+	check(oneOf(c.Postgres.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+		"postgres.sslmode: %q is not a valid sslmode", c.Postgres.SSLMode)
+	errs = append(errs, c.Postgres.Pool.validate("postgres.pool")...)
+
+	check(c.MySQL.Host != "", "mysql.host is required")
+	check(validPort(c.MySQL.Port), "mysql.port: %d is not a valid port", c.MySQL.Port)
+	check(c.MySQL.User != "", "mysql.user is required")
+	check(c.MySQL.Password != "", "mysql.password is required (set MYSQL_PASSWORD)")
+	check(c.MySQL.Database != "", "mysql.database is required")
+	check(oneOf(c.MySQL.TLS, "false", "true", "skip-verify", "preferred"),
+		"mysql.tls: %q must be one of false, true, skip-verify, preferred", c.MySQL.TLS)
+	errs = append(errs, c.MySQL.Pool.validate("mysql.pool")...)
+
+	check(c.Mongo.Host != "", "mongo.host is required")
+	check(validPort(c.Mongo.Port), "mongo.port: %d is not a valid port", c.Mongo.Port)
+	check(c.Mongo.Database != "", "mongo.database is required")
+	check((c.Mongo.User == "") == (c.Mongo.Password == ""), "mongo.user and mongo.password must be set together")
+	check(c.Mongo.MaxPoolSize > 0, "mongo.max_pool_size must be positive")
+	check(c.Mongo.ConnectTimeout > 0, "mongo.connect_timeout must be positive")
+
+	check(oneOf(strings.ToLower(c.Logging.Level), "debug", "info", "warn", "error"),
+		"logging.level: %q must be one of debug, info, warn, error", c.Logging.Level)
+	check(oneOf(strings.ToLower(c.Logging.Format), "json", "text"),
+		"logging.format: %q must be json or text", c.Logging.Format)
+
+	check(oneOf(c.Tracing.Exporter, "otlp", "stdout", "none"),
+		"tracing.exporter: %q must be one of otlp, stdout, none", c.Tracing.Exporter)
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+
+	check(c.Inventory.LowStockInterval > 0, "inventory.low_stock_interval must be positive")
+	check(c.Inventory.LowStockWebhook == "" || strings.HasPrefix(c.Inventory.LowStockWebhook, "http://") || strings.HasPrefix(c.Inventory.LowStockWebhook, "https://"),
+		"inventory.low_stock_webhook: %q must be an http(s) URL", c.Inventory.LowStockWebhook)
+	check(c.Inventory.LeadTimeDays >= 0, "inventory.lead_time_days must not be negative")
+	check(c.Inventory.CoverDays >= 0, "inventory.cover_days must not be negative")
+	check(c.Inventory.VelocityWindowDays > 0, "inventory.velocity_window_days must be positive")
+
+	check(c.Idempotency.TTL > 0, "idempotency.ttl must be positive")
+
+	for _, p := range c.Moderation.BlockedPatterns {
+		_, err := regexp.Compile(p)
+		check(err == nil, "moderation.blocked_patterns: %q is not a valid regular expression: %v", p, err)
+	}
+	check(c.Moderation.MaxLinks >= 0, "moderation.max_links must not be negative")
+	check(c.Moderation.ReportThreshold > 0, "moderation.report_threshold must be positive")
+
+	if c.Capture.Enabled {
+		check(c.Capture.Dir != "", "capture.dir is required when capture is enabled")
+	}
+	check(oneOf(c.Capture.Format, "yaml", "jsonl"), "capture.format: %q must be yaml or jsonl", c.Capture.Format)
+	check(c.Capture.SampleRate >= 0 && c.Capture.SampleRate <= 1, "capture.sample_rate must be between 0 and 1")
+	check(c.Capture.MaxBodyBytes > 0, "capture.max_body_bytes must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %w", joinErrors(errs))
+	}
+	return nil
+}
+
+func (p PoolConfig) validate(prefix string) []error {
+	var errs []error
+	if p.MaxOpenConns < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_open_conns must be at least 1", prefix))
+	}
+	if p.MaxIdleConns < 0 || p.MaxIdleConns > p.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("%s.max_idle_conns must be between 0 and max_open_conns (%d)", prefix, p.MaxOpenConns))
+	}
+	if p.ConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("%s.conn_max_lifetime must not be negative", prefix))
+	}
+	if p.ConnectTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.connect_timeout must be positive", prefix))
+	}
+	return errs
+}
+
+// Print writes the effective configuration as YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// RunCommand implements the `config print` subcommand
+func RunCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: config print")
+	}
+	return cfg.Print(out)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port < 65536
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors joins errors one per line, indented under the summary
+func joinErrors(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "\n  "))
+}
+
+// flag.Value adapters that write straight into Config fields
+
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+
+type secretValue struct{ p *Secret }
+
+func (v secretValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+func (v secretValue) Set(s string) error { *v.p = Secret(s); return nil }
+
+type intValue struct{ p *int }
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.p)
+}
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v.p = n
+	return nil
+}
+
+type floatValue struct{ p *float64 }
+
+func (v floatValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v.p, 'g', -1, 64)
+}
+func (v floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v.p = f
+	return nil
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.p)
+}
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v.p = b
+	return nil
+}
+
+// listValue takes a comma-separated list
+type listValue struct{ p *[]string }
+
+func (v listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+func (v listValue) Set(s string) error {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v.p = items
+	return nil
+}
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration (e.g. 30s, 5m)", s)
+	}
+	*v.p = d
+	return nil
+}
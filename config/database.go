@@ -2,16 +2,25 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
-	"fmt"
 	"log"
-	"os"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"sample-application/metrics"
+	"sample-application/tracing"
+
+	"github.com/XSAM/otelsql"
+	"github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 )
 
 var (
@@ -21,86 +30,97 @@ var (
 	MongoDBCtx context.Context
 )
 
-func InitDatabases() {
-	initPostgres()
-	initMySQL()
-	initMongoDB()
-}
+// App is the configuration the databases were initialized with
+var App *Config
 
-func initPostgres() {
-	postgresHost := getEnv("POSTGRES_HOST", "localhost")
-	postgresPort := getEnv("POSTGRES_PORT", "5432")
-	postgresUser := getEnv("POSTGRES_USER", "postgres")
-	postgresPass := getEnv("POSTGRES_PASSWORD", "postgres")
-	postgresDB := getEnv("POSTGRES_DB", "ecommerce")
+func InitDatabases(cfg *Config) {
+	App = cfg
+	initPostgres(cfg.Postgres)
+	initMySQL(cfg.MySQL)
+	initMongoDB(cfg.Mongo)
+}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		postgresHost, postgresPort, postgresUser, postgresPass, postgresDB)
+func initPostgres(cfg PostgresConfig) {
+	query := url.Values{}
+	query.Set("sslmode", cfg.SSLMode)
+	// lib/pq takes whole seconds and treats 0 as no timeout, so round up
+	query.Set("connect_timeout", strconv.Itoa(int(math.Ceil(cfg.Pool.ConnectTimeout.Seconds()))))
+	dsn := (&url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password.Value()),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Database,
+		RawQuery: query.Encode(),
+	}).String()
 
 	var err error
-	PostgresDB, err = sql.Open("postgres", dsn)
+	PostgresDB, err = otelsql.Open("postgres", dsn, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
 	}
 
-	PostgresDB.SetMaxOpenConns(25)
-	PostgresDB.SetMaxIdleConns(5)
-	PostgresDB.SetConnMaxLifetime(5 * time.Minute)
+	configurePool(PostgresDB, cfg.Pool)
 
-	if err = PostgresDB.Ping(); err != nil {
+	if err = pingSQL(PostgresDB, cfg.Pool.ConnectTimeout); err != nil {
 		log.Fatalf("Failed to ping PostgreSQL: %v", err)
 	}
 
 	log.Println("Connected to PostgreSQL")
-	createPostgresTables()
 }
 
-func initMySQL() {
-	mysqlHost := getEnv("MYSQL_HOST", "localhost")
-	mysqlPort := getEnv("MYSQL_PORT", "3306")
-	mysqlUser := getEnv("MYSQL_USER", "root")
-	mysqlPass := getEnv("MYSQL_PASSWORD", "root")
-	mysqlDB := getEnv("MYSQL_DB", "ecommerce")
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-		mysqlUser, mysqlPass, mysqlHost, mysqlPort, mysqlDB)
+func initMySQL(cfg MySQLConfig) {
+	mysqlCfg := mysql.NewConfig()
+	mysqlCfg.User = cfg.User
+	mysqlCfg.Passwd = cfg.Password.Value()
+	mysqlCfg.Net = "tcp"
+	mysqlCfg.Addr = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	mysqlCfg.DBName = cfg.Database
+	mysqlCfg.ParseTime = true
+	// Keep the session in UTC, like the driver's time.Time conversions, so
+	// TIMESTAMP columns compare correctly with timestamps passed in
+	mysqlCfg.Loc = time.UTC
+	mysqlCfg.Params = map[string]string{"time_zone": "'+00:00'"}
+	mysqlCfg.TLSConfig = cfg.TLS
+	mysqlCfg.Timeout = cfg.Pool.ConnectTimeout
 
 	var err error
-	MySQLDB, err = sql.Open("mysql", dsn)
+	MySQLDB, err = otelsql.Open("mysql", mysqlCfg.FormatDSN(), otelsql.WithAttributes(semconv.DBSystemMySQL))
 	if err != nil {
 		log.Fatalf("Failed to connect to MySQL: %v", err)
 	}
 
-	MySQLDB.SetMaxOpenConns(25)
-	MySQLDB.SetMaxIdleConns(5)
-	MySQLDB.SetConnMaxLifetime(5 * time.Minute)
+	configurePool(MySQLDB, cfg.Pool)
 
-	if err = MySQLDB.Ping(); err != nil {
+	if err = pingSQL(MySQLDB, cfg.Pool.ConnectTimeout); err != nil {
 		log.Fatalf("Failed to ping MySQL: %v", err)
 	}
 
 	log.Println("Connected to MySQL")
-	createMySQLTables()
 }
 
-func initMongoDB() {
-	mongoHost := getEnv("MONGO_HOST", "localhost")
-	mongoPort := getEnv("MONGO_PORT", "27017")
-	mongoUser := getEnv("MONGO_USER", "")
-	mongoPass := getEnv("MONGO_PASSWORD", "")
-
-	var uri string
-	if mongoUser != "" && mongoPass != "" {
-		uri = fmt.Sprintf("mongodb://%s:%s@%s:%s", mongoUser, mongoPass, mongoHost, mongoPort)
-	} else {
-		uri = fmt.Sprintf("mongodb://%s:%s", mongoHost, mongoPort)
+func initMongoDB(cfg MongoConfig) {
+	uri := &url.URL{
+		Scheme: "mongodb",
+		Host:   net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+	}
+	if cfg.User != "" {
+		uri.User = url.UserPassword(cfg.User, cfg.Password.Value())
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 
+	opts := options.Client().
+		ApplyURI(uri.String()).
+		SetMaxPoolSize(uint64(cfg.MaxPoolSize)).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetMonitor(combineMonitors(metrics.MongoMonitor(), tracing.MongoMonitor()))
+	if cfg.TLS {
+		opts.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
 	var err error
-	MongoDB, err = mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	MongoDB, err = mongo.Connect(ctx, opts)
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
@@ -113,82 +133,44 @@ func initMongoDB() {
 	log.Println("Connected to MongoDB")
 }
 
-func createPostgresTables() {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password VARCHAR(255) NOT NULL,
-			address TEXT,
-			phone VARCHAR(50),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS orders (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER REFERENCES users(id),
-			total_amount DECIMAL(10, 2) NOT NULL,
-			status VARCHAR(50) DEFAULT 'pending',
-			payment_method VARCHAR(50),
-			shipping_address TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS order_items (
-			id SERIAL PRIMARY KEY,
-			order_id INTEGER REFERENCES orders(id),
-			product_id VARCHAR(100) NOT NULL,
-			quantity INTEGER NOT NULL,
-			price DECIMAL(10, 2) NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS cart (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER REFERENCES users(id),
-			product_id VARCHAR(100) NOT NULL,
-			quantity INTEGER NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-	}
-
-	for _, query := range queries {
-		if _, err := PostgresDB.Exec(query); err != nil {
-			log.Printf("Error creating PostgreSQL table: %v", err)
-		}
-	}
-	log.Println("PostgreSQL tables created/verified")
+func configurePool(db *sql.DB, cfg PoolConfig) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 }
 
-func createMySQLTables() {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS inventory (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			product_id VARCHAR(100) UNIQUE NOT NULL,
-			quantity INT NOT NULL DEFAULT 0,
-			warehouse_location VARCHAR(255),
-			last_restocked TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			low_stock_threshold INT DEFAULT 10,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS sales_analytics (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			product_id VARCHAR(100) NOT NULL,
-			quantity_sold INT NOT NULL,
-			revenue DECIMAL(10, 2) NOT NULL,
-			sale_date DATE NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-	}
+func pingSQL(db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
 
-	for _, query := range queries {
-		if _, err := MySQLDB.Exec(query); err != nil {
-			log.Printf("Error creating MySQL table: %v", err)
-		}
+// combineMonitors fans MongoDB command events out to several monitors, since
+// the driver only accepts one
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
 	}
-	log.Println("MySQL tables created/verified")
 }
 
 func CloseDatabases() {
@@ -208,14 +190,6 @@ func CloseDatabases() {
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 func GetMongoDatabase() *mongo.Database {
-	dbName := getEnv("MONGO_DB", "ecommerce")
-	return MongoDB.Database(dbName)
+	return MongoDB.Database(App.Mongo.Database)
 }
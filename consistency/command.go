@@ -0,0 +1,105 @@
+package consistency
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// policyFlag collects repeated -policy check=policy flags
+type policyFlag map[string]string
+
+func (p policyFlag) String() string { return "" }
+
+func (p policyFlag) Set(value string) error {
+	name, policy, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("want check=policy, got %q", value)
+	}
+	p[strings.TrimSpace(name)] = strings.TrimSpace(policy)
+	return nil
+}
+
+// RunCommand implements `consistency check [-fix] [-policy check=policy]...`.
+// It fails while orphans remain, so it can gate a deployment or cron job.
+func RunCommand(ctx context.Context, args []string, c *Checker, out io.Writer) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: consistency check [-fix] [-policy check=policy]...")
+	}
+	fs := flag.NewFlagSet("consistency check", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fix := fs.Bool("fix", false, "repair the orphans found")
+	policies := policyFlag{}
+	fs.Var(policies, "policy", "repair policy for a check, as check=policy (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: consistency check [-fix] [-policy check=policy]...")
+		fs.PrintDefaults()
+		fmt.Fprintln(out, "\nchecks and their policies, the default first:")
+		for _, ch := range checks {
+			fmt.Fprintf(out, "  %-28s %s\n", ch.name, strings.Join(ch.policies, ", "))
+		}
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if len(policies) > 0 && !*fix {
+		return fmt.Errorf("-policy only applies with -fix")
+	}
+
+	var report Report
+	var err error
+	if *fix {
+		report, err = c.Repair(ctx, policies)
+	} else {
+		report, err = c.Check(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	WriteReport(out, report)
+	if n := report.Remaining(); n > 0 {
+		return fmt.Errorf("%d orphaned references remain", n)
+	}
+	return nil
+}
+
+// WriteReport prints a table of the checks followed by example orphans
+func WriteReport(w io.Writer, report Report) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tORPHANS\tPOLICY\tREPAIRED")
+	for _, r := range report.Results {
+		policy := r.Policy
+		if policy == "" {
+			policy = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\n", r.Check, r.Orphans, policy, r.Repaired)
+	}
+	tw.Flush()
+
+	for _, r := range report.Results {
+		if r.Orphans == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s: %d %s\n", r.Check, r.Orphans, r.Description)
+		for _, o := range r.Examples {
+			fmt.Fprintf(w, "  %s -> missing %s\n", o.ID, o.Missing)
+		}
+		if more := r.Orphans - len(r.Examples); more > 0 {
+			fmt.Fprintf(w, "  ... and %d more\n", more)
+		}
+	}
+	if report.RatingsRecomputed > 0 {
+		fmt.Fprintf(w, "\nrecomputed ratings for %d products\n", report.RatingsRecomputed)
+	}
+	fmt.Fprintf(w, "\n%d orphaned references, %d repaired\n", report.Orphans, report.Repaired)
+}
@@ -0,0 +1,373 @@
+package consistency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repair policies. Each check allows a subset; the first it lists is used
+// when none is chosen.
+const (
+	// PolicyKeep leaves orphans in place, so they are only reported
+	PolicyKeep = "keep"
+	// PolicyDelete removes the records holding the dangling reference
+	PolicyDelete = "delete"
+	// PolicyAnonymize detaches reviews from their deleted author, keeping
+	// the review and its rating
+	PolicyAnonymize = "anonymize"
+)
+
+// exampleLimit caps the orphans listed per check in a report
+const exampleLimit = 10
+
+// Orphan is a record referring to a product or user that doesn't exist.
+// ID identifies the record: a cart item or order item ID, a review or
+// wishlist entry ObjectID, or the product ID of an inventory row.
+type Orphan struct {
+	ID      string `json:"id"`
+	Missing string `json:"missing"`
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Check       string   `json:"check"`
+	Description string   `json:"description"`
+	Orphans     int      `json:"orphans"`
+	Examples    []Orphan `json:"examples"`
+	// Policy is set when repairing, and Repaired counts the orphans its
+	// policy resolved
+	Policy   string `json:"policy,omitempty"`
+	Repaired int    `json:"repaired"`
+}
+
+// Report is the outcome of every check
+type Report struct {
+	Results  []Result `json:"results"`
+	Orphans  int      `json:"orphans"`
+	Repaired int      `json:"repaired"`
+	// RatingsRecomputed is the number of products whose rating aggregates
+	// were recomputed after reviews were deleted
+	RatingsRecomputed int `json:"ratings_recomputed"`
+}
+
+// Remaining is the number of orphans left after the run
+func (r Report) Remaining() int {
+	return r.Orphans - r.Repaired
+}
+
+// Checker finds references across the three stores that point at deleted
+// products or users. Product and user IDs are never reused, so an orphan
+// stays an orphan and can be repaired safely after it was found.
+type Checker struct {
+	postgres      *sql.DB
+	mysql         *sql.DB
+	mongo         *mongo.Database
+	repairRatings func(ctx context.Context) (int, error)
+}
+
+// New creates a Checker. repairRatings recomputes product rating
+// aggregates and is called after reviews are deleted.
+func New(postgres, mysql *sql.DB, mongo *mongo.Database, repairRatings func(ctx context.Context) (int, error)) *Checker {
+	return &Checker{postgres: postgres, mysql: mysql, mongo: mongo, repairRatings: repairRatings}
+}
+
+// target is the kind of record a check's references point at
+type target int
+
+const (
+	products target = iota
+	users
+)
+
+type check struct {
+	name, description string
+	target            target
+	// policies the check can be repaired with, the default first
+	policies []string
+	// scan lists every reference the check covers
+	scan func(ctx context.Context, c *Checker) ([]Orphan, error)
+	// repair applies a policy other than keep to the orphans found
+	repair func(ctx context.Context, c *Checker, policy string, orphans []Orphan) (int, error)
+}
+
+var checks = []check{
+	{
+		name:        "cart_missing_product",
+		description: "cart items for products that no longer exist",
+		target:      products,
+		policies:    []string{PolicyDelete, PolicyKeep},
+		scan:        scanSQL("postgres", `SELECT id, product_id FROM cart`),
+		repair:      deleteCartItems,
+	},
+	{
+		name:        "order_item_missing_product",
+		description: "order items for products that no longer exist, kept as order history",
+		target:      products,
+		policies:    []string{PolicyKeep},
+		scan:        scanSQL("postgres", `SELECT id, product_id FROM order_items`),
+	},
+	{
+		name:        "inventory_missing_product",
+		description: "inventory and warehouse stock for products that no longer exist",
+		target:      products,
+		policies:    []string{PolicyDelete, PolicyKeep},
+		scan: scanSQL("mysql", `SELECT product_id, product_id FROM inventory
+			UNION SELECT product_id, product_id FROM warehouse_stock`),
+		repair: deleteInventory,
+	},
+	{
+		name:        "review_missing_product",
+		description: "reviews of products that no longer exist",
+		target:      products,
+		policies:    []string{PolicyDelete, PolicyKeep},
+		scan:        scanMongo("reviews", "product_id"),
+		repair:      deleteReviews,
+	},
+	{
+		name:        "review_missing_user",
+		description: "reviews by users who no longer exist",
+		target:      users,
+		policies:    []string{PolicyAnonymize, PolicyDelete, PolicyKeep},
+		scan:        scanMongo("reviews", "user_id"),
+		repair:      repairReviewAuthors,
+	},
+	{
+		name:        "wishlist_missing_product",
+		description: "wishlist entries for products that no longer exist",
+		target:      products,
+		policies:    []string{PolicyDelete, PolicyKeep},
+		scan:        scanMongo("wishlist", "product_id"),
+		repair:      deleteWishlistEntries,
+	},
+	{
+		name:        "wishlist_missing_user",
+		description: "wishlist entries of users who no longer exist",
+		target:      users,
+		policies:    []string{PolicyDelete, PolicyKeep},
+		scan:        scanMongo("wishlist", "user_id"),
+		repair:      deleteWishlistEntries,
+	},
+}
+
+// policiesOf lists the policies a check allows, the default first
+func policiesOf(name string) ([]string, bool) {
+	for _, ch := range checks {
+		if ch.name == name {
+			return ch.policies, true
+		}
+	}
+	return nil, false
+}
+
+// ValidatePolicies rejects unknown checks and policies a check doesn't
+// allow
+func ValidatePolicies(policies map[string]string) error {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		allowed, ok := policiesOf(name)
+		if !ok {
+			return fmt.Errorf("unknown check %q", name)
+		}
+		if !contains(allowed, policies[name]) {
+			return fmt.Errorf("check %s allows policies %s, not %q", name, strings.Join(allowed, ", "), policies[name])
+		}
+	}
+	return nil
+}
+
+// Check reports orphaned references without changing anything
+func (c *Checker) Check(ctx context.Context) (Report, error) {
+	return c.run(ctx, nil, false)
+}
+
+// Repair reports orphaned references and repairs them, using each check's
+// default policy unless policies names another
+func (c *Checker) Repair(ctx context.Context, policies map[string]string) (Report, error) {
+	if err := ValidatePolicies(policies); err != nil {
+		return Report{}, err
+	}
+	return c.run(ctx, policies, true)
+}
+
+func (c *Checker) run(ctx context.Context, policies map[string]string, repair bool) (Report, error) {
+	// References are listed before the products and users they point at,
+	// so one created mid-check can't be mistaken for an orphan
+	scanned := make([][]Orphan, len(checks))
+	for i, ch := range checks {
+		refs, err := ch.scan(ctx, c)
+		if err != nil {
+			return Report{}, fmt.Errorf("%s: %w", ch.name, err)
+		}
+		scanned[i] = refs
+	}
+	productIDs, err := c.productIDs(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("loading products: %w", err)
+	}
+	userIDs, err := c.userIDs(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("loading users: %w", err)
+	}
+
+	var report Report
+	ratingsStale := false
+	for i, ch := range checks {
+		existing := productIDs
+		if ch.target == users {
+			existing = userIDs
+		}
+		var orphans []Orphan
+		for _, ref := range scanned[i] {
+			if !existing[ref.Missing] {
+				orphans = append(orphans, ref)
+			}
+		}
+
+		result := Result{Check: ch.name, Description: ch.description, Orphans: len(orphans), Examples: orphans}
+		if len(orphans) > exampleLimit {
+			result.Examples = orphans[:exampleLimit]
+		}
+		if result.Examples == nil {
+			result.Examples = []Orphan{}
+		}
+		if repair {
+			result.Policy = ch.policies[0]
+			if p, ok := policies[ch.name]; ok {
+				result.Policy = p
+			}
+			if result.Policy != PolicyKeep && len(orphans) > 0 {
+				n, err := ch.repair(ctx, c, result.Policy, orphans)
+				if err != nil {
+					return Report{}, fmt.Errorf("repairing %s: %w", ch.name, err)
+				}
+				result.Repaired = n
+				// Reviews of missing products count towards no product
+				ratingsStale = ratingsStale || ch.name == "review_missing_user" && result.Policy == PolicyDelete
+			}
+		}
+		report.Results = append(report.Results, result)
+		report.Orphans += result.Orphans
+		report.Repaired += result.Repaired
+	}
+
+	if ratingsStale && c.repairRatings != nil {
+		n, err := c.repairRatings(ctx)
+		if err != nil {
+			return Report{}, fmt.Errorf("recomputing ratings: %w", err)
+		}
+		report.RatingsRecomputed = n
+	}
+	return report, nil
+}
+
+// productIDs is the set of existing product IDs, as hex strings
+func (c *Checker) productIDs(ctx context.Context) (map[string]bool, error) {
+	cursor, err := c.mongo.Collection("products").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := map[string]bool{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids[idString(doc.ID)] = true
+	}
+	return ids, cursor.Err()
+}
+
+// userIDs is the set of existing user IDs, as decimal strings
+func (c *Checker) userIDs(ctx context.Context) (map[string]bool, error) {
+	rows, err := c.postgres.QueryContext(ctx, `SELECT id FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[strconv.Itoa(id)] = true
+	}
+	return ids, rows.Err()
+}
+
+// scanSQL lists references from a query returning (record ID, referenced ID)
+func scanSQL(store, query string) func(ctx context.Context, c *Checker) ([]Orphan, error) {
+	return func(ctx context.Context, c *Checker) ([]Orphan, error) {
+		db := c.postgres
+		if store == "mysql" {
+			db = c.mysql
+		}
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var refs []Orphan
+		for rows.Next() {
+			var ref Orphan
+			if err := rows.Scan(&ref.ID, &ref.Missing); err != nil {
+				return nil, err
+			}
+			refs = append(refs, ref)
+		}
+		return refs, rows.Err()
+	}
+}
+
+// scanMongo lists the field references of a collection's documents.
+// Anonymized reviews have a negative user_id and refer to nobody.
+func scanMongo(collection, field string) func(ctx context.Context, c *Checker) ([]Orphan, error) {
+	return func(ctx context.Context, c *Checker) ([]Orphan, error) {
+		cursor, err := c.mongo.Collection(collection).Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1, field: 1}))
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+
+		var refs []Orphan
+		for cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				return nil, err
+			}
+			ref := Orphan{ID: idString(doc["_id"]), Missing: idString(doc[field])}
+			if n, err := strconv.Atoi(ref.Missing); err == nil && n < 0 {
+				continue
+			}
+			refs = append(refs, ref)
+		}
+		return refs, cursor.Err()
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
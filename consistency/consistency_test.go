@@ -0,0 +1,136 @@
+package consistency
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestChecks(t *testing.T) {
+	names := map[string]bool{}
+	for _, ch := range checks {
+		if names[ch.name] {
+			t.Errorf("check %s is defined twice", ch.name)
+		}
+		names[ch.name] = true
+		if len(ch.policies) == 0 || ch.scan == nil {
+			t.Errorf("check %s has no policies or no scan", ch.name)
+		}
+		for _, policy := range ch.policies {
+			if policy != PolicyKeep && ch.repair == nil {
+				t.Errorf("check %s allows %s but can't repair", ch.name, policy)
+			}
+		}
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	tests := []struct {
+		policies map[string]string
+		err      string
+	}{
+		{policies: nil},
+		{policies: map[string]string{"review_missing_user": PolicyDelete, "cart_missing_product": PolicyKeep}},
+		{policies: map[string]string{"no_such_check": PolicyKeep}, err: `unknown check "no_such_check"`},
+		{policies: map[string]string{"order_item_missing_product": PolicyDelete}, err: `check order_item_missing_product allows policies keep, not "delete"`},
+		{policies: map[string]string{"wishlist_missing_user": PolicyAnonymize}, err: `not "anonymize"`},
+	}
+	for _, tt := range tests {
+		err := ValidatePolicies(tt.policies)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("ValidatePolicies(%v) = %v, want %q", tt.policies, err, tt.err)
+		}
+	}
+}
+
+func TestRunCommandArguments(t *testing.T) {
+	// Each of these fails before the checker is used
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: nil, err: "usage: consistency check"},
+		{args: []string{"repair"}, err: "usage: consistency check"},
+		{args: []string{"check", "extra"}, err: `unexpected argument "extra"`},
+		{args: []string{"check", "-policy", "cart_missing_product=keep"}, err: "-policy only applies with -fix"},
+		{args: []string{"check", "-fix", "-policy", "cart_missing_product"}, err: "want check=policy"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := RunCommand(context.Background(), tt.args, nil, &out)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("RunCommand(%q) = %v, want %q", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestPolicyFlag(t *testing.T) {
+	p := policyFlag{}
+	for _, value := range []string{"cart_missing_product=keep", " review_missing_user = anonymize "} {
+		if err := p.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := policyFlag{"cart_missing_product": PolicyKeep, "review_missing_user": PolicyAnonymize}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("policies %v, want %v", p, want)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := Report{
+		Results: []Result{
+			{Check: "cart_missing_product", Description: "cart items for products that no longer exist", Orphans: 12,
+				Examples: []Orphan{{ID: "1", Missing: "p1"}, {ID: "2", Missing: "p2"}}, Policy: PolicyDelete, Repaired: 12},
+			{Check: "order_item_missing_product", Examples: []Orphan{}},
+		},
+		Orphans:           12,
+		Repaired:          12,
+		RatingsRecomputed: 3,
+	}
+	var out bytes.Buffer
+	WriteReport(&out, report)
+	for _, want := range []string{
+		"cart_missing_product        12       delete  12",
+		"order_item_missing_product  0        -       0",
+		"cart_missing_product: 12 cart items for products that no longer exist",
+		"  1 -> missing p1",
+		"  ... and 10 more",
+		"recomputed ratings for 3 products",
+		"12 orphaned references, 12 repaired",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, out.String())
+		}
+	}
+	if report.Remaining() != 0 {
+		t.Errorf("Remaining() = %d, want 0", report.Remaining())
+	}
+}
+
+func TestIDs(t *testing.T) {
+	oid := primitive.NewObjectID()
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{oid, oid.Hex()},
+		{"prod84", "prod84"},
+		{int32(7), "7"},
+		{nil, ""},
+	} {
+		if got := idString(tt.value); got != tt.want {
+			t.Errorf("idString(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	got := objectIDs(recordIDs([]Orphan{{ID: oid.Hex()}, {ID: "prod84"}}))
+	if want := (bson.A{oid, "prod84"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("objectIDs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package consistency
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// idString formats a Mongo field holding an ObjectID, a string or a number
+func idString(v interface{}) string {
+	switch v := v.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func recordIDs(orphans []Orphan) []string {
+	ids := make([]string, len(orphans))
+	for i, o := range orphans {
+		ids[i] = o.ID
+	}
+	return ids
+}
+
+// objectIDs converts record IDs back to ObjectIDs, keeping IDs stored as
+// plain strings as they are
+func objectIDs(ids []string) bson.A {
+	out := make(bson.A, len(ids))
+	for i, id := range ids {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			out[i] = oid
+		} else {
+			out[i] = id
+		}
+	}
+	return out
+}
+
+func deleteCartItems(ctx context.Context, c *Checker, _ string, orphans []Orphan) (int, error) {
+	ids := make([]int64, 0, len(orphans))
+	for _, o := range orphans {
+		id, err := strconv.ParseInt(o.ID, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if _, err := c.postgres.ExecContext(ctx, `DELETE FROM cart WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	return len(orphans), nil
+}
+
+// deleteInventory removes a missing product's inventory row, its stock at
+// each warehouse, its ledger and its transfers. Sales history is kept for
+// revenue reporting.
+func deleteInventory(ctx context.Context, c *Checker, _ string, orphans []Orphan) (int, error) {
+	ids := recordIDs(orphans)
+	placeholders := "?" + strings.Repeat(", ?", len(ids)-1)
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	tx, err := c.mysql.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"stock_movements", "stock_transfers", "warehouse_stock", "inventory"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE product_id IN (`+placeholders+`)`, args...); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// deleteReviews removes reviews along with their votes and reports
+func deleteReviews(ctx context.Context, c *Checker, _ string, orphans []Orphan) (int, error) {
+	ids := recordIDs(orphans)
+	if _, err := c.mongo.Collection("reviews").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(ids)}}); err != nil {
+		return 0, err
+	}
+	for _, collection := range []string{"review_votes", "review_reports"} {
+		if _, err := c.mongo.Collection(collection).DeleteMany(ctx, bson.M{"review_id": bson.M{"$in": ids}}); err != nil {
+			return 0, err
+		}
+	}
+	return len(orphans), nil
+}
+
+func repairReviewAuthors(ctx context.Context, c *Checker, policy string, orphans []Orphan) (int, error) {
+	if policy == PolicyDelete {
+		return deleteReviews(ctx, c, policy, orphans)
+	}
+	anonymized := map[int]bool{}
+	repaired := 0
+	for _, o := range orphans {
+		userID, err := strconv.Atoi(o.Missing)
+		if err != nil {
+			// Without a usable user_id there is nobody to detach
+			continue
+		}
+		if !anonymized[userID] {
+			if _, err := AnonymizeUser(ctx, c.mongo, userID); err != nil {
+				return repaired, err
+			}
+			anonymized[userID] = true
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// AnonymizeUser detaches a user's reviews, review votes and review reports
+// from them by replacing their user_id with a random negative pseudonym,
+// shared by all of that user's records so uniqueness per review still
+// holds. Reviews keep their ratings and helpfulness totals, but can no
+// longer be traced back to the user or edited. It returns the number of
+// reviews changed.
+func AnonymizeUser(ctx context.Context, db *mongo.Database, userID int) (int, error) {
+	pseudonym := -1 - rand.Intn(math.MaxInt32-1)
+	filter := bson.M{"user_id": userID}
+	update := bson.M{"$set": bson.M{"user_id": pseudonym}}
+	for _, collection := range []string{"review_votes", "review_reports"} {
+		if _, err := db.Collection(collection).UpdateMany(ctx, filter, update); err != nil {
+			return 0, err
+		}
+	}
+	result, err := db.Collection("reviews").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+func deleteWishlistEntries(ctx context.Context, c *Checker, _ string, orphans []Orphan) (int, error) {
+	if _, err := c.mongo.Collection("wishlist").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(recordIDs(orphans))}}); err != nil {
+		return 0, err
+	}
+	return len(orphans), nil
+}
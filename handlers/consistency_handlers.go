@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"sample-application/config"
+	"sample-application/consistency"
+)
+
+// NewConsistencyChecker checks the references between the configured
+// databases
+func NewConsistencyChecker() *consistency.Checker {
+	return consistency.New(config.PostgresDB, config.MySQLDB, config.GetMongoDatabase(), RepairRatings)
+}
+
+// Consistency Handlers (all stores)
+func GetConsistencyReport(w http.ResponseWriter, r *http.Request) {
+	report, err := NewConsistencyChecker().Check(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// RepairConsistency repairs orphaned references. The body may choose a
+// policy per check, e.g. {"policies": {"review_missing_user": "delete"}};
+// checks it leaves out use their default policy.
+func RepairConsistency(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Policies map[string]string `json:"policies"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := consistency.ValidatePolicies(data.Policies); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := NewConsistencyChecker().Repair(r.Context(), data.Policies)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
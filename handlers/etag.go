@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Optimistic concurrency: versioned resources carry a counter that every
+// write increments. GETs expose it as a strong ETag, and updates must send
+// it back in If-Match so concurrent edits fail instead of overwriting.
+
+// anyVersion is the expected version for "If-Match: *"
+const anyVersion = 0
+
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// notModified sets the ETag header for version and, when the request's
+// If-None-Match already names it, writes 304 Not Modified and returns true
+func notModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	tag := versionETag(version)
+	w.Header().Set("ETag", tag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		// If-None-Match uses weak comparison
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch returns the version named by the If-Match header, or
+// anyVersion for "*". Without the header it writes 428 Precondition Required
+// and returns false. Tags that aren't one of our versions yield -1, which
+// never matches.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		http.Error(w, "If-Match header is required; fetch the resource to get its ETag", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	if header == "*" {
+		return anyVersion, true
+	}
+	// If-Match uses strong comparison, so weak tags can't match
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || !strings.HasPrefix(header, `"`) || version < 1 {
+		return -1, true
+	}
+	return version, true
+}
+
+func versionMatches(expected, current int) bool {
+	return expected == anyVersion || expected == current
+}
+
+func preconditionFailed(w http.ResponseWriter) {
+	http.Error(w, "Resource has been modified; fetch it again and retry with the new ETag", http.StatusPreconditionFailed)
+}
+
+// errVersionMismatch means a conditional update found the resource at a
+// different version than If-Match expected
+var errVersionMismatch = errors.New("version mismatch")
+
+// updateVersioned applies $set to a MongoDB document if it is still at the
+// expected version, incrementing the version, and returns the new version.
+// It returns mongo.ErrNoDocuments when the document doesn't exist.
+func updateVersioned(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, expected int, set interface{}) (int, error) {
+	filter := bson.M{"_id": id}
+	if expected != anyVersion {
+		filter["version"] = expected
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"version": 1})
+
+	var updated struct {
+		Version int `bson:"version"`
+	}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != mongo.ErrNoDocuments {
+		return updated.Version, err
+	}
+	// Tell a stale version apart from a missing document
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, mongo.ErrNoDocuments
+	}
+	return 0, errVersionMismatch
+}
+
+// writeConditionalMiss responds to a conditional SQL update that matched no
+// rows: 404 if the row is gone, otherwise 412
+func writeConditionalMiss(w http.ResponseWriter, r *http.Request, db *sql.DB, existsQuery string, id interface{}, notFound string) {
+	var exists bool
+	if err := db.QueryRowContext(r.Context(), existsQuery, id).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, notFound, http.StatusNotFound)
+		return
+	}
+	preconditionFailed(w)
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"sample-application/config"
@@ -14,7 +18,7 @@ import (
 
 // Inventory Handlers (MySQL)
 func GetAllInventory(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.MySQLDB.Query(`SELECT id, product_id, quantity, warehouse_location, last_restocked, low_stock_threshold, created_at, updated_at FROM inventory LIMIT 100`)
+	rows, err := config.MySQLDB.QueryContext(r.Context(), `SELECT id, product_id, quantity, COALESCE(warehouse_location, ''), last_restocked, COALESCE(low_stock_threshold, 0), version, created_at, updated_at FROM inventory LIMIT 100`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -24,7 +28,7 @@ func GetAllInventory(w http.ResponseWriter, r *http.Request) {
 	inventory := []models.Inventory{}
 	for rows.Next() {
 		var item models.Inventory
-		err := rows.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.WarehouseLocation, &item.LastRestocked, &item.LowStockThreshold, &item.CreatedAt, &item.UpdatedAt)
+		err := rows.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.WarehouseLocation, &item.LastRestocked, &item.LowStockThreshold, &item.Version, &item.CreatedAt, &item.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -40,8 +44,8 @@ func GetInventoryByProduct(w http.ResponseWriter, r *http.Request) {
 	productID := vars["product_id"]
 
 	var item models.Inventory
-	query := `SELECT id, product_id, quantity, warehouse_location, last_restocked, low_stock_threshold, created_at, updated_at FROM inventory WHERE product_id = ?`
-	err := config.MySQLDB.QueryRow(query, productID).Scan(&item.ID, &item.ProductID, &item.Quantity, &item.WarehouseLocation, &item.LastRestocked, &item.LowStockThreshold, &item.CreatedAt, &item.UpdatedAt)
+	query := `SELECT id, product_id, quantity, COALESCE(warehouse_location, ''), last_restocked, COALESCE(low_stock_threshold, 0), version, created_at, updated_at FROM inventory WHERE product_id = ?`
+	err := config.MySQLDB.QueryRowContext(r.Context(), query, productID).Scan(&item.ID, &item.ProductID, &item.Quantity, &item.WarehouseLocation, &item.LastRestocked, &item.LowStockThreshold, &item.Version, &item.CreatedAt, &item.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "Inventory not found", http.StatusNotFound)
@@ -52,63 +56,185 @@ func GetInventoryByProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if notModified(w, r, item.Version) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(item)
 }
 
+// UpdateInventory sets the on-hand quantity of a product at one warehouse
+// (warehouse_id in the body, or the primary warehouse when omitted). Existing
+// inventory rows can only be updated with a matching If-Match; a product
+// with no inventory row yet is created without one.
 func UpdateInventory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["product_id"]
 
-	var item models.Inventory
+	var item struct {
+		models.Inventory
+		WarehouseID int    `json:"warehouse_id"`
+		Reason      string `json:"reason"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	query := `UPDATE inventory SET quantity = ?, warehouse_location = ?, updated_at = NOW() WHERE product_id = ?`
-	result, err := config.MySQLDB.Exec(query, item.Quantity, item.WarehouseLocation, productID)
+	tx, err := config.MySQLDB.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		// Insert if not exists
-		insertQuery := `INSERT INTO inventory (product_id, quantity, warehouse_location, last_restocked) VALUES (?, ?, ?, NOW())`
-		_, err := config.MySQLDB.Exec(insertQuery, productID, item.Quantity, item.WarehouseLocation)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	if !checkInventoryVersion(w, r, tx, productID) {
+		return
+	}
+
+	warehouseID, err := resolveWarehouse(r.Context(), tx, item.WarehouseID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Warehouse not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	movement := stockMovement{Type: MovementAdjustment, Reason: item.Reason, Actor: requestActor(r)}
+	if _, err := setWarehouseStock(r.Context(), tx, productID, warehouseID, item.Quantity, movement); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Insert if not exists; quantity is filled in by syncInventoryTotal
+	query := `INSERT INTO inventory (product_id, quantity, warehouse_location, last_restocked) VALUES (?, 0, ?, NOW())
+			  ON DUPLICATE KEY UPDATE warehouse_location = VALUES(warehouse_location), updated_at = NOW()`
+	if _, err := tx.ExecContext(r.Context(), query, productID, item.WarehouseLocation); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := syncInventoryTotal(r.Context(), tx, productID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	version, err := inventoryVersion(r.Context(), tx, productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Inventory updated successfully"})
 }
 
+// checkInventoryVersion locks the product's inventory row and checks it
+// against If-Match, which is required when the row exists. A product with
+// no inventory row yet has nothing to conflict with, but If-Match can't
+// match a missing row. It writes the error response and returns false when
+// the update must not go ahead.
+func checkInventoryVersion(w http.ResponseWriter, r *http.Request, tx *sql.Tx, productID string) bool {
+	var current int
+	err := tx.QueryRowContext(r.Context(), `SELECT version FROM inventory WHERE product_id = ? FOR UPDATE`, productID).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+		if r.Header.Get("If-Match") != "" {
+			preconditionFailed(w)
+			return false
+		}
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	default:
+		expected, ok := requireIfMatch(w, r)
+		if !ok {
+			return false
+		}
+		if !versionMatches(expected, current) {
+			preconditionFailed(w)
+			return false
+		}
+	}
+	return true
+}
+
+// inventoryVersion reads the version of the product's inventory row, for
+// the ETag of an update
+func inventoryVersion(ctx context.Context, tx *sql.Tx, productID string) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `SELECT version FROM inventory WHERE product_id = ?`, productID).Scan(&version)
+	return version, err
+}
+
+// RestockInventory adds stock at one warehouse (warehouse_id in the body, or
+// the primary warehouse when omitted). A product's first restock creates
+// its inventory and warehouse stock rows.
 func RestockInventory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["product_id"]
 
-	var data map[string]int
+	var data struct {
+		Quantity    int    `json:"quantity"`
+		WarehouseID int    `json:"warehouse_id"`
+		Reason      string `json:"reason"`
+		ReferenceID string `json:"reference_id"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if data.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
 
-	quantity := data["quantity"]
-	query := `UPDATE inventory SET quantity = quantity + ?, last_restocked = NOW(), updated_at = NOW() WHERE product_id = ?`
-	result, err := config.MySQLDB.Exec(query, quantity, productID)
+	tx, err := config.MySQLDB.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		http.Error(w, "Inventory not found", http.StatusNotFound)
+	// The first restock of a product creates its inventory row; its
+	// quantity is filled in by syncInventoryTotal below
+	query := `INSERT INTO inventory (product_id, quantity, last_restocked) VALUES (?, 0, NOW())
+		ON DUPLICATE KEY UPDATE last_restocked = NOW(), updated_at = NOW()`
+	if _, err := tx.ExecContext(r.Context(), query, productID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	warehouseID, err := resolveWarehouse(r.Context(), tx, data.WarehouseID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Warehouse not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	movement := stockMovement{Type: MovementRestock, Reason: data.Reason, Actor: requestActor(r), ReferenceID: data.ReferenceID}
+	if _, err := adjustWarehouseStock(r.Context(), tx, productID, warehouseID, data.Quantity, movement); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := syncInventoryTotal(r.Context(), tx, productID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -117,25 +243,108 @@ func RestockInventory(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetLowStockItems(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.MySQLDB.Query(`SELECT id, product_id, quantity, warehouse_location, last_restocked, low_stock_threshold, created_at, updated_at FROM inventory WHERE quantity <= low_stock_threshold`)
+	inventory, err := ListLowStockItems(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inventory)
+}
+
+// ListLowStockItems returns inventory rows at or below their threshold
+func ListLowStockItems(ctx context.Context) ([]models.Inventory, error) {
+	rows, err := config.MySQLDB.QueryContext(ctx, `SELECT id, product_id, quantity, COALESCE(warehouse_location, ''), last_restocked, COALESCE(low_stock_threshold, 0), version, created_at, updated_at FROM inventory WHERE quantity <= low_stock_threshold`)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	inventory := []models.Inventory{}
 	for rows.Next() {
 		var item models.Inventory
-		err := rows.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.WarehouseLocation, &item.LastRestocked, &item.LowStockThreshold, &item.CreatedAt, &item.UpdatedAt)
+		err := rows.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.WarehouseLocation, &item.LastRestocked, &item.LowStockThreshold, &item.Version, &item.CreatedAt, &item.UpdatedAt)
 		if err != nil {
 			continue
 		}
 		inventory = append(inventory, item)
 	}
+	return inventory, rows.Err()
+}
+
+// GetReorderSuggestions recommends restock quantities from recent sales
+// velocity. A product needs reordering once its stock position (on hand plus
+// in transit) can no longer cover demand over the supplier lead time on top
+// of its low-stock threshold; the suggestion tops it up to cover the lead
+// time plus cover_days of demand. lead_time_days, cover_days and window_days
+// override the configured defaults.
+func GetReorderSuggestions(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	leadTime, coverDays, window := config.App.Inventory.LeadTimeDays, config.App.Inventory.CoverDays, config.App.Inventory.VelocityWindowDays
+	for _, p := range []struct {
+		name string
+		dest *int
+		min  int
+	}{{"lead_time_days", &leadTime, 0}, {"cover_days", &coverDays, 0}, {"window_days", &window, 1}} {
+		value := params.Get(p.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < p.min {
+			http.Error(w, "Invalid "+p.name, http.StatusBadRequest)
+			return
+		}
+		*p.dest = n
+	}
+
+	since := time.Now().AddDate(0, 0, -window).Format("2006-01-02")
+	query := `SELECT i.product_id, i.quantity, COALESCE(i.low_stock_threshold, 0), COALESCE(s.sold, 0), COALESCE(t.in_transit, 0)
+			  FROM inventory i
+			  LEFT JOIN (SELECT product_id, SUM(quantity_sold) AS sold FROM sales_analytics WHERE sale_date > ? GROUP BY product_id) s
+			    ON s.product_id = i.product_id
+			  LEFT JOIN (SELECT product_id, SUM(quantity) AS in_transit FROM stock_transfers WHERE status = ? GROUP BY product_id) t
+			    ON t.product_id = i.product_id`
+	rows, err := config.MySQLDB.QueryContext(r.Context(), query, since, TransferInTransit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []models.ReorderSuggestion{}
+	for rows.Next() {
+		var s models.ReorderSuggestion
+		var sold int
+		if err := rows.Scan(&s.ProductID, &s.OnHand, &s.LowStockThreshold, &sold, &s.InTransit); err != nil {
+			continue
+		}
+		s.DailyVelocity = float64(sold) / float64(window)
+		s.ReorderPoint = int(math.Ceil(s.DailyVelocity*float64(leadTime))) + s.LowStockThreshold
+		position := s.OnHand + s.InTransit
+		if position > s.ReorderPoint {
+			continue
+		}
+		target := int(math.Ceil(s.DailyVelocity*float64(leadTime+coverDays))) + s.LowStockThreshold
+		s.SuggestedQuantity = target - position
+		if s.SuggestedQuantity <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].SuggestedQuantity > suggestions[j].SuggestedQuantity })
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(inventory)
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// CountLowStockItems returns the number of inventory rows at or below their threshold
+func CountLowStockItems(ctx context.Context) (int, error) {
+	var count int
+	err := config.MySQLDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM inventory WHERE quantity <= low_stock_threshold`).Scan(&count)
+	return count, err
 }
 
 // Analytics Handlers (MySQL)
@@ -152,7 +361,7 @@ func GetSalesAnalytics(w http.ResponseWriter, r *http.Request) {
 		endDate = time.Now().Format("2006-01-02")
 	}
 
-	rows, err := config.MySQLDB.Query(query, startDate, endDate)
+	rows, err := config.MySQLDB.QueryContext(r.Context(), query, startDate, endDate)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -180,7 +389,7 @@ func GetPopularProducts(w http.ResponseWriter, r *http.Request) {
 			  ORDER BY total_sold DESC 
 			  LIMIT 20`
 
-	rows, err := config.MySQLDB.Query(query)
+	rows, err := config.MySQLDB.QueryContext(r.Context(), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -217,7 +426,7 @@ func GetRevenueStats(w http.ResponseWriter, r *http.Request) {
 			  GROUP BY DATE(sale_date) 
 			  ORDER BY date DESC`
 
-	rows, err := config.MySQLDB.Query(query)
+	rows, err := config.MySQLDB.QueryContext(r.Context(), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
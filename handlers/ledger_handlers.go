@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sample-application/config"
+	"sample-application/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Inventory ledger movement types
+const (
+	MovementRestock     = "restock"
+	MovementSale        = "sale"
+	MovementReservation = "reservation"
+	MovementRelease     = "release"
+	MovementAdjustment  = "adjustment"
+	MovementReturn      = "return"
+	MovementTransfer    = "transfer"
+)
+
+// ActorHeader names the caller responsible for a stock change. Requests
+// without it are recorded against defaultActor.
+const (
+	ActorHeader  = "X-Actor"
+	defaultActor = "api"
+)
+
+// movementSign is the direction each externally recordable movement type
+// moves stock in. Adjustments carry their own sign; transfers and restocks
+// go through their dedicated endpoints.
+var movementSign = map[string]int{
+	MovementSale:        -1,
+	MovementReservation: -1,
+	MovementRelease:     1,
+	MovementReturn:      1,
+	MovementAdjustment:  0,
+}
+
+// stockMovement describes why a stock change happened, for the ledger
+type stockMovement struct {
+	Type        string
+	Reason      string
+	Actor       string
+	ReferenceID string
+}
+
+func requestActor(r *http.Request) string {
+	if actor := strings.TrimSpace(r.Header.Get(ActorHeader)); actor != "" {
+		return actor
+	}
+	return defaultActor
+}
+
+// applyStockMovement changes the on-hand quantity at one warehouse from
+// current by delta and appends the matching ledger entry, returning its id
+// (0 when delta is zero and nothing is recorded). Callers must hold the
+// warehouse_stock row lock (see lockWarehouseStock).
+func applyStockMovement(ctx context.Context, tx *sql.Tx, productID string, warehouseID, current, delta int, m stockMovement) (int64, error) {
+	if delta == 0 {
+		return 0, nil
+	}
+	balance := current + delta
+	_, err := tx.ExecContext(ctx, `INSERT INTO warehouse_stock (product_id, warehouse_id, quantity) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE quantity = VALUES(quantity)`, productID, warehouseID, balance)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `INSERT INTO stock_movements (product_id, warehouse_id, movement_type, quantity, balance_after, reason, actor, reference_id)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''))`,
+		productID, warehouseID, m.Type, delta, balance, m.Reason, m.Actor, m.ReferenceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Inventory Ledger Handlers (MySQL)
+func RecordStockMovement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID := vars["product_id"]
+
+	var data struct {
+		Type        string `json:"type"`
+		Quantity    int    `json:"quantity"`
+		WarehouseID int    `json:"warehouse_id"`
+		Reason      string `json:"reason"`
+		ReferenceID string `json:"reference_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sign, ok := movementSign[data.Type]
+	if !ok {
+		http.Error(w, "type must be one of sale, reservation, release, return or adjustment", http.StatusBadRequest)
+		return
+	}
+	delta := data.Quantity
+	if sign != 0 {
+		// Directional movements take a positive quantity
+		if data.Quantity <= 0 {
+			http.Error(w, "quantity must be positive", http.StatusBadRequest)
+			return
+		}
+		delta = sign * data.Quantity
+	} else if delta == 0 {
+		http.Error(w, "adjustment quantity must not be zero", http.StatusBadRequest)
+		return
+	}
+	if data.Type == MovementAdjustment && data.Reason == "" {
+		http.Error(w, "adjustments require a reason", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.MySQLDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	warehouseID, err := resolveWarehouse(r.Context(), tx, data.WarehouseID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Warehouse not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	movement := stockMovement{Type: data.Type, Reason: data.Reason, Actor: requestActor(r), ReferenceID: data.ReferenceID}
+	movementID, err := adjustWarehouseStock(r.Context(), tx, productID, warehouseID, delta, movement)
+	if err == errInsufficientStock {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := syncInventoryTotal(r.Context(), tx, productID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// syncInventoryTotal may have inserted the inventory row, moving
+	// LAST_INSERT_ID() on to it, so the movement is read back by its own id
+	var recorded models.StockMovement
+	err = tx.QueryRowContext(r.Context(), `SELECT id, product_id, warehouse_id, movement_type, quantity, balance_after, COALESCE(reason, ''), actor, COALESCE(reference_id, ''), created_at
+		FROM stock_movements WHERE id = ?`, movementID).
+		Scan(&recorded.ID, &recorded.ProductID, &recorded.WarehouseID, &recorded.Type, &recorded.Quantity, &recorded.BalanceAfter, &recorded.Reason, &recorded.Actor, &recorded.ReferenceID, &recorded.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(recorded)
+}
+
+// GetStockMovements lists a product's ledger entries, newest first. It
+// accepts warehouse_id, type, since and until (RFC 3339) and limit filters.
+func GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	params := r.URL.Query()
+
+	conditions := []string{"product_id = ?"}
+	args := []interface{}{vars["product_id"]}
+	if warehouseID := params.Get("warehouse_id"); warehouseID != "" {
+		conditions = append(conditions, "warehouse_id = ?")
+		args = append(args, warehouseID)
+	}
+	if movementType := params.Get("type"); movementType != "" {
+		conditions = append(conditions, "movement_type = ?")
+		args = append(args, movementType)
+	}
+	for _, bound := range []struct{ param, op string }{{"since", ">="}, {"until", "<="}} {
+		value := params.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid "+bound.param+" timestamp, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		conditions = append(conditions, "created_at "+bound.op+" ?")
+		args = append(args, t.UTC())
+	}
+
+	limit := 100
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	args = append(args, limit)
+
+	query := `SELECT id, product_id, warehouse_id, movement_type, quantity, balance_after, COALESCE(reason, ''), actor, COALESCE(reference_id, ''), created_at
+		FROM stock_movements WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id DESC LIMIT ?`
+	rows, err := config.MySQLDB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	movements := []models.StockMovement{}
+	for rows.Next() {
+		var m models.StockMovement
+		err := rows.Scan(&m.ID, &m.ProductID, &m.WarehouseID, &m.Type, &m.Quantity, &m.BalanceAfter, &m.Reason, &m.Actor, &m.ReferenceID, &m.CreatedAt)
+		if err != nil {
+			continue
+		}
+		movements = append(movements, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(movements)
+}
+
+// GetStockAt replays the ledger to report a product's stock as of ?at=
+// (RFC 3339), or now when omitted
+func GetStockAt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	snapshot := models.StockSnapshot{ProductID: vars["product_id"], At: time.Now().UTC(), Warehouses: []models.WarehouseQuantity{}}
+	if at := r.URL.Query().Get("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			http.Error(w, "Invalid at timestamp, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		snapshot.At = t.UTC()
+	}
+
+	rows, err := config.MySQLDB.QueryContext(r.Context(), `SELECT warehouse_id, SUM(quantity) FROM stock_movements
+		WHERE product_id = ? AND created_at <= ? GROUP BY warehouse_id ORDER BY warehouse_id`, snapshot.ProductID, snapshot.At)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wq models.WarehouseQuantity
+		if err := rows.Scan(&wq.WarehouseID, &wq.Quantity); err != nil {
+			continue
+		}
+		snapshot.Quantity += wq.Quantity
+		snapshot.Warehouses = append(snapshot.Warehouses, wq)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
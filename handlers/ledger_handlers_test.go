@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sample-application/config"
+	"sample-application/models"
+)
+
+func TestRecordStockMovement(t *testing.T) {
+	requireDatabases(t)
+	warehouseID := testWarehouse(t)
+	productID := testProductID(t)
+	path := "/api/inventory/" + productID + "/movements"
+
+	record := func(body map[string]interface{}) models.StockMovement {
+		t.Helper()
+		body["warehouse_id"] = warehouseID
+		rec := serve(t, http.MethodPost, path, body, ActorHeader, "tester")
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("recording %v: %d %s", body, rec.Code, rec.Body)
+		}
+		var m models.StockMovement
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	inventoryQuantity := func() int {
+		t.Helper()
+		var quantity int
+		if err := config.MySQLDB.QueryRow(`SELECT quantity FROM inventory WHERE product_id = ?`, productID).Scan(&quantity); err != nil {
+			t.Fatal(err)
+		}
+		return quantity
+	}
+
+	// The product has no inventory row yet, so recording the movement
+	// inserts one; the response must still be the movement itself
+	first := record(map[string]interface{}{"type": MovementAdjustment, "quantity": 10, "reason": "cycle count"})
+	var lastID int64
+	if err := config.MySQLDB.QueryRow(`SELECT MAX(id) FROM stock_movements WHERE product_id = ?`, productID).Scan(&lastID); err != nil {
+		t.Fatal(err)
+	}
+	want := models.StockMovement{ID: lastID, ProductID: productID, WarehouseID: warehouseID, Type: MovementAdjustment, Quantity: 10, BalanceAfter: 10, Reason: "cycle count", Actor: "tester"}
+	first.CreatedAt = want.CreatedAt
+	if first != want {
+		t.Errorf("first movement = %+v, want %+v", first, want)
+	}
+	if got := inventoryQuantity(); got != 10 {
+		t.Errorf("inventory total = %d, want 10", got)
+	}
+
+	sale := record(map[string]interface{}{"type": MovementSale, "quantity": 4, "reference_id": "order-1"})
+	if sale.ID <= first.ID || sale.Quantity != -4 || sale.BalanceAfter != 6 || sale.ReferenceID != "order-1" {
+		t.Errorf("sale = %+v, want id after %d, quantity -4, balance 6", sale, first.ID)
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 6 {
+		t.Errorf("warehouse stock = %d, want 6", got)
+	}
+	if got := inventoryQuantity(); got != 6 {
+		t.Errorf("inventory total = %d, want 6", got)
+	}
+
+	for _, tc := range []struct {
+		name string
+		body map[string]interface{}
+		code int
+	}{
+		{"more than on hand", map[string]interface{}{"type": MovementSale, "quantity": 7, "warehouse_id": warehouseID}, http.StatusConflict},
+		{"unknown type", map[string]interface{}{"type": MovementRestock, "quantity": 1, "warehouse_id": warehouseID}, http.StatusBadRequest},
+		{"negative sale", map[string]interface{}{"type": MovementSale, "quantity": -1, "warehouse_id": warehouseID}, http.StatusBadRequest},
+		{"zero adjustment", map[string]interface{}{"type": MovementAdjustment, "quantity": 0, "reason": "x", "warehouse_id": warehouseID}, http.StatusBadRequest},
+		{"adjustment without reason", map[string]interface{}{"type": MovementAdjustment, "quantity": -1, "warehouse_id": warehouseID}, http.StatusBadRequest},
+		{"unknown warehouse", map[string]interface{}{"type": MovementReturn, "quantity": 1, "warehouse_id": 1 << 30}, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := serve(t, http.MethodPost, path, tc.body); rec.Code != tc.code {
+				t.Errorf("%d %s, want %d", rec.Code, rec.Body, tc.code)
+			}
+		})
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 6 {
+		t.Errorf("warehouse stock after rejected movements = %d, want 6", got)
+	}
+}
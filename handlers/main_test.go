@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sample-application/config"
+	"sample-application/migrations"
+
+	"github.com/gorilla/mux"
+)
+
+// skipDatabases is why the database tests are skipped, or empty when the
+// databases configured through the environment are reachable and migrated
+var skipDatabases string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	skipDatabases = connectDatabases()
+	code := m.Run()
+	if skipDatabases == "" {
+		config.CloseDatabases()
+	}
+	os.Exit(code)
+}
+
+// connectDatabases connects to and migrates the databases, like main does,
+// and returns why it couldn't
+func connectDatabases() string {
+	if testing.Short() {
+		return "needs live databases"
+	}
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		return fmt.Sprintf("databases not configured: %v", err)
+	}
+	for _, addr := range []string{
+		net.JoinHostPort(cfg.Postgres.Host, strconv.Itoa(cfg.Postgres.Port)),
+		net.JoinHostPort(cfg.MySQL.Host, strconv.Itoa(cfg.MySQL.Port)),
+		net.JoinHostPort(cfg.Mongo.Host, strconv.Itoa(cfg.Mongo.Port)),
+	} {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return fmt.Sprintf("database not reachable: %v", err)
+		}
+		conn.Close()
+	}
+
+	config.InitDatabases(cfg)
+	if err := migrateUp(context.Background()); err != nil {
+		config.CloseDatabases()
+		return fmt.Sprintf("migrating the databases failed: %v", err)
+	}
+	return ""
+}
+
+func migrateUp(ctx context.Context) error {
+	postgres, err := migrations.NewPostgres(config.PostgresDB)
+	if err != nil {
+		return err
+	}
+	mysql, err := migrations.NewMySQL(config.MySQLDB)
+	if err != nil {
+		return err
+	}
+	for _, m := range []migrations.Migrator{postgres, mysql, migrations.NewMongo(config.GetMongoDatabase())} {
+		if err := m.Up(ctx); err != nil {
+			return fmt.Errorf("%s: %w", m.Store(), err)
+		}
+	}
+	return nil
+}
+
+// requireDatabases skips tests that need the compose databases when they
+// aren't available
+func requireDatabases(t *testing.T) {
+	t.Helper()
+	if skipDatabases != "" {
+		t.Skip(skipDatabases)
+	}
+}
+
+// testRouter routes the endpoints under test as main's router does
+func testRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/orders", CreateOrder).Methods("POST")
+	router.HandleFunc("/api/orders/{id}/status", UpdateOrderStatus).Methods("PATCH")
+	router.HandleFunc("/api/orders/{id}/cancel", CancelOrder).Methods("POST")
+	router.HandleFunc("/api/inventory/{product_id}/movements", RecordStockMovement).Methods("POST")
+	return router
+}
+
+// serve sends a request through testRouter. Headers are given as
+// name, value pairs.
+func serve(t *testing.T, method, path string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload string
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = string(data)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(payload))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	testRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+// randomSuffix makes names of test rows unique
+func randomSuffix(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// testWarehouse creates a warehouse that is removed when the test ends.
+// Create it before the products stocked in it, so their cleanup runs first.
+func testWarehouse(t *testing.T) int {
+	t.Helper()
+	code := "TEST-" + strings.ToUpper(randomSuffix(t))
+	result, err := config.MySQLDB.Exec(`INSERT INTO warehouses (code, name, city) VALUES (?, ?, 'Testville')`, code, "Warehouse "+code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := config.MySQLDB.Exec(`DELETE FROM warehouses WHERE id = ?`, id); err != nil {
+			t.Errorf("cleaning up warehouse: %v", err)
+		}
+	})
+	return int(id)
+}
+
+// testProductID returns a product ID no other data uses, and removes its
+// stock, ledger and inventory rows when the test ends
+func testProductID(t *testing.T) string {
+	t.Helper()
+	productID := "test-" + randomSuffix(t)
+	t.Cleanup(func() {
+		for _, table := range []string{"stock_movements", "warehouse_stock", "inventory"} {
+			if _, err := config.MySQLDB.Exec(`DELETE FROM `+table+` WHERE product_id = ?`, productID); err != nil {
+				t.Errorf("cleaning up %s: %v", table, err)
+			}
+		}
+	})
+	return productID
+}
+
+// testUser creates a user that is removed, with their orders, when the test
+// ends
+func testUser(t *testing.T) int {
+	t.Helper()
+	var id int
+	err := config.PostgresDB.QueryRow(`INSERT INTO users (name, email, password) VALUES ('Test User', $1, 'secret') RETURNING id`,
+		"test-"+randomSuffix(t)+"@example.com").Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, query := range []string{
+			`DELETE FROM order_items WHERE order_id IN (SELECT id FROM orders WHERE user_id = $1)`,
+			`DELETE FROM orders WHERE user_id = $1`,
+			`DELETE FROM users WHERE id = $1`,
+		} {
+			if _, err := config.PostgresDB.Exec(query, id); err != nil {
+				t.Errorf("cleaning up user: %v", err)
+			}
+		}
+	})
+	return id
+}
+
+// warehouseQuantity reads a product's on-hand stock at one warehouse
+func warehouseQuantity(t *testing.T, productID string, warehouseID int) int {
+	t.Helper()
+	var quantity int
+	err := config.MySQLDB.QueryRow(`SELECT quantity FROM warehouse_stock WHERE product_id = ? AND warehouse_id = ?`, productID, warehouseID).Scan(&quantity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quantity
+}
+
+// stockProduct records an adjustment that brings a product's stock at a
+// warehouse up from zero to quantity
+func stockProduct(t *testing.T, productID string, warehouseID, quantity int) {
+	t.Helper()
+	rec := serve(t, http.MethodPost, "/api/inventory/"+productID+"/movements",
+		map[string]interface{}{"type": MovementAdjustment, "quantity": quantity, "warehouse_id": warehouseID, "reason": "test stock"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("stocking %s: %d %s", productID, rec.Code, rec.Body)
+	}
+}
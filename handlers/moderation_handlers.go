@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sample-application/config"
+	"sample-application/logging"
+	"sample-application/models"
+	"sample-application/moderation"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Review moderation statuses
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+	ReviewFlagged  = "flagged"
+)
+
+// reviewScreener is built from config.App on first use
+var reviewScreener = sync.OnceValues(func() (*moderation.Screener, error) {
+	m := config.App.Moderation
+	return moderation.NewScreener(m.BlockedWords, m.BlockedPatterns, m.MaxLinks)
+})
+
+// screenReview decides the status of a new or edited review: flagged with
+// the reasons if the screen objects to its comment, otherwise approved or
+// pending depending on moderation.auto_approve
+func screenReview(comment string) (string, []string, error) {
+	screener, err := reviewScreener()
+	if err != nil {
+		return "", nil, err
+	}
+	if reasons := screener.Screen(comment); len(reasons) > 0 {
+		return ReviewFlagged, reasons, nil
+	}
+	if config.App.Moderation.AutoApprove {
+		return ReviewApproved, nil, nil
+	}
+	return ReviewPending, nil, nil
+}
+
+// ratingContribution is the rating a review adds to its product's
+// aggregates: its own rating while approved, nothing otherwise
+func ratingContribution(status string, rating int) int {
+	if status == ReviewApproved {
+		return rating
+	}
+	return 0
+}
+
+// moveReviewRating updates the product's rating for a review going from
+// one status and rating to another, once the review itself is written. If
+// the update fails, the product's rating is recomputed from its reviews;
+// errors are logged rather than failing the request, since the review
+// change has already been made.
+func moveReviewRating(ctx context.Context, before, after models.Review) {
+	removed := ratingContribution(before.Status, before.Rating)
+	added := ratingContribution(after.Status, after.Rating)
+	if removed == added {
+		return
+	}
+	err := applyRatingChange(ctx, after.ProductID, removed, added)
+	if err == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	logger.Warn("updating product rating failed, recomputing it", "product_id", after.ProductID, "error", err)
+	if err := recomputeRating(context.WithoutCancel(ctx), after.ProductID); err != nil {
+		logger.Error("recomputing product rating failed; run `ratings repair`", "product_id", after.ProductID, "error", err)
+	}
+}
+
+// ReportReview records a report of an approved review by the user named in
+// the X-User-ID header. Each user can report a review once; once
+// moderation.report_threshold users have, the review is flagged and hidden
+// until a moderator looks at it.
+func ReportReview(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	userID := requestUser(r)
+	if userID == 0 {
+		http.Error(w, UserHeader+" header with the reporter's user ID is required", http.StatusUnauthorized)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid review ID", http.StatusBadRequest)
+		return
+	}
+
+	// The body only carries an optional reason
+	var report models.ReviewReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report.UserID = userID
+
+	db := config.GetMongoDatabase()
+	reviews := db.Collection("reviews")
+	count, err := reviews.CountDocuments(r.Context(), bson.M{"_id": objectID, "status": ReviewApproved})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+
+	report.ID = ""
+	report.ReviewID = id
+	report.CreatedAt = time.Now()
+	result, err := db.Collection("review_reports").InsertOne(r.Context(), report)
+	if mongo.IsDuplicateKeyError(err) {
+		http.Error(w, "User has already reported this review", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	var review models.Review
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = reviews.FindOneAndUpdate(r.Context(), bson.M{"_id": objectID}, bson.M{"$inc": bson.M{"report_count": 1}}, opts).Decode(&review)
+	if err != nil && err != mongo.ErrNoDocuments {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err == nil && review.ReportCount >= config.App.Moderation.ReportThreshold {
+		// Only the report that crosses the threshold flips the status, so
+		// the rating is adjusted once
+		flag := bson.M{"$set": bson.M{"status": ReviewFlagged, "moderation_reasons": bson.A{"reported by customers"}}}
+		result, err := reviews.UpdateOne(r.Context(), bson.M{"_id": objectID, "status": ReviewApproved}, flag)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("flagging reported review failed", "review_id", id, "error", err)
+		} else if result.ModifiedCount == 1 {
+			moveReviewRating(r.Context(), review, models.Review{ProductID: review.ProductID, Status: ReviewFlagged, Rating: review.Rating})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetModerationQueue lists reviews awaiting a moderator, oldest first. It
+// accepts status (pending, flagged, rejected or approved; pending and
+// flagged by default), product_id and limit.
+func GetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	filter := bson.M{"status": bson.M{"$in": bson.A{ReviewPending, ReviewFlagged}}}
+	if status := params.Get("status"); status != "" {
+		if status != ReviewPending && status != ReviewFlagged && status != ReviewRejected && status != ReviewApproved {
+			http.Error(w, "status must be pending, flagged, rejected or approved", http.StatusBadRequest)
+			return
+		}
+		filter["status"] = status
+	}
+	if productID := params.Get("product_id"); productID != "" {
+		filter["product_id"] = productID
+	}
+
+	limit := 100
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := config.GetMongoDatabase().Collection("reviews").Find(r.Context(), filter, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	reviews := []models.Review{}
+	if err := cursor.All(r.Context(), &reviews); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+func ApproveReview(w http.ResponseWriter, r *http.Request) {
+	moderateReview(w, r, ReviewApproved)
+}
+
+func RejectReview(w http.ResponseWriter, r *http.Request) {
+	moderateReview(w, r, ReviewRejected)
+}
+
+// moderateReview records a moderator's decision with their reason. The
+// moderator is named by the X-Actor header. Rejections require a reason.
+func moderateReview(w http.ResponseWriter, r *http.Request, status string) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid review ID", http.StatusBadRequest)
+		return
+	}
+
+	var data struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.Reason = strings.TrimSpace(data.Reason)
+	if status == ReviewRejected && data.Reason == "" {
+		http.Error(w, "reason is required when rejecting a review", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{"status": status, "moderated_by": requestActor(r), "moderated_at": now, "moderation_note": data.Reason}
+	update := bson.M{"$set": set}
+	if status == ReviewApproved {
+		// An approval clears the reports that flagged the review, so it
+		// takes a fresh set of reports to flag it again
+		set["report_count"] = 0
+		update["$unset"] = bson.M{"moderation_reasons": ""}
+	}
+
+	var before models.Review
+	err = config.GetMongoDatabase().Collection("reviews").FindOneAndUpdate(r.Context(), bson.M{"_id": objectID}, update).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	review := before
+	review.Status = status
+	review.ModeratedBy = set["moderated_by"].(string)
+	review.ModeratedAt = &now
+	review.ModerationNote = data.Reason
+	if status == ReviewApproved {
+		review.ReportCount = 0
+		review.ModerationReasons = nil
+		if _, err := config.GetMongoDatabase().Collection("review_reports").DeleteMany(r.Context(), bson.M{"review_id": id}); err != nil {
+			logging.FromContext(r.Context()).Error("clearing review reports failed", "review_id", id, "error", err)
+		}
+	}
+	moveReviewRating(r.Context(), before, review)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
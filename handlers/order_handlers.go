@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 
 	"sample-application/config"
+	"sample-application/logging"
+	"sample-application/metrics"
 	"sample-application/models"
 
 	"github.com/gorilla/mux"
@@ -19,19 +26,42 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, err := config.PostgresDB.Begin()
+	for _, item := range order.Items {
+		if item.Quantity <= 0 {
+			http.Error(w, "item quantity must be positive", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Fulfil from the warehouse best placed to ship the whole order
+	warehouseID, err := PickWarehouse(r.Context(), order.Items, order.ShippingAddress)
+	if err != nil {
+		metrics.CheckoutFailures.Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(order.Items) > 0 && warehouseID == nil {
+		metrics.CheckoutFailures.Inc()
+		http.Error(w, errInsufficientStock.Error(), http.StatusConflict)
+		return
+	}
+	order.WarehouseID = warehouseID
+
+	tx, err := config.PostgresDB.BeginTx(r.Context(), nil)
 	if err != nil {
+		metrics.CheckoutFailures.Inc()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer tx.Rollback()
 
-	query := `INSERT INTO orders (user_id, total_amount, status, payment_method, shipping_address) 
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at`
-	err = tx.QueryRow(query, order.UserID, order.TotalAmount, order.Status, order.PaymentMethod, order.ShippingAddress).
-		Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+	query := `INSERT INTO orders (user_id, total_amount, status, payment_method, shipping_address, warehouse_id) 
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, version, created_at, updated_at`
+	err = tx.QueryRowContext(r.Context(), query, order.UserID, order.TotalAmount, order.Status, order.PaymentMethod, order.ShippingAddress, order.WarehouseID).
+		Scan(&order.ID, &order.Version, &order.CreatedAt, &order.UpdatedAt)
 
 	if err != nil {
+		metrics.CheckoutFailures.Inc()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -40,26 +70,52 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 	if len(order.Items) > 0 {
 		for _, item := range order.Items {
 			itemQuery := `INSERT INTO order_items (order_id, product_id, quantity, price) VALUES ($1, $2, $3, $4)`
-			_, err = tx.Exec(itemQuery, order.ID, item.ProductID, item.Quantity, item.Price)
+			_, err = tx.ExecContext(r.Context(), itemQuery, order.ID, item.ProductID, item.Quantity, item.Price)
 			if err != nil {
+				metrics.CheckoutFailures.Inc()
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 		}
 	}
 
+	// Stock is reserved before the order commits, so an order that can't be
+	// filled is never created. If the commit then fails, the reservation is
+	// released again.
+	if len(order.Items) > 0 {
+		err := moveOrderStock(r.Context(), order.ID, *warehouseID, order.Items, MovementReservation, requestActor(r))
+		if err == errInsufficientStock {
+			metrics.CheckoutFailures.Inc()
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			metrics.CheckoutFailures.Inc()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
+		metrics.CheckoutFailures.Inc()
+		if len(order.Items) > 0 {
+			if err := moveOrderStock(r.Context(), order.ID, *warehouseID, order.Items, MovementRelease, requestActor(r)); err != nil {
+				logging.FromContext(r.Context()).Error("releasing stock of failed order", "order_id", order.ID, "error", err)
+			}
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	metrics.OrdersCreated.Inc()
 
+	w.Header().Set("ETag", versionETag(order.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(order)
 }
 
 func GetAllOrders(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.PostgresDB.Query(`SELECT id, user_id, total_amount, status, payment_method, shipping_address, created_at, updated_at FROM orders LIMIT 100`)
+	rows, err := config.PostgresDB.QueryContext(r.Context(), `SELECT id, COALESCE(user_id, 0), total_amount, status, payment_method, shipping_address, warehouse_id, version, created_at, updated_at FROM orders LIMIT 100`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -69,7 +125,7 @@ func GetAllOrders(w http.ResponseWriter, r *http.Request) {
 	orders := []models.Order{}
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.CreatedAt, &order.UpdatedAt)
+		err := rows.Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.WarehouseID, &order.Version, &order.CreatedAt, &order.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -85,8 +141,8 @@ func GetOrderByID(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	var order models.Order
-	query := `SELECT id, user_id, total_amount, status, payment_method, shipping_address, created_at, updated_at FROM orders WHERE id = $1`
-	err := config.PostgresDB.QueryRow(query, id).Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.CreatedAt, &order.UpdatedAt)
+	query := `SELECT id, COALESCE(user_id, 0), total_amount, status, payment_method, shipping_address, warehouse_id, version, created_at, updated_at FROM orders WHERE id = $1`
+	err := config.PostgresDB.QueryRowContext(r.Context(), query, id).Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.WarehouseID, &order.Version, &order.CreatedAt, &order.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "Order not found", http.StatusNotFound)
@@ -98,7 +154,7 @@ func GetOrderByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get order items
-	itemRows, err := config.PostgresDB.Query(`SELECT id, order_id, product_id, quantity, price FROM order_items WHERE order_id = $1`, order.ID)
+	itemRows, err := config.PostgresDB.QueryContext(r.Context(), `SELECT id, order_id, product_id, quantity, price FROM order_items WHERE order_id = $1`, order.ID)
 	if err == nil {
 		defer itemRows.Close()
 		for itemRows.Next() {
@@ -109,14 +165,36 @@ func GetOrderByID(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if notModified(w, r, order.Version) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(order)
 }
 
+// orderTransitions lists the statuses UpdateOrderStatus may move an order
+// to from each status. Cancelling goes through CancelOrder instead, which
+// releases the order's stock, and delivered and cancelled orders are final.
+var orderTransitions = map[string][]string{
+	"pending":    {"processing", "shipped"},
+	"processing": {"shipped"},
+	"shipped":    {"delivered"},
+	"delivered":  nil,
+	"cancelled":  nil,
+}
+
+// UpdateOrderStatus moves an order along orderTransitions. Like
+// cancellation, it requires the order's ETag in If-Match.
 func UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var data map[string]string
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -124,40 +202,197 @@ func UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	status := data["status"]
-	query := `UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
-	result, err := config.PostgresDB.Exec(query, status, id)
+	if status == "cancelled" {
+		http.Error(w, "Cancel orders with POST /api/orders/{id}/cancel, which releases their stock", http.StatusBadRequest)
+		return
+	}
+	if _, ok := orderTransitions[status]; !ok || status == "pending" {
+		http.Error(w, "status must be processing, shipped or delivered", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.PostgresDB.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
+	var current string
+	var version int
+	err = tx.QueryRowContext(r.Context(), `SELECT status, version FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&current, &version)
+	if err == sql.ErrNoRows {
 		http.Error(w, "Order not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !versionMatches(expected, version) {
+		preconditionFailed(w)
+		return
+	}
+	if !slices.Contains(orderTransitions[current], status) {
+		http.Error(w, fmt.Sprintf("Order is %s and can't become %s", current, status), http.StatusConflict)
+		return
+	}
 
+	query := `UPDATE orders SET status = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $2 RETURNING version`
+	if err := tx.QueryRowContext(r.Context(), query, status, id).Scan(&version); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if status == "delivered" {
+		if err := markVerifiedPurchases(r.Context(), id); err != nil {
+			logging.FromContext(r.Context()).Error("marking verified reviews failed", "order_id", id, "error", err)
+		}
+	}
+
+	w.Header().Set("ETag", versionETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Order status updated successfully"})
 }
 
+// CancelOrder cancels an order and, unless it has already shipped, releases
+// the stock reserved for it back to its warehouse. Like status updates, it
+// requires the order's ETag in If-Match.
 func CancelOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	query := `UPDATE orders SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP WHERE id = $1`
-	result, err := config.PostgresDB.Exec(query, id)
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := config.PostgresDB.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
+	var orderID int
+	var status string
+	var warehouseID *int
+	err = tx.QueryRowContext(r.Context(), `SELECT id, status, warehouse_id FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&orderID, &status, &warehouseID)
+	if err == sql.ErrNoRows {
 		http.Error(w, "Order not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var version int
+	query := `UPDATE orders SET status = 'cancelled', version = version + 1, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $1 AND ($2 = 0 OR version = $2) RETURNING version`
+	err = tx.QueryRowContext(r.Context(), query, orderID, expected).Scan(&version)
+	if err == sql.ErrNoRows {
+		// The row is locked, so it exists and only the version can differ
+		preconditionFailed(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var items []models.OrderItem
+	if warehouseID != nil && !stockLeftWarehouse[status] {
+		if items, err = orderItems(r.Context(), tx, orderID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := moveOrderStock(r.Context(), orderID, *warehouseID, items, MovementRelease, requestActor(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if len(items) > 0 {
+			if err := moveOrderStock(r.Context(), orderID, *warehouseID, items, MovementReservation, requestActor(r)); err != nil {
+				logging.FromContext(r.Context()).Error("reserving stock of order left uncancelled", "order_id", orderID, "error", err)
+			}
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	w.Header().Set("ETag", versionETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Order cancelled successfully"})
 }
+
+// stockLeftWarehouse lists the order statuses whose reserved stock has been
+// shipped or already released, so cancelling releases nothing
+var stockLeftWarehouse = map[string]bool{
+	"shipped":   true,
+	"delivered": true,
+	"cancelled": true,
+}
+
+func orderReference(id int) string {
+	return "order:" + strconv.Itoa(id)
+}
+
+func orderItems(ctx context.Context, tx *sql.Tx, orderID int) ([]models.OrderItem, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, order_id, product_id, quantity, price FROM order_items WHERE order_id = $1`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.OrderItem
+	for rows.Next() {
+		var item models.OrderItem
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// moveOrderStock reserves (MovementReservation) or releases
+// (MovementRelease) an order's items at its warehouse in one MySQL
+// transaction, recording each product's movement in the ledger. Products are
+// locked in ID order so concurrent orders can't deadlock.
+func moveOrderStock(ctx context.Context, orderID, warehouseID int, items []models.OrderItem, movementType, actor string) error {
+	quantities := map[string]int{}
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	productIDs := make([]string, 0, len(quantities))
+	for productID := range quantities {
+		productIDs = append(productIDs, productID)
+	}
+	sort.Strings(productIDs)
+
+	tx, err := config.MySQLDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	movement := stockMovement{Type: movementType, Actor: actor, ReferenceID: orderReference(orderID)}
+	for _, productID := range productIDs {
+		delta := movementSign[movementType] * quantities[productID]
+		if _, err := adjustWarehouseStock(ctx, tx, productID, warehouseID, delta, movement); err != nil {
+			return err
+		}
+		if err := syncInventoryTotal(ctx, tx, productID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
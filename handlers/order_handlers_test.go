@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"sample-application/config"
+	"sample-application/models"
+)
+
+// createOrder places a pending order for the items and returns it with its
+// ETag
+func createOrder(t *testing.T, userID int, items ...models.OrderItem) (models.Order, string) {
+	t.Helper()
+	rec := serve(t, http.MethodPost, "/api/orders", models.Order{
+		UserID:          userID,
+		TotalAmount:     10,
+		Status:          "pending",
+		PaymentMethod:   "credit_card",
+		ShippingAddress: "1 Main St, Testville",
+		Items:           items,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating order: %d %s", rec.Code, rec.Body)
+	}
+	var order models.Order
+	if err := json.Unmarshal(rec.Body.Bytes(), &order); err != nil {
+		t.Fatal(err)
+	}
+	return order, rec.Header().Get("ETag")
+}
+
+func orderPath(order models.Order, action string) string {
+	return "/api/orders/" + strconv.Itoa(order.ID) + "/" + action
+}
+
+func TestCreateOrderReservesStock(t *testing.T) {
+	requireDatabases(t)
+	warehouseID := testWarehouse(t)
+	productID := testProductID(t)
+	userID := testUser(t)
+	stockProduct(t, productID, warehouseID, 5)
+
+	order, _ := createOrder(t, userID, models.OrderItem{ProductID: productID, Quantity: 3, Price: 2})
+	if order.WarehouseID == nil || *order.WarehouseID != warehouseID {
+		t.Errorf("order fulfilled from warehouse %v, want %d", order.WarehouseID, warehouseID)
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 2 {
+		t.Errorf("stock after reserving 3 of 5 = %d, want 2", got)
+	}
+	var movementType string
+	var quantity int
+	err := config.MySQLDB.QueryRow(`SELECT movement_type, quantity FROM stock_movements WHERE product_id = ? AND reference_id = ?`,
+		productID, orderReference(order.ID)).Scan(&movementType, &quantity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movementType != MovementReservation || quantity != -3 {
+		t.Errorf("ledger has %s %d for the order, want reservation -3", movementType, quantity)
+	}
+
+	// An order that can't be filled is rejected without touching stock
+	rec := serve(t, http.MethodPost, "/api/orders", models.Order{
+		UserID: userID,
+		Status: "pending",
+		Items:  []models.OrderItem{{ProductID: productID, Quantity: 3, Price: 2}},
+	})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("ordering 3 of 2 in stock: %d %s, want 409", rec.Code, rec.Body)
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 2 {
+		t.Errorf("stock after a rejected order = %d, want 2", got)
+	}
+	var orders int
+	if err := config.PostgresDB.QueryRow(`SELECT COUNT(*) FROM orders WHERE user_id = $1`, userID).Scan(&orders); err != nil {
+		t.Fatal(err)
+	}
+	if orders != 1 {
+		t.Errorf("user has %d orders, want 1", orders)
+	}
+}
+
+func TestCancelOrderReleasesStock(t *testing.T) {
+	requireDatabases(t)
+	warehouseID := testWarehouse(t)
+	productID := testProductID(t)
+	userID := testUser(t)
+	stockProduct(t, productID, warehouseID, 5)
+
+	order, etag := createOrder(t, userID, models.OrderItem{ProductID: productID, Quantity: 2, Price: 2})
+	path := orderPath(order, "cancel")
+
+	if rec := serve(t, http.MethodPost, path, nil); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("cancel without If-Match: %d, want 428", rec.Code)
+	}
+	if rec := serve(t, http.MethodPost, path, nil, "If-Match", `"99"`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("cancel with a stale ETag: %d, want 412", rec.Code)
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 3 {
+		t.Fatalf("stock after failed cancels = %d, want 3", got)
+	}
+
+	rec := serve(t, http.MethodPost, path, nil, "If-Match", etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", rec.Code, rec.Body)
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 5 {
+		t.Errorf("stock after cancelling = %d, want 5", got)
+	}
+
+	// Cancelling again doesn't release the stock twice
+	if rec := serve(t, http.MethodPost, path, nil, "If-Match", "*"); rec.Code != http.StatusOK {
+		t.Errorf("second cancel: %d %s", rec.Code, rec.Body)
+	}
+	if got := warehouseQuantity(t, productID, warehouseID); got != 5 {
+		t.Errorf("stock after cancelling twice = %d, want 5", got)
+	}
+}
+
+func TestUpdateOrderStatusTransitions(t *testing.T) {
+	requireDatabases(t)
+	order, etag := createOrder(t, testUser(t))
+	path := orderPath(order, "status")
+
+	if rec := serve(t, http.MethodPatch, path, map[string]string{"status": "processing"}); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("update without If-Match: %d, want 428", rec.Code)
+	}
+
+	for _, step := range []struct {
+		status string
+		code   int
+	}{
+		{"cancelled", http.StatusBadRequest},
+		{"pending", http.StatusBadRequest},
+		{"unknown", http.StatusBadRequest},
+		{"delivered", http.StatusConflict},
+		{"processing", http.StatusOK},
+		{"processing", http.StatusConflict},
+		{"shipped", http.StatusOK},
+		{"processing", http.StatusConflict},
+		{"delivered", http.StatusOK},
+		{"shipped", http.StatusConflict},
+	} {
+		rec := serve(t, http.MethodPatch, path, map[string]string{"status": step.status}, "If-Match", etag)
+		if rec.Code != step.code {
+			t.Fatalf("-> %s: %d %s, want %d", step.status, rec.Code, rec.Body, step.code)
+		}
+		if rec.Code == http.StatusOK {
+			if rec.Header().Get("ETag") == etag {
+				t.Errorf("-> %s kept ETag %s", step.status, etag)
+			}
+			etag = rec.Header().Get("ETag")
+		}
+	}
+
+	var status string
+	if err := config.PostgresDB.QueryRow(`SELECT status FROM orders WHERE id = $1`, order.ID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "delivered" {
+		t.Errorf("order is %s, want delivered", status)
+	}
+
+	stale := serve(t, http.MethodPatch, orderPath(order, "status"), map[string]string{"status": "delivered"}, "If-Match", `"1"`)
+	if stale.Code != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale ETag: %d, want 412", stale.Code)
+	}
+}
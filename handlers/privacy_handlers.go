@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sample-application/config"
+	"sample-application/consistency"
+	"sample-application/logging"
+	"sample-application/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userDeletion reports what deleting a user removed or anonymized
+type userDeletion struct {
+	Message                string `json:"message"`
+	CartItemsDeleted       int64  `json:"cart_items_deleted"`
+	OrdersAnonymized       int64  `json:"orders_anonymized"`
+	WishlistItemsDeleted   int64  `json:"wishlist_items_deleted"`
+	PriceDropEventsDeleted int64  `json:"price_drop_events_deleted"`
+	ReviewsPseudonymized   int    `json:"reviews_pseudonymized"`
+}
+
+// userExport is everything held about a user, across the stores. MySQL
+// inventory and analytics hold nothing per user.
+type userExport struct {
+	ExportedAt      time.Time               `json:"exported_at"`
+	User            models.User             `json:"user"`
+	Orders          []models.Order          `json:"orders"`
+	Cart            []models.CartItem       `json:"cart"`
+	Reviews         []models.Review         `json:"reviews"`
+	ReviewVotes     []models.ReviewVote     `json:"review_votes"`
+	ReviewReports   []models.ReviewReport   `json:"review_reports"`
+	Wishlist        []models.Wishlist       `json:"wishlist"`
+	PriceDropEvents []models.PriceDropEvent `json:"price_drop_events"`
+}
+
+// Privacy Handlers (all stores)
+
+// DeleteUser forgets a user. In PostgreSQL their cart and account are
+// deleted, and their orders are kept for accounting but detached from them,
+// without the shipping address. In MongoDB their wishlist and price-drop
+// events are deleted, and their reviews, votes and reports pseudonymized.
+// PostgreSQL commits first: if MongoDB then fails, the account is already
+// gone and `consistency check -fix` finishes the job.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := forgetUserAccount(r.Context(), id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := forgetUserContent(r.Context(), id, &result); err != nil {
+		logging.FromContext(r.Context()).Error("forgetting user content failed", "user_id", id, "error", err)
+		http.Error(w, "User deleted, but removing their reviews and wishlist failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result.Message = "User deleted successfully"
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// forgetUserAccount deletes the user's account and cart and anonymizes
+// their orders in one transaction, returning sql.ErrNoRows if there is no
+// such user
+func forgetUserAccount(ctx context.Context, id int) (userDeletion, error) {
+	var result userDeletion
+	tx, err := config.PostgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Locking the row keeps new orders and cart items from referencing it
+	// until it is gone
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&exists); err != nil {
+		return result, err
+	}
+
+	deleted, err := tx.ExecContext(ctx, `DELETE FROM cart WHERE user_id = $1`, id)
+	if err != nil {
+		return result, err
+	}
+	result.CartItemsDeleted, _ = deleted.RowsAffected()
+
+	anonymized, err := tx.ExecContext(ctx, `UPDATE orders SET user_id = NULL, shipping_address = '', version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1`, id)
+	if err != nil {
+		return result, err
+	}
+	result.OrdersAnonymized, _ = anonymized.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id); err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
+
+func forgetUserContent(ctx context.Context, id int, result *userDeletion) error {
+	db := config.GetMongoDatabase()
+	deleted, err := db.Collection("wishlist").DeleteMany(ctx, bson.M{"user_id": id})
+	if err != nil {
+		return err
+	}
+	result.WishlistItemsDeleted = deleted.DeletedCount
+
+	deleted, err = db.Collection("price_drop_events").DeleteMany(ctx, bson.M{"user_id": id})
+	if err != nil {
+		return err
+	}
+	result.PriceDropEventsDeleted = deleted.DeletedCount
+
+	result.ReviewsPseudonymized, err = consistency.AnonymizeUser(ctx, db, id)
+	return err
+}
+
+// ExportUser returns everything held about a user as one JSON document,
+// for data access requests. The password is left out.
+func ExportUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+
+	export := userExport{ExportedAt: time.Now().UTC()}
+	err = config.PostgresDB.QueryRowContext(ctx, `SELECT id, name, email, COALESCE(address, ''), COALESCE(phone, ''), version, created_at, updated_at FROM users WHERE id = $1`, id).
+		Scan(&export.User.ID, &export.User.Name, &export.User.Email, &export.User.Address, &export.User.Phone, &export.User.Version, &export.User.CreatedAt, &export.User.UpdatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if export.Orders, err = exportOrders(ctx, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if export.Cart, err = exportCart(ctx, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	db := config.GetMongoDatabase()
+	filter := bson.M{"user_id": id}
+	byCreated := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	export.Reviews, export.ReviewVotes, export.ReviewReports = []models.Review{}, []models.ReviewVote{}, []models.ReviewReport{}
+	export.Wishlist, export.PriceDropEvents = []models.Wishlist{}, []models.PriceDropEvent{}
+	for _, c := range []struct {
+		collection string
+		opts       *options.FindOptions
+		out        interface{}
+	}{
+		{"reviews", byCreated, &export.Reviews},
+		{"review_votes", byCreated, &export.ReviewVotes},
+		{"review_reports", byCreated, &export.ReviewReports},
+		{"wishlist", options.Find().SetSort(bson.D{{Key: "added_at", Value: 1}}), &export.Wishlist},
+		{"price_drop_events", byCreated, &export.PriceDropEvents},
+	} {
+		cursor, err := db.Collection(c.collection).Find(ctx, filter, c.opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := cursor.All(ctx, c.out); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="user-`+strconv.Itoa(id)+`-export.json"`)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(export)
+}
+
+// exportOrders lists the user's orders with their items, oldest first
+func exportOrders(ctx context.Context, userID int) ([]models.Order, error) {
+	rows, err := config.PostgresDB.QueryContext(ctx, `SELECT id, user_id, total_amount, status, payment_method, shipping_address, warehouse_id, version, created_at, updated_at
+		FROM orders WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []models.Order{}
+	index := map[int]int{}
+	for rows.Next() {
+		var order models.Order
+		if err := rows.Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.WarehouseID, &order.Version, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			return nil, err
+		}
+		index[order.ID] = len(orders)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	itemRows, err := config.PostgresDB.QueryContext(ctx, `SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.price
+		FROM order_items oi JOIN orders o ON o.id = oi.order_id WHERE o.user_id = $1 ORDER BY oi.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var item models.OrderItem
+		if err := itemRows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price); err != nil {
+			return nil, err
+		}
+		if i, ok := index[item.OrderID]; ok {
+			orders[i].Items = append(orders[i].Items, item)
+		}
+	}
+	return orders, itemRows.Err()
+}
+
+func exportCart(ctx context.Context, userID int) ([]models.CartItem, error) {
+	rows, err := config.PostgresDB.QueryContext(ctx, `SELECT id, user_id, product_id, quantity, created_at, updated_at FROM cart WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cart := []models.CartItem{}
+	for rows.Next() {
+		var item models.CartItem
+		if err := rows.Scan(&item.ID, &item.UserID, &item.ProductID, &item.Quantity, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		cart = append(cart, item)
+	}
+	return cart, rows.Err()
+}
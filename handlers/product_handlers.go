@@ -1,17 +1,18 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
 	"time"
 
 	"sample-application/config"
+	"sample-application/logging"
 	"sample-application/models"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -25,9 +26,13 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	product.CreatedAt = time.Now()
 	product.UpdatedAt = time.Now()
+	product.Version = 1
+	// Ratings are derived from reviews, never taken from the client
+	product.Rating = 0
+	product.ReviewCount = 0
 
 	collection := config.GetMongoDatabase().Collection("products")
-	result, err := collection.InsertOne(context.Background(), product)
+	result, err := collection.InsertOne(r.Context(), product)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -35,6 +40,7 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	product.ID = result.InsertedID.(primitive.ObjectID).Hex()
 
+	w.Header().Set("ETag", versionETag(product.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(product)
@@ -44,15 +50,15 @@ func GetAllProducts(w http.ResponseWriter, r *http.Request) {
 	collection := config.GetMongoDatabase().Collection("products")
 
 	opts := options.Find().SetLimit(100)
-	cursor, err := collection.Find(context.Background(), bson.M{}, opts)
+	cursor, err := collection.Find(r.Context(), bson.M{}, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
 	products := []models.Product{}
-	if err := cursor.All(context.Background(), &products); err != nil {
+	if err := cursor.All(r.Context(), &products); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -73,12 +79,16 @@ func GetProductByID(w http.ResponseWriter, r *http.Request) {
 
 	collection := config.GetMongoDatabase().Collection("products")
 	var product models.Product
-	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&product)
+	err = collection.FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&product)
 	if err != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
 
+	if notModified(w, r, product.Version) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(product)
 }
@@ -93,6 +103,11 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var product models.Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -100,20 +115,32 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product.UpdatedAt = time.Now()
+	// The version is only ever advanced by updateVersioned, and the rating
+	// fields by review aggregation; zero values are left out of the $set
+	product.Version = 0
+	product.Rating = 0
+	product.ReviewCount = 0
 
 	collection := config.GetMongoDatabase().Collection("products")
-	update := bson.M{"$set": product}
-	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+	version, err := updateVersioned(r.Context(), collection, objectID, expected, product)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err == errVersionMismatch {
+		preconditionFailed(w)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, "Product not found", http.StatusNotFound)
-		return
+	if err := notifyPriceDrops(r.Context(), id, product.Name, product.Price); err != nil {
+		logging.FromContext(r.Context()).Error("recording wishlist price drops failed", "product_id", id, "error", err)
 	}
 
+	w.Header().Set("ETag", versionETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Product updated successfully"})
 }
@@ -129,7 +156,7 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	collection := config.GetMongoDatabase().Collection("products")
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	result, err := collection.DeleteOne(r.Context(), bson.M{"_id": objectID})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -156,15 +183,15 @@ func SearchProducts(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	cursor, err := collection.Find(context.Background(), filter)
+	cursor, err := collection.Find(r.Context(), filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
 	products := []models.Product{}
-	if err := cursor.All(context.Background(), &products); err != nil {
+	if err := cursor.All(r.Context(), &products); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -180,15 +207,15 @@ func GetProductsByCategory(w http.ResponseWriter, r *http.Request) {
 	collection := config.GetMongoDatabase().Collection("products")
 	filter := bson.M{"category": category}
 
-	cursor, err := collection.Find(context.Background(), filter)
+	cursor, err := collection.Find(r.Context(), filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
 	products := []models.Product{}
-	if err := cursor.All(context.Background(), &products); err != nil {
+	if err := cursor.All(r.Context(), &products); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -207,9 +234,10 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
 
 	category.CreatedAt = time.Now()
 	category.UpdatedAt = time.Now()
+	category.Version = 1
 
 	collection := config.GetMongoDatabase().Collection("categories")
-	result, err := collection.InsertOne(context.Background(), category)
+	result, err := collection.InsertOne(r.Context(), category)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -217,6 +245,7 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
 
 	category.ID = result.InsertedID.(primitive.ObjectID).Hex()
 
+	w.Header().Set("ETag", versionETag(category.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(category)
@@ -225,15 +254,15 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
 func GetAllCategories(w http.ResponseWriter, r *http.Request) {
 	collection := config.GetMongoDatabase().Collection("categories")
 
-	cursor, err := collection.Find(context.Background(), bson.M{})
+	cursor, err := collection.Find(r.Context(), bson.M{})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
 	categories := []models.Category{}
-	if err := cursor.All(context.Background(), &categories); err != nil {
+	if err := cursor.All(r.Context(), &categories); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -254,12 +283,16 @@ func GetCategoryByID(w http.ResponseWriter, r *http.Request) {
 
 	collection := config.GetMongoDatabase().Collection("categories")
 	var category models.Category
-	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&category)
+	err = collection.FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&category)
 	if err != nil {
 		http.Error(w, "Category not found", http.StatusNotFound)
 		return
 	}
 
+	if notModified(w, r, category.Version) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(category)
 }
@@ -274,6 +307,11 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var category models.Category
 	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -281,20 +319,24 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	category.UpdatedAt = time.Now()
+	category.Version = 0
 
 	collection := config.GetMongoDatabase().Collection("categories")
-	update := bson.M{"$set": category}
-	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	version, err := updateVersioned(r.Context(), collection, objectID, expected, category)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Category not found", http.StatusNotFound)
 		return
 	}
-
-	if result.MatchedCount == 0 {
-		http.Error(w, "Category not found", http.StatusNotFound)
+	if err == errVersionMismatch {
+		preconditionFailed(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Category updated successfully"})
 }
@@ -310,7 +352,7 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	collection := config.GetMongoDatabase().Collection("categories")
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	result, err := collection.DeleteOne(r.Context(), bson.M{"_id": objectID})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
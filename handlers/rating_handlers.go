@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"sample-application/config"
+	"sample-application/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rating aggregates live on each product document: rating (the average),
+// review_count, rating_sum and rating_histogram ({"1": n, ..., "5": n}).
+// They are only ever changed by applyRatingChange, recomputeRating and
+// RepairRatings.
+//
+// A review write and the aggregate update that follows it are not one
+// transaction: MongoDB transactions need a replica set, and the bundled
+// deployment runs a standalone server. When the update fails, the product
+// is recomputed from its reviews instead (see moveReviewRating), and
+// `ratings repair` fixes anything a crash in between leaves behind.
+
+const (
+	minRating = 1
+	maxRating = 5
+)
+
+// ratedFilter selects the reviews that count towards a product's rating
+func ratedFilter() bson.M {
+	return bson.M{"status": ReviewApproved, "rating": bson.M{"$gte": minRating, "$lte": maxRating}}
+}
+
+// applyRatingChange updates a product's aggregates for one review being
+// removed (removed > 0), added (added > 0) or re-rated (both). The update is
+// a single pipeline on the product document, so concurrent reviews can't
+// lose each other's changes.
+func applyRatingChange(ctx context.Context, productID string, removed, added int) error {
+	objectID, err := primitive.ObjectIDFromHex(productID)
+	if err != nil || (removed == 0 && added == 0) {
+		return nil
+	}
+
+	countDelta, sumDelta := 0, added-removed
+	histogram := map[int]int{}
+	if removed > 0 {
+		countDelta--
+		histogram[removed]--
+	}
+	if added > 0 {
+		countDelta++
+		histogram[added]++
+	}
+
+	increment := func(field string, delta int) bson.M {
+		return bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + field, 0}}, delta}}
+	}
+	counts := bson.M{
+		"review_count": increment("review_count", countDelta),
+		"rating_sum":   increment("rating_sum", sumDelta),
+	}
+	for star, delta := range histogram {
+		if delta != 0 {
+			field := "rating_histogram." + strconv.Itoa(star)
+			counts[field] = increment(field, delta)
+		}
+	}
+
+	_, err = config.GetMongoDatabase().Collection("products").UpdateOne(ctx, bson.M{"_id": objectID}, ratingUpdate(counts))
+	return err
+}
+
+// averageExpr computes rating from rating_sum and review_count, to two decimals
+func averageExpr() bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{"$review_count", 0}},
+		bson.M{"$round": bson.A{bson.M{"$divide": bson.A{"$rating_sum", "$review_count"}}, 2}},
+		0,
+	}}
+}
+
+// ratingAggregates sums the rated reviews matching filter per product
+func ratingAggregates(ctx context.Context, filter bson.M) (map[string]bson.M, error) {
+	groupStage := bson.M{
+		"_id":   "$product_id",
+		"count": bson.M{"$sum": 1},
+		"sum":   bson.M{"$sum": "$rating"},
+	}
+	for star := minRating; star <= maxRating; star++ {
+		groupStage[strconv.Itoa(star)] = bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$rating", star}}, 1, 0}}}
+	}
+	match := ratedFilter()
+	for field, value := range filter {
+		match[field] = value
+	}
+	cursor, err := config.GetMongoDatabase().Collection("reviews").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: groupStage}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	aggregates := map[string]bson.M{}
+	for cursor.Next(ctx) {
+		var group bson.M
+		if err := cursor.Decode(&group); err != nil {
+			return nil, err
+		}
+		productID, _ := group["_id"].(string)
+		histogram := bson.M{}
+		for star := minRating; star <= maxRating; star++ {
+			histogram[strconv.Itoa(star)] = group[strconv.Itoa(star)]
+		}
+		aggregates[productID] = bson.M{"review_count": group["count"], "rating_sum": group["sum"], "rating_histogram": histogram}
+	}
+	return aggregates, cursor.Err()
+}
+
+// ratingUpdate writes counts and the average derived from them
+func ratingUpdate(counts bson.M) mongo.Pipeline {
+	if counts == nil {
+		counts = bson.M{"review_count": 0, "rating_sum": 0, "rating_histogram": bson.M{}}
+	}
+	return mongo.Pipeline{
+		{{Key: "$set", Value: counts}},
+		{{Key: "$set", Value: bson.M{"rating": averageExpr()}}},
+	}
+}
+
+// recomputeRating rebuilds one product's aggregates from its reviews
+func recomputeRating(ctx context.Context, productID string) error {
+	objectID, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return nil
+	}
+	aggregates, err := ratingAggregates(ctx, bson.M{"product_id": productID})
+	if err != nil {
+		return err
+	}
+	_, err = config.GetMongoDatabase().Collection("products").UpdateOne(ctx, bson.M{"_id": objectID}, ratingUpdate(aggregates[productID]))
+	return err
+}
+
+// RepairRatings recomputes every product's rating aggregates from the
+// reviews collection, returning the number of products written
+func RepairRatings(ctx context.Context) (int, error) {
+	db := config.GetMongoDatabase()
+	aggregates, err := ratingAggregates(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	products := db.Collection("products")
+	productCursor, err := products.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer productCursor.Close(ctx)
+
+	var writes []mongo.WriteModel
+	for productCursor.Next(ctx) {
+		var product struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := productCursor.Decode(&product); err != nil {
+			return 0, err
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": product.ID}).
+			SetUpdate(ratingUpdate(aggregates[product.ID.Hex()])))
+	}
+	if err := productCursor.Err(); err != nil {
+		return 0, err
+	}
+	if len(writes) == 0 {
+		return 0, nil
+	}
+
+	if _, err := products.BulkWrite(ctx, writes); err != nil {
+		return 0, err
+	}
+	return len(writes), nil
+}
+
+func GetRatingSummary(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var product struct {
+		Rating      float64        `bson:"rating"`
+		ReviewCount int            `bson:"review_count"`
+		Histogram   map[string]int `bson:"rating_histogram"`
+	}
+	err = config.GetMongoDatabase().Collection("products").FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&product)
+	if err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	summary := models.RatingSummary{ProductID: id, Average: product.Rating, ReviewCount: product.ReviewCount, Histogram: map[string]int{}}
+	for star := minRating; star <= maxRating; star++ {
+		key := strconv.Itoa(star)
+		summary.Histogram[key] = product.Histogram[key]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -3,19 +3,100 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"sample-application/config"
+	"sample-application/logging"
 	"sample-application/models"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Review Handlers (MongoDB)
+
+// Errors for reviews that name a product or user that doesn't exist
+var (
+	errReviewedProductNotFound = errors.New("product not found")
+	errReviewerNotFound        = errors.New("user not found")
+)
+
+// verifiedPurchase reports whether the user has a delivered order that
+// contains the product
+func verifiedPurchase(ctx context.Context, userID int, productID string) (bool, error) {
+	var verified bool
+	err := config.PostgresDB.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM orders o JOIN order_items oi ON oi.order_id = o.id
+		WHERE o.user_id = $1 AND oi.product_id = $2 AND o.status = 'delivered')`, userID, productID).Scan(&verified)
+	return verified, err
+}
+
+// markVerifiedPurchases flags the reviews its buyer already wrote for the
+// products in a newly delivered order
+func markVerifiedPurchases(ctx context.Context, orderID string) error {
+	// Orders of deleted users have no user_id and so match no reviews
+	rows, err := config.PostgresDB.QueryContext(ctx, `SELECT o.user_id, oi.product_id
+		FROM orders o JOIN order_items oi ON oi.order_id = o.id WHERE o.id = $1 AND o.user_id IS NOT NULL`, orderID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var userID int
+	productIDs := bson.A{}
+	for rows.Next() {
+		var productID string
+		if err := rows.Scan(&userID, &productID); err != nil {
+			return err
+		}
+		productIDs = append(productIDs, productID)
+	}
+	if err := rows.Err(); err != nil || len(productIDs) == 0 {
+		return err
+	}
+
+	_, err = config.GetMongoDatabase().Collection("reviews").UpdateMany(ctx,
+		bson.M{"user_id": userID, "product_id": bson.M{"$in": productIDs}},
+		bson.M{"$set": bson.M{"verified_purchase": true}})
+	return err
+}
+
+// checkReviewer confirms the review's product exists in MongoDB and its user
+// in PostgreSQL, returning whether the user bought the product
+func checkReviewer(ctx context.Context, review models.Review) (bool, error) {
+	productID, err := primitive.ObjectIDFromHex(review.ProductID)
+	if err != nil {
+		return false, errReviewedProductNotFound
+	}
+	count, err := config.GetMongoDatabase().Collection("products").CountDocuments(ctx, bson.M{"_id": productID})
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, errReviewedProductNotFound
+	}
+
+	var exists bool
+	err = config.PostgresDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, review.UserID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, errReviewerNotFound
+	}
+	return verifiedPurchase(ctx, review.UserID, review.ProductID)
+}
+
+// CreateReview adds a review. Each user may review a product once; the
+// review is marked verified_purchase when they have received it, and is
+// screened before it is published.
 func CreateReview(w http.ResponseWriter, r *http.Request) {
 	var review models.Review
 	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
@@ -23,11 +104,49 @@ func CreateReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if review.Rating < minRating || review.Rating > maxRating {
+		http.Error(w, "rating must be between 1 and 5", http.StatusBadRequest)
+		return
+	}
+
+	verified, err := checkReviewer(r.Context(), review)
+	switch err {
+	case nil:
+	case errReviewedProductNotFound:
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	case errReviewerNotFound:
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status, reasons, err := screenReview(review.Comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	review.ID = ""
+	review.Status = status
+	review.ModerationReasons = reasons
+	review.ReportCount = 0
+	review.ModerationNote, review.ModeratedBy, review.ModeratedAt = "", "", nil
 	review.CreatedAt = time.Now()
+	review.UpdatedAt = review.CreatedAt
 	review.Helpful = 0
+	review.NotHelpful = 0
+	review.HelpfulScore = 0
+	review.VerifiedPurchase = verified
 
 	collection := config.GetMongoDatabase().Collection("reviews")
-	result, err := collection.InsertOne(context.Background(), review)
+	result, err := collection.InsertOne(r.Context(), review)
+	if mongo.IsDuplicateKeyError(err) {
+		http.Error(w, "User has already reviewed this product; edit the existing review instead", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -35,27 +154,94 @@ func CreateReview(w http.ResponseWriter, r *http.Request) {
 
 	review.ID = result.InsertedID.(primitive.ObjectID).Hex()
 
+	moveReviewRating(r.Context(), models.Review{}, review)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(review)
 }
 
+// reviewSorts maps the sort parameter of review listings to a sort order
+var reviewSorts = map[string]bson.D{
+	"recent":  {{Key: "created_at", Value: -1}},
+	"rating":  {{Key: "rating", Value: -1}, {Key: "created_at", Value: -1}},
+	"helpful": {{Key: "helpful_score", Value: -1}, {Key: "helpful", Value: -1}, {Key: "created_at", Value: -1}},
+}
+
+// GetProductReviews lists a product's approved reviews. It accepts verified,
+// rating, min_rating, sort (recent, rating or helpful), limit and offset.
 func GetProductReviews(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["product_id"]
+	params := r.URL.Query()
+
+	filter := bson.M{"product_id": productID, "status": ReviewApproved}
+	if v := params.Get("verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "verified must be true or false", http.StatusBadRequest)
+			return
+		}
+		filter["verified_purchase"] = verified
+	}
+	for _, bound := range []struct{ param, op string }{{"rating", "$eq"}, {"min_rating", "$gte"}} {
+		value := params.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		rating, err := strconv.Atoi(value)
+		if err != nil || rating < minRating || rating > maxRating {
+			http.Error(w, bound.param+" must be between 1 and 5", http.StatusBadRequest)
+			return
+		}
+		conditions, _ := filter["rating"].(bson.M)
+		if conditions == nil {
+			conditions = bson.M{}
+			filter["rating"] = conditions
+		}
+		conditions[bound.op] = rating
+	}
+
+	sortBy := params.Get("sort")
+	if sortBy == "" {
+		sortBy = "recent"
+	}
+	order, ok := reviewSorts[sortBy]
+	if !ok {
+		http.Error(w, "sort must be recent, rating or helpful", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := 100, 0
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if o := params.Get("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
 
 	collection := config.GetMongoDatabase().Collection("reviews")
-	filter := bson.M{"product_id": productID}
+	opts := options.Find().SetSort(order).SetLimit(int64(limit)).SetSkip(int64(offset))
 
-	cursor, err := collection.Find(context.Background(), filter)
+	cursor, err := collection.Find(r.Context(), filter, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(r.Context())
 
 	reviews := []models.Review{}
-	if err := cursor.All(context.Background(), &reviews); err != nil {
+	if err := cursor.All(r.Context(), &reviews); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -64,7 +250,26 @@ func GetProductReviews(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(reviews)
 }
 
-func DeleteReview(w http.ResponseWriter, r *http.Request) {
+// UserHeader names the user a request acts as, on endpoints whose route
+// doesn't. The API has no authentication, so it is trusted as sent: a
+// gateway in front of the API must set it from the caller's credentials.
+const UserHeader = "X-User-ID"
+
+// requestUser returns the user ID from UserHeader, or 0 if it is missing
+// or not a positive integer
+func requestUser(r *http.Request) int {
+	id, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(UserHeader)))
+	if err != nil || id < 1 {
+		return 0
+	}
+	return id
+}
+
+// UpdateReview lets a review's author, named by the X-User-ID header,
+// change its rating and comment. An edited comment is screened again: it
+// may need re-approval, and a rejected or flagged review goes back to
+// pending rather than being approved without a moderator.
+func UpdateReview(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
@@ -73,126 +278,132 @@ func DeleteReview(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid review ID", http.StatusBadRequest)
 		return
 	}
-
-	collection := config.GetMongoDatabase().Collection("reviews")
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	userID := requestUser(r)
+	if userID == 0 {
+		http.Error(w, UserHeader+" header with the author's user ID is required", http.StatusUnauthorized)
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		http.Error(w, "Review not found", http.StatusNotFound)
+	var data struct {
+		Rating  int     `json:"rating"`
+		Comment *string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Review deleted successfully"})
-}
-
-func MarkReviewHelpful(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		http.Error(w, "Invalid review ID", http.StatusBadRequest)
+	if data.Rating != 0 && (data.Rating < minRating || data.Rating > maxRating) {
+		http.Error(w, "rating must be between 1 and 5", http.StatusBadRequest)
 		return
 	}
 
 	collection := config.GetMongoDatabase().Collection("reviews")
-	update := bson.M{"$inc": bson.M{"helpful": 1}}
-	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
-	if err != nil {
+	var review models.Review
+	if err := collection.FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&review); err == mongo.ErrNoDocuments {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	if result.MatchedCount == 0 {
-		http.Error(w, "Review not found", http.StatusNotFound)
+	if review.UserID != userID {
+		http.Error(w, "Only the review's author can edit it", http.StatusForbidden)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Review marked as helpful"})
-}
-
-// Wishlist Handlers (MongoDB)
-func GetWishlist(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
-	userIDInt, _ := strconv.Atoi(userID)
-
-	collection := config.GetMongoDatabase().Collection("wishlist")
-	filter := bson.M{"user_id": userIDInt}
-
-	cursor, err := collection.Find(context.Background(), filter)
+	verified, err := verifiedPurchase(r.Context(), review.UserID, review.ProductID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
-
-	wishlist := []models.Wishlist{}
-	if err := cursor.All(context.Background(), &wishlist); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	set := bson.M{"updated_at": time.Now(), "verified_purchase": verified}
+	if data.Rating != 0 {
+		set["rating"] = data.Rating
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(wishlist)
-}
-
-func AddToWishlist(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
-	userIDInt, _ := strconv.Atoi(userID)
-
-	var data map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	var reasons []string
+	if data.Comment != nil {
+		set["comment"] = *data.Comment
+		status, screened, err := screenReview(*data.Comment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Only a moderator can approve a review they turned down or that
+		// customers reported
+		if status == ReviewApproved && (review.Status == ReviewRejected || review.Status == ReviewFlagged) {
+			status = ReviewPending
+		}
+		set["status"] = status
+		reasons = screened
+		set["moderation_reasons"] = reasons
 	}
 
-	wishlistItem := models.Wishlist{
-		UserID:    userIDInt,
-		ProductID: data["product_id"],
-		AddedAt:   time.Now(),
+	// Returning the document as it was before the update gives the rating
+	// that is actually replaced, even if another edit got in first. The
+	// new status was derived from the old one, so a moderation decision in
+	// between makes the edit fail.
+	filter := bson.M{"_id": objectID, "user_id": userID}
+	if data.Comment != nil {
+		filter["status"] = review.Status
+	}
+	var before models.Review
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = collection.FindOneAndUpdate(r.Context(), filter, bson.M{"$set": set}, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Review was deleted or moderated while being edited; fetch it and retry", http.StatusConflict)
+		return
 	}
-
-	collection := config.GetMongoDatabase().Collection("wishlist")
-	result, err := collection.InsertOne(context.Background(), wishlistItem)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	wishlistItem.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	review = before
+	review.UpdatedAt = set["updated_at"].(time.Time)
+	review.VerifiedPurchase = verified
+	if data.Rating != 0 {
+		review.Rating = data.Rating
+	}
+	if data.Comment != nil {
+		review.Comment = *data.Comment
+		review.Status = set["status"].(string)
+		review.ModerationReasons = reasons
+	}
+	moveReviewRating(r.Context(), before, review)
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(wishlistItem)
+	json.NewEncoder(w).Encode(review)
 }
 
-func RemoveFromWishlist(w http.ResponseWriter, r *http.Request) {
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	userID := vars["user_id"]
-	productID := vars["product_id"]
-	userIDInt, _ := strconv.Atoi(userID)
+	id := vars["id"]
 
-	collection := config.GetMongoDatabase().Collection("wishlist")
-	filter := bson.M{"user_id": userIDInt, "product_id": productID}
-	result, err := collection.DeleteOne(context.Background(), filter)
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid review ID", http.StatusBadRequest)
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		http.Error(w, "Wishlist item not found", http.StatusNotFound)
+	collection := config.GetMongoDatabase().Collection("reviews")
+	var review models.Review
+	err = collection.FindOneAndDelete(r.Context(), bson.M{"_id": objectID}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	moveReviewRating(r.Context(), review, models.Review{ProductID: review.ProductID})
+	for _, collection := range []string{"review_votes", "review_reports"} {
+		if _, err := config.GetMongoDatabase().Collection(collection).DeleteMany(r.Context(), bson.M{"review_id": id}); err != nil {
+			logging.FromContext(r.Context()).Error("deleting review data failed", "collection", collection, "review_id", id, "error", err)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Item removed from wishlist"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "Review deleted successfully"})
 }
@@ -24,9 +24,9 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `INSERT INTO users (name, email, password, address, phone) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at`
-	err := config.PostgresDB.QueryRow(query, user.Name, user.Email, user.Password, user.Address, user.Phone).
-		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	query := `INSERT INTO users (name, email, password, address, phone) VALUES ($1, $2, $3, $4, $5) RETURNING id, version, created_at, updated_at`
+	err := config.PostgresDB.QueryRowContext(r.Context(), query, user.Name, user.Email, user.Password, user.Address, user.Phone).
+		Scan(&user.ID, &user.Version, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -34,13 +34,14 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.Password = "" // Don't return password
+	w.Header().Set("ETag", versionETag(user.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
 }
 
 func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.PostgresDB.Query(`SELECT id, name, email, address, phone, created_at, updated_at FROM users LIMIT 100`)
+	rows, err := config.PostgresDB.QueryContext(r.Context(), `SELECT id, name, email, address, phone, version, created_at, updated_at FROM users LIMIT 100`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -50,7 +51,7 @@ func GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	users := []models.User{}
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Address, &user.Phone, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Address, &user.Phone, &user.Version, &user.CreatedAt, &user.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -66,8 +67,8 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	var user models.User
-	query := `SELECT id, name, email, address, phone, created_at, updated_at FROM users WHERE id = $1`
-	err := config.PostgresDB.QueryRow(query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Address, &user.Phone, &user.CreatedAt, &user.UpdatedAt)
+	query := `SELECT id, name, email, address, phone, version, created_at, updated_at FROM users WHERE id = $1`
+	err := config.PostgresDB.QueryRowContext(r.Context(), query, id).Scan(&user.ID, &user.Name, &user.Email, &user.Address, &user.Phone, &user.Version, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -78,6 +79,10 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if notModified(w, r, user.Version) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -86,54 +91,39 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
 
-	query := `UPDATE users SET name = $1, email = $2, address = $3, phone = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5`
-	result, err := config.PostgresDB.Exec(query, user.Name, user.Email, user.Address, user.Phone, id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var user models.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
+	query := `UPDATE users SET name = $1, email = $2, address = $3, phone = $4, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $5 AND ($6 = 0 OR version = $6) RETURNING version`
+	err := config.PostgresDB.QueryRowContext(r.Context(), query, user.Name, user.Email, user.Address, user.Phone, id, expected).Scan(&user.Version)
+	if err == sql.ErrNoRows {
+		writeConditionalMiss(w, r, config.PostgresDB, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id, "User not found")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "User updated successfully"})
-}
-
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	result, err := config.PostgresDB.Exec(`DELETE FROM users WHERE id = $1`, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
+	w.Header().Set("ETag", versionETag(user.Version))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "User deleted successfully"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "User updated successfully"})
 }
 
 func GetUserOrders(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
-	rows, err := config.PostgresDB.Query(`SELECT id, user_id, total_amount, status, payment_method, shipping_address, created_at, updated_at FROM orders WHERE user_id = $1`, userID)
+	rows, err := config.PostgresDB.QueryContext(r.Context(), `SELECT id, COALESCE(user_id, 0), total_amount, status, payment_method, shipping_address, warehouse_id, version, created_at, updated_at FROM orders WHERE user_id = $1`, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -143,7 +133,7 @@ func GetUserOrders(w http.ResponseWriter, r *http.Request) {
 	orders := []models.Order{}
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.CreatedAt, &order.UpdatedAt)
+		err := rows.Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddress, &order.WarehouseID, &order.Version, &order.CreatedAt, &order.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -159,7 +149,7 @@ func GetCart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	rows, err := config.PostgresDB.Query(`SELECT id, user_id, product_id, quantity, created_at, updated_at FROM cart WHERE user_id = $1`, userID)
+	rows, err := config.PostgresDB.QueryContext(r.Context(), `SELECT id, user_id, product_id, quantity, created_at, updated_at FROM cart WHERE user_id = $1`, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -197,19 +187,12 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 			  ON CONFLICT (user_id, product_id) DO UPDATE SET quantity = cart.quantity + $3, updated_at = CURRENT_TIMESTAMP
 			  RETURNING id, created_at, updated_at`
 
-	// Note: This requires a unique constraint on (user_id, product_id)
-	err := config.PostgresDB.QueryRow(query, item.UserID, item.ProductID, item.Quantity).
+	// Relies on the cart_user_product_key unique index (migration 0002)
+	err := config.PostgresDB.QueryRowContext(r.Context(), query, item.UserID, item.ProductID, item.Quantity).
 		Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
-
 	if err != nil {
-		// Fallback to simple insert if constraint doesn't exist
-		query = `INSERT INTO cart (user_id, product_id, quantity) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at`
-		err = config.PostgresDB.QueryRow(query, item.UserID, item.ProductID, item.Quantity).
-			Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -222,7 +205,7 @@ func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	userID := vars["user_id"]
 	itemID := vars["item_id"]
 
-	result, err := config.PostgresDB.Exec(`DELETE FROM cart WHERE id = $1 AND user_id = $2`, itemID, userID)
+	result, err := config.PostgresDB.ExecContext(r.Context(), `DELETE FROM cart WHERE id = $1 AND user_id = $2`, itemID, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -242,7 +225,7 @@ func ClearCart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["user_id"]
 
-	_, err := config.PostgresDB.Exec(`DELETE FROM cart WHERE user_id = $1`, userID)
+	_, err := config.PostgresDB.ExecContext(r.Context(), `DELETE FROM cart WHERE user_id = $1`, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
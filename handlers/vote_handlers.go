@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"sample-application/config"
+	"sample-application/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Helpfulness votes are stored one per (review, user) in review_votes. The
+// review keeps the helpful and not_helpful totals plus helpful_score, the
+// lower bound of the Wilson score interval, which ranks a review with 40 of
+// 50 up votes above one with a single up vote.
+
+const (
+	VoteUp      = "up"
+	VoteDown    = "down"
+	VoteRetract = "retract"
+)
+
+// wilsonZ is the z-score for 95% confidence
+const wilsonZ = 1.96
+
+// helpfulScoreExpr computes helpful_score from the review's vote totals
+func helpfulScoreExpr() bson.M {
+	n := bson.M{"$add": bson.A{"$helpful", "$not_helpful"}}
+	z2 := wilsonZ * wilsonZ
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{n, 0}},
+		0,
+		bson.M{"$let": bson.M{
+			"vars": bson.M{"n": n, "p": bson.M{"$divide": bson.A{"$helpful", n}}},
+			// (p + z²/2n - z·√((p(1-p) + z²/4n) / n)) / (1 + z²/n)
+			"in": bson.M{"$round": bson.A{bson.M{"$divide": bson.A{
+				bson.M{"$subtract": bson.A{
+					bson.M{"$add": bson.A{"$$p", bson.M{"$divide": bson.A{z2 / 2, "$$n"}}}},
+					bson.M{"$multiply": bson.A{wilsonZ, bson.M{"$sqrt": bson.M{"$divide": bson.A{
+						bson.M{"$add": bson.A{
+							bson.M{"$multiply": bson.A{"$$p", bson.M{"$subtract": bson.A{1, "$$p"}}}},
+							bson.M{"$divide": bson.A{z2 / 4, "$$n"}},
+						}},
+						"$$n",
+					}}}}},
+				}},
+				bson.M{"$add": bson.A{1, bson.M{"$divide": bson.A{z2, "$$n"}}}},
+			}}, 4}},
+		}},
+	}}
+}
+
+// castReviewVote records the user's vote (up, down or retract) and returns
+// the review with its updated totals. Each write to review_votes hands back
+// the vote it replaced, so concurrent votes by the same user adjust the
+// totals exactly once each.
+func castReviewVote(ctx context.Context, reviewID primitive.ObjectID, userID int, vote string) (models.Review, error) {
+	votes := config.GetMongoDatabase().Collection("review_votes")
+	filter := bson.M{"review_id": reviewID.Hex(), "user_id": userID}
+
+	var previous models.ReviewVote
+	var err error
+	if vote == VoteRetract {
+		err = votes.FindOneAndDelete(ctx, filter).Decode(&previous)
+	} else {
+		now := time.Now()
+		update := bson.M{
+			"$set":         bson.M{"vote": vote, "updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+		err = votes.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+		if mongo.IsDuplicateKeyError(err) {
+			// A concurrent first vote by the same user inserted the document
+			// between our lookup and insert; it exists now, so this update
+			// replaces that vote
+			previous = models.ReviewVote{}
+			err = votes.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+		}
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return models.Review{}, err
+	}
+
+	delta := func(side string) int {
+		d := 0
+		if vote == side {
+			d++
+		}
+		if previous.Vote == side {
+			d--
+		}
+		return d
+	}
+	up, down := delta(VoteUp), delta(VoteDown)
+
+	reviews := config.GetMongoDatabase().Collection("reviews")
+	var review models.Review
+	if up == 0 && down == 0 {
+		err = reviews.FindOne(ctx, bson.M{"_id": reviewID}).Decode(&review)
+		return review, err
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"helpful":     bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$helpful", 0}}, up}},
+			"not_helpful": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$not_helpful", 0}}, down}},
+		}}},
+		{{Key: "$set", Value: bson.M{"helpful_score": helpfulScoreExpr()}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = reviews.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, update, opts).Decode(&review)
+	return review, err
+}
+
+// VoteOnReview records an up, down or retract vote on a review by the user
+// named in the X-User-ID header
+func VoteOnReview(w http.ResponseWriter, r *http.Request) {
+	userID := requestUser(r)
+	if userID == 0 {
+		http.Error(w, UserHeader+" header with the voter's user ID is required", http.StatusUnauthorized)
+		return
+	}
+	var data struct {
+		Vote string `json:"vote"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Vote != VoteUp && data.Vote != VoteDown && data.Vote != VoteRetract {
+		http.Error(w, "vote must be up, down or retract", http.StatusBadRequest)
+		return
+	}
+	writeReviewVote(w, r, userID, data.Vote)
+}
+
+// MarkReviewHelpful is kept for existing clients of
+// POST /api/reviews/{id}/helpful. With X-User-ID it records that user's up
+// vote and returns the review. Without it, it counts an anonymous helpful
+// mark as it always has: one that nobody can change or retract, like the
+// counts migration 4 carried over.
+func MarkReviewHelpful(w http.ResponseWriter, r *http.Request) {
+	if userID := requestUser(r); userID != 0 {
+		writeReviewVote(w, r, userID, VoteUp)
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid review ID", http.StatusBadRequest)
+		return
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"helpful": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$helpful", 0}}, 1}}}}},
+		{{Key: "$set", Value: bson.M{"helpful_score": helpfulScoreExpr()}}},
+	}
+	result, err := config.GetMongoDatabase().Collection("reviews").UpdateOne(r.Context(), bson.M{"_id": objectID, "status": ReviewApproved}, update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Review marked as helpful"})
+}
+
+func writeReviewVote(w http.ResponseWriter, r *http.Request, userID int, vote string) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid review ID", http.StatusBadRequest)
+		return
+	}
+	var review models.Review
+	// Only published reviews can be voted on
+	err = config.GetMongoDatabase().Collection("reviews").FindOne(r.Context(), bson.M{"_id": objectID, "status": ReviewApproved}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if review.UserID == userID {
+		http.Error(w, "Authors can't vote on their own review", http.StatusForbidden)
+		return
+	}
+
+	var exists bool
+	err = config.PostgresDB.QueryRowContext(r.Context(), `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	review, err = castReviewVote(r.Context(), objectID, userID, vote)
+	if err == mongo.ErrNoDocuments {
+		// Deleted while the vote was being recorded
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sample-application/config"
+	"sample-application/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	TransferInTransit = "in_transit"
+	TransferReceived  = "received"
+	TransferCancelled = "cancelled"
+)
+
+var errInsufficientStock = errors.New("insufficient stock at warehouse")
+
+// Warehouse Handlers (MySQL)
+func CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	var warehouse models.Warehouse
+	if err := json.NewDecoder(r.Body).Decode(&warehouse); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if warehouse.Code == "" || warehouse.Name == "" {
+		http.Error(w, "code and name are required", http.StatusBadRequest)
+		return
+	}
+	warehouse.Active = true
+
+	query := `INSERT INTO warehouses (code, name, address, city, state, country, active) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := config.MySQLDB.ExecContext(r.Context(), query, warehouse.Code, warehouse.Name, warehouse.Address, warehouse.City, warehouse.State, warehouse.Country, warehouse.Active)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	warehouse.ID = int(id)
+	err = config.MySQLDB.QueryRowContext(r.Context(), `SELECT created_at, updated_at FROM warehouses WHERE id = ?`, warehouse.ID).
+		Scan(&warehouse.CreatedAt, &warehouse.UpdatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(warehouse)
+}
+
+func GetAllWarehouses(w http.ResponseWriter, r *http.Request) {
+	rows, err := config.MySQLDB.QueryContext(r.Context(), `SELECT id, code, name, COALESCE(address, ''), COALESCE(city, ''), COALESCE(state, ''), COALESCE(country, ''), active, created_at, updated_at FROM warehouses ORDER BY id`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	warehouses := []models.Warehouse{}
+	for rows.Next() {
+		var wh models.Warehouse
+		err := rows.Scan(&wh.ID, &wh.Code, &wh.Name, &wh.Address, &wh.City, &wh.State, &wh.Country, &wh.Active, &wh.CreatedAt, &wh.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		warehouses = append(warehouses, wh)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouses)
+}
+
+// SetWarehouseStock sets the on-hand quantity of a product at one
+// warehouse. Since it changes the product's inventory total, it takes the
+// inventory's ETag in If-Match like UpdateInventory and returns the new one.
+func SetWarehouseStock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID := vars["product_id"]
+	warehouseID, err := strconv.Atoi(vars["warehouse_id"])
+	if err != nil {
+		http.Error(w, "Invalid warehouse ID", http.StatusBadRequest)
+		return
+	}
+
+	var data struct {
+		Quantity int    `json:"quantity"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quantity := data.Quantity
+	if quantity < 0 {
+		http.Error(w, "quantity must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.MySQLDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkInventoryVersion(w, r, tx, productID) {
+		return
+	}
+	if _, err := resolveWarehouse(r.Context(), tx, warehouseID); err == sql.ErrNoRows {
+		http.Error(w, "Warehouse not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	movement := stockMovement{Type: MovementAdjustment, Reason: data.Reason, Actor: requestActor(r)}
+	if _, err := setWarehouseStock(r.Context(), tx, productID, warehouseID, quantity, movement); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := syncInventoryTotal(r.Context(), tx, productID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	version, err := inventoryVersion(r.Context(), tx, productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Warehouse stock updated successfully"})
+}
+
+func GetProductAvailability(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID := vars["product_id"]
+
+	availability := models.ProductAvailability{ProductID: productID, Warehouses: []models.WarehouseStock{}}
+	indexByWarehouse := map[int]int{}
+
+	rows, err := config.MySQLDB.QueryContext(r.Context(), `SELECT ws.warehouse_id, w.code, ws.quantity, ws.updated_at
+		FROM warehouse_stock ws JOIN warehouses w ON w.id = ws.warehouse_id
+		WHERE ws.product_id = ? ORDER BY ws.warehouse_id`, productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		stock := models.WarehouseStock{ProductID: productID}
+		if err := rows.Scan(&stock.WarehouseID, &stock.WarehouseCode, &stock.Quantity, &stock.UpdatedAt); err != nil {
+			continue
+		}
+		availability.OnHand += stock.Quantity
+		indexByWarehouse[stock.WarehouseID] = len(availability.Warehouses)
+		availability.Warehouses = append(availability.Warehouses, stock)
+	}
+
+	inbound, err := config.MySQLDB.QueryContext(r.Context(), `SELECT t.to_warehouse_id, w.code, SUM(t.quantity)
+		FROM stock_transfers t JOIN warehouses w ON w.id = t.to_warehouse_id
+		WHERE t.product_id = ? AND t.status = ? GROUP BY t.to_warehouse_id, w.code`, productID, TransferInTransit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer inbound.Close()
+
+	for inbound.Next() {
+		var warehouseID, quantity int
+		var code string
+		if err := inbound.Scan(&warehouseID, &code, &quantity); err != nil {
+			continue
+		}
+		availability.InTransit += quantity
+		if i, ok := indexByWarehouse[warehouseID]; ok {
+			availability.Warehouses[i].InboundQty = quantity
+		} else {
+			availability.Warehouses = append(availability.Warehouses, models.WarehouseStock{
+				ProductID: productID, WarehouseID: warehouseID, WarehouseCode: code, InboundQty: quantity,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(availability)
+}
+
+// Stock Transfer Handlers (MySQL)
+func CreateStockTransfer(w http.ResponseWriter, r *http.Request) {
+	var transfer models.StockTransfer
+	if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if transfer.ProductID == "" || transfer.Quantity <= 0 {
+		http.Error(w, "product_id and a positive quantity are required", http.StatusBadRequest)
+		return
+	}
+	if transfer.FromWarehouseID <= 0 || transfer.ToWarehouseID <= 0 {
+		http.Error(w, "from_warehouse_id and to_warehouse_id are required", http.StatusBadRequest)
+		return
+	}
+	if transfer.FromWarehouseID == transfer.ToWarehouseID {
+		http.Error(w, "from_warehouse_id and to_warehouse_id must differ", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := config.MySQLDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int{transfer.FromWarehouseID, transfer.ToWarehouseID} {
+		if _, err := resolveWarehouse(r.Context(), tx, id); err == sql.ErrNoRows {
+			http.Error(w, "Warehouse not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	transfer.Status = TransferInTransit
+	result, err := tx.ExecContext(r.Context(), `INSERT INTO stock_transfers (product_id, from_warehouse_id, to_warehouse_id, quantity, status) VALUES (?, ?, ?, ?, ?)`,
+		transfer.ProductID, transfer.FromWarehouseID, transfer.ToWarehouseID, transfer.Quantity, transfer.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	transfer.ID = int(id)
+
+	// Stock leaves the source as soon as it ships
+	movement := stockMovement{Type: MovementTransfer, Reason: "transfer out", Actor: requestActor(r), ReferenceID: transferReference(transfer.ID)}
+	_, err = adjustWarehouseStock(r.Context(), tx, transfer.ProductID, transfer.FromWarehouseID, -transfer.Quantity, movement)
+	if err == errInsufficientStock {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := syncInventoryTotal(r.Context(), tx, transfer.ProductID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.QueryRowContext(r.Context(), `SELECT created_at FROM stock_transfers WHERE id = ?`, transfer.ID).Scan(&transfer.CreatedAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+func GetStockTransfers(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT id, product_id, from_warehouse_id, to_warehouse_id, quantity, status, created_at, completed_at FROM stock_transfers`
+	var conditions []string
+	var args []interface{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, status)
+	}
+	if productID := r.URL.Query().Get("product_id"); productID != "" {
+		conditions = append(conditions, "product_id = ?")
+		args = append(args, productID)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT 100"
+
+	rows, err := config.MySQLDB.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	transfers := []models.StockTransfer{}
+	for rows.Next() {
+		var t models.StockTransfer
+		err := rows.Scan(&t.ID, &t.ProductID, &t.FromWarehouseID, &t.ToWarehouseID, &t.Quantity, &t.Status, &t.CreatedAt, &t.CompletedAt)
+		if err != nil {
+			continue
+		}
+		transfers = append(transfers, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfers)
+}
+
+func ReceiveStockTransfer(w http.ResponseWriter, r *http.Request) {
+	completeStockTransfer(w, r, TransferReceived)
+}
+
+func CancelStockTransfer(w http.ResponseWriter, r *http.Request) {
+	completeStockTransfer(w, r, TransferCancelled)
+}
+
+// completeStockTransfer moves an in-transit transfer to its final status,
+// crediting the destination on receipt or the source on cancellation
+func completeStockTransfer(w http.ResponseWriter, r *http.Request, status string) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	tx, err := config.MySQLDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var t models.StockTransfer
+	err = tx.QueryRowContext(r.Context(), `SELECT id, product_id, from_warehouse_id, to_warehouse_id, quantity, status FROM stock_transfers WHERE id = ? FOR UPDATE`, id).
+		Scan(&t.ID, &t.ProductID, &t.FromWarehouseID, &t.ToWarehouseID, &t.Quantity, &t.Status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t.Status != TransferInTransit {
+		http.Error(w, "Transfer is already "+t.Status, http.StatusConflict)
+		return
+	}
+
+	warehouseID, reason := t.ToWarehouseID, "transfer received"
+	if status == TransferCancelled {
+		warehouseID, reason = t.FromWarehouseID, "transfer cancelled"
+	}
+	movement := stockMovement{Type: MovementTransfer, Reason: reason, Actor: requestActor(r), ReferenceID: transferReference(t.ID)}
+	if _, err := adjustWarehouseStock(r.Context(), tx, t.ProductID, warehouseID, t.Quantity, movement); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), `UPDATE stock_transfers SET status = ?, completed_at = NOW() WHERE id = ?`, status, t.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := syncInventoryTotal(r.Context(), tx, t.ProductID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Transfer " + status})
+}
+
+// transferReference is the ledger reference id for a stock transfer
+func transferReference(id int) string {
+	return "transfer:" + strconv.Itoa(id)
+}
+
+// resolveWarehouse returns id if it names an existing warehouse, or the
+// primary warehouse (code DEFAULT, else the lowest id) when id is zero
+func resolveWarehouse(ctx context.Context, tx *sql.Tx, id int) (int, error) {
+	if id > 0 {
+		err := tx.QueryRowContext(ctx, `SELECT id FROM warehouses WHERE id = ?`, id).Scan(&id)
+		return id, err
+	}
+	err := tx.QueryRowContext(ctx, `SELECT id FROM warehouses WHERE active ORDER BY code = 'DEFAULT' DESC, id LIMIT 1`).Scan(&id)
+	return id, err
+}
+
+// lockWarehouseStock returns the on-hand quantity at one warehouse, locking
+// the row for the rest of the transaction
+func lockWarehouseStock(ctx context.Context, tx *sql.Tx, productID string, warehouseID int) (int, error) {
+	var current int
+	err := tx.QueryRowContext(ctx, `SELECT quantity FROM warehouse_stock WHERE product_id = ? AND warehouse_id = ? FOR UPDATE`, productID, warehouseID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return current, err
+}
+
+// setWarehouseStock overwrites the on-hand quantity at one warehouse,
+// recording the difference in the ledger. It returns the ledger entry's id,
+// or 0 if the quantity didn't change.
+func setWarehouseStock(ctx context.Context, tx *sql.Tx, productID string, warehouseID, quantity int, m stockMovement) (int64, error) {
+	current, err := lockWarehouseStock(ctx, tx, productID, warehouseID)
+	if err != nil {
+		return 0, err
+	}
+	return applyStockMovement(ctx, tx, productID, warehouseID, current, quantity-current, m)
+}
+
+// adjustWarehouseStock adds delta to the on-hand quantity at one warehouse,
+// refusing to go below zero, and records it in the ledger, returning the
+// entry's id
+func adjustWarehouseStock(ctx context.Context, tx *sql.Tx, productID string, warehouseID, delta int, m stockMovement) (int64, error) {
+	current, err := lockWarehouseStock(ctx, tx, productID, warehouseID)
+	if err != nil {
+		return 0, err
+	}
+	if current+delta < 0 {
+		return 0, errInsufficientStock
+	}
+	return applyStockMovement(ctx, tx, productID, warehouseID, current, delta, m)
+}
+
+// syncInventoryTotal keeps inventory.quantity equal to the product's on-hand
+// stock summed across warehouses, creating the inventory row if needed. Every
+// stock change passes through here, so it also advances the row's version.
+func syncInventoryTotal(ctx context.Context, tx *sql.Tx, productID string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO inventory (product_id, quantity, last_restocked)
+		SELECT ?, COALESCE(SUM(quantity), 0), NOW() FROM warehouse_stock WHERE product_id = ?
+		ON DUPLICATE KEY UPDATE quantity = VALUES(quantity), version = version + 1, updated_at = NOW()`, productID, productID)
+	return err
+}
+
+// PickWarehouse chooses the warehouse to fulfil an order from. Warehouses that
+// can ship every item are preferred, then those closest to the shipping
+// address (matching city, then state, then country), then the one holding
+// the most stock. It returns nil when no warehouse holds any of the items.
+func PickWarehouse(ctx context.Context, items []models.OrderItem, shippingAddress string) (*int, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	needed := map[string]int{}
+	placeholders := make([]string, 0, len(items))
+	args := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if _, ok := needed[item.ProductID]; !ok {
+			placeholders = append(placeholders, "?")
+			args = append(args, item.ProductID)
+		}
+		needed[item.ProductID] += item.Quantity
+	}
+
+	rows, err := config.MySQLDB.QueryContext(ctx, `SELECT w.id, COALESCE(w.city, ''), COALESCE(w.state, ''), COALESCE(w.country, ''), ws.product_id, ws.quantity
+		FROM warehouses w JOIN warehouse_stock ws ON ws.warehouse_id = w.id
+		WHERE w.active AND ws.product_id IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		id                   int
+		city, state, country string
+		stock                map[string]int
+	}
+	candidates := map[int]*candidate{}
+	var order []int
+	for rows.Next() {
+		var c candidate
+		var productID string
+		var quantity int
+		if err := rows.Scan(&c.id, &c.city, &c.state, &c.country, &productID, &quantity); err != nil {
+			return nil, err
+		}
+		existing, ok := candidates[c.id]
+		if !ok {
+			c.stock = map[string]int{}
+			existing = &c
+			candidates[c.id] = existing
+			order = append(order, c.id)
+		}
+		existing.stock[productID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	address := strings.ToLower(shippingAddress)
+	matches := func(field string) bool {
+		return field != "" && strings.Contains(address, strings.ToLower(field))
+	}
+
+	var best *candidate
+	var bestScore [3]int
+	for _, id := range order {
+		c := candidates[id]
+		canFulfil, total := 1, 0
+		for productID, qty := range needed {
+			if c.stock[productID] < qty {
+				canFulfil = 0
+			}
+			total += c.stock[productID]
+		}
+		proximity := 0
+		switch {
+		case matches(c.city):
+			proximity = 3
+		case matches(c.state):
+			proximity = 2
+		case matches(c.country):
+			proximity = 1
+		}
+		score := [3]int{canFulfil, proximity, total}
+		if best == nil || score[0] > bestScore[0] ||
+			(score[0] == bestScore[0] && (score[1] > bestScore[1] || (score[1] == bestScore[1] && score[2] > bestScore[2]))) {
+			best, bestScore = c, score
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return &best.id, nil
+}
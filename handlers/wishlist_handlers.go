@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sample-application/config"
+	"sample-application/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stock statuses shown on wishlist items, from MySQL inventory
+const (
+	StockInStock   = "in_stock"
+	StockLow       = "low_stock"
+	StockOut       = "out_of_stock"
+	StockUntracked = "unknown"
+)
+
+// Wishlist Handlers (MongoDB)
+
+// GetWishlist returns the user's wishlist, newest first, with each
+// product's current details, price drop and stock status
+func GetWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+	userIDInt, _ := strconv.Atoi(userID)
+
+	db := config.GetMongoDatabase()
+	opts := options.Find().SetSort(bson.D{{Key: "added_at", Value: -1}})
+	cursor, err := db.Collection("wishlist").Find(r.Context(), bson.M{"user_id": userIDInt}, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	wishlist := []models.Wishlist{}
+	if err := cursor.All(r.Context(), &wishlist); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	productIDs := bson.A{}
+	for _, item := range wishlist {
+		if oid, err := primitive.ObjectIDFromHex(item.ProductID); err == nil {
+			productIDs = append(productIDs, oid)
+		}
+	}
+	products := map[string]*models.Product{}
+	if len(productIDs) > 0 {
+		productCursor, err := db.Collection("products").Find(r.Context(), bson.M{"_id": bson.M{"$in": productIDs}})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var found []models.Product
+		if err := productCursor.All(r.Context(), &found); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range found {
+			products[found[i].ID] = &found[i]
+		}
+	}
+
+	stock, err := stockStatuses(r.Context(), wishlist)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]models.WishlistItem, 0, len(wishlist))
+	for _, entry := range wishlist {
+		item := models.WishlistItem{Wishlist: entry, Product: products[entry.ProductID], StockStatus: StockUntracked}
+		if status, ok := stock[entry.ProductID]; ok {
+			item.StockStatus = status
+		}
+		if item.Product != nil && item.Product.Price < entry.PriceAtAdd {
+			item.PriceDrop = entry.PriceAtAdd - item.Product.Price
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// stockStatuses looks up the stock status of each wishlisted product.
+// Products without an inventory row are left out.
+func stockStatuses(ctx context.Context, wishlist []models.Wishlist) (map[string]string, error) {
+	statuses := map[string]string{}
+	if len(wishlist) == 0 {
+		return statuses, nil
+	}
+
+	placeholders := make([]string, len(wishlist))
+	args := make([]interface{}, len(wishlist))
+	for i, item := range wishlist {
+		placeholders[i] = "?"
+		args[i] = item.ProductID
+	}
+	rows, err := config.MySQLDB.QueryContext(ctx, `SELECT product_id, quantity, COALESCE(low_stock_threshold, 0)
+		FROM inventory WHERE product_id IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID string
+		var quantity, threshold int
+		if err := rows.Scan(&productID, &quantity, &threshold); err != nil {
+			return nil, err
+		}
+		switch {
+		case quantity <= 0:
+			statuses[productID] = StockOut
+		case quantity <= threshold:
+			statuses[productID] = StockLow
+		default:
+			statuses[productID] = StockInStock
+		}
+	}
+	return statuses, rows.Err()
+}
+
+// AddToWishlist adds an existing product to the user's wishlist, recording
+// its current price. Adding a product that is already there returns the
+// existing item with 200 instead of 201.
+func AddToWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+	userIDInt, _ := strconv.Atoi(userID)
+
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := config.GetMongoDatabase()
+	objectID, err := primitive.ObjectIDFromHex(data["product_id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	var product models.Product
+	if err := db.Collection("products").FindOne(r.Context(), bson.M{"_id": objectID}).Decode(&product); err == mongo.ErrNoDocuments {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	wishlistItem := models.Wishlist{
+		UserID:     userIDInt,
+		ProductID:  data["product_id"],
+		PriceAtAdd: product.Price,
+		AddedAt:    time.Now(),
+	}
+
+	// Relies on the wishlist_user_product unique index (Mongo migration 6)
+	collection := db.Collection("wishlist")
+	filter := bson.M{"user_id": wishlistItem.UserID, "product_id": wishlistItem.ProductID}
+	result, err := collection.UpdateOne(r.Context(), filter, bson.M{"$setOnInsert": wishlistItem}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if err == nil && result.UpsertedID != nil {
+		status = http.StatusCreated
+		wishlistItem.ID = result.UpsertedID.(primitive.ObjectID).Hex()
+	} else if err := collection.FindOne(r.Context(), filter).Decode(&wishlistItem); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(wishlistItem)
+}
+
+func RemoveFromWishlist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+	productID := vars["product_id"]
+	userIDInt, _ := strconv.Atoi(userID)
+
+	collection := config.GetMongoDatabase().Collection("wishlist")
+	filter := bson.M{"user_id": userIDInt, "product_id": productID}
+	result, err := collection.DeleteOne(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		http.Error(w, "Wishlist item not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Item removed from wishlist"})
+}
+
+// GetPriceDrops lists the price-drop events for the user's wishlist,
+// newest first
+func GetPriceDrops(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+	userIDInt, _ := strconv.Atoi(userID)
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+	cursor, err := config.GetMongoDatabase().Collection("price_drop_events").Find(r.Context(), bson.M{"user_id": userIDInt}, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	events := []models.PriceDropEvent{}
+	if err := cursor.All(r.Context(), &events); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// notifyPriceDrops records a price-drop event for every wishlist item whose
+// product now costs less than when it was added. Each item is claimed by
+// setting last_notified_price before its event is written, so concurrent
+// updates announce a drop once; a later, deeper drop is announced again, as
+// is any drop after the price has recovered to the price at add.
+func notifyPriceDrops(ctx context.Context, productID, productName string, price float64) error {
+	if price <= 0 {
+		return nil
+	}
+	db := config.GetMongoDatabase()
+	wishlist := db.Collection("wishlist")
+
+	// Items are re-armed once the price is back at or above what it was
+	// when they were added, so the next drop is announced afresh. A partial
+	// recovery doesn't re-arm them, so it can't be followed by an event
+	// for a price that is higher than the last one announced.
+	_, err := wishlist.UpdateMany(ctx,
+		bson.M{"product_id": productID, "last_notified_price": bson.M{"$exists": true}, "price_at_add": bson.M{"$lte": price}},
+		bson.M{"$unset": bson.M{"last_notified_price": ""}})
+	if err != nil {
+		return err
+	}
+
+	dropped := bson.M{
+		"product_id":   productID,
+		"price_at_add": bson.M{"$gt": price},
+		"$or": bson.A{
+			bson.M{"last_notified_price": bson.M{"$exists": false}},
+			bson.M{"last_notified_price": bson.M{"$gt": price}},
+		},
+	}
+	cursor, err := wishlist.Find(ctx, dropped)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var item models.Wishlist
+		if err := cursor.Decode(&item); err != nil {
+			return err
+		}
+		oid, err := primitive.ObjectIDFromHex(item.ID)
+		if err != nil {
+			continue
+		}
+		claim := bson.M{"_id": oid}
+		for k, v := range dropped {
+			claim[k] = v
+		}
+		result, err := wishlist.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"last_notified_price": price}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		event := models.PriceDropEvent{
+			UserID:      item.UserID,
+			ProductID:   productID,
+			ProductName: productName,
+			PriceAtAdd:  item.PriceAtAdd,
+			NewPrice:    price,
+			CreatedAt:   time.Now(),
+		}
+		if _, err := db.Collection("price_drop_events").InsertOne(ctx, event); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "wishlist price drop",
+			"user_id", item.UserID,
+			"product_id", productID,
+			"price_at_add", item.PriceAtAdd,
+			"new_price", price,
+		)
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,163 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+
+	"sample-application/logging"
+)
+
+const (
+	// Header carries the client-chosen key, typically a UUID
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses served from the store
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	maxBodyBytes = 1 << 20
+)
+
+// identityHeaders identify the client a key belongs to. Their values only
+// ever reach the store hashed.
+var identityHeaders = []string{"Authorization", "X-User-ID", "X-Actor"}
+
+// replayedHeaders are the response headers stored alongside the body.
+// Per-request headers such as X-Request-ID are deliberately left out.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Middleware makes POST requests that carry an Idempotency-Key safe to
+// retry. Keys are scoped to the client, as identified by identityHeaders,
+// and to the method and path, so two clients or two endpoints choosing the
+// same key don't collide. The first response for a key is stored and
+// replayed for later requests with the same key, method, URL and body;
+// reusing the key for a different request to the same endpoint gets 422,
+// and retrying while the first attempt is still running gets 409. Server
+// errors aren't stored, so they can be retried.
+func Middleware(store Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				http.Error(w, Header+" must be at most "+strconv.Itoa(maxKeyLength)+" characters", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(body) > maxBodyBytes {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			logger := logging.FromContext(r.Context())
+			key = scopedKey(r, key)
+			stored, err := store.Begin(r.Context(), key, requestHash(r, body))
+			switch err {
+			case nil:
+			case ErrMismatch:
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			case ErrInFlight:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			default:
+				logger.Error("idempotency lookup failed", "error", err)
+				http.Error(w, "Idempotency check failed", http.StatusInternalServerError)
+				return
+			}
+
+			if stored != nil {
+				for name, values := range stored.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(ReplayedHeader, "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			// Record the outcome even if the client has gone away, since
+			// that is exactly when it will retry
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.status >= 500 {
+				if err := store.Release(ctx, key); err != nil {
+					logger.Error("releasing idempotency key failed", "error", err)
+				}
+				return
+			}
+
+			header := http.Header{}
+			for _, name := range replayedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					header[name] = values
+				}
+			}
+			if err := store.Complete(ctx, key, Response{StatusCode: rec.status, Header: header, Body: rec.body.Bytes()}); err != nil {
+				logger.Error("storing idempotent response failed", "error", err)
+			}
+		})
+	}
+}
+
+// scopedKey derives the stored key from the client's key, its identity and
+// the endpoint
+func scopedKey(r *http.Request, key string) string {
+	h := sha256.New()
+	for _, name := range identityHeaders {
+		io.WriteString(h, name+": "+r.Header.Get(name)+"\n")
+	}
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	io.WriteString(h, key)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// requestHash identifies a request by method, URL and body, so a key can't
+// be replayed against a different request
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes the response through while keeping a copy to store
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,188 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore is a Store kept in a map, with the same claim semantics as
+// PostgresStore
+type memoryStore struct {
+	mu   sync.Mutex
+	keys map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	hash string
+	resp *Response
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{keys: map[string]*memoryEntry{}}
+}
+
+func (s *memoryStore) Begin(ctx context.Context, key, requestHash string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.keys[key]
+	if !ok {
+		s.keys[key] = &memoryEntry{hash: requestHash}
+		return nil, nil
+	}
+	if entry.hash != requestHash {
+		return nil, ErrMismatch
+	}
+	if entry.resp == nil {
+		return nil, ErrInFlight
+	}
+	return entry.resp, nil
+}
+
+func (s *memoryStore) Complete(ctx context.Context, key string, resp Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key].resp = &resp
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.keys[key]; ok && entry.resp == nil {
+		delete(s.keys, key)
+	}
+	return nil
+}
+
+func post(handler http.Handler, path, key, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareReplay(t *testing.T) {
+	calls := 0
+	handler := Middleware(newMemoryStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/orders/1")
+		w.Header().Set("X-Request-ID", "req-1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}))
+
+	first := post(handler, "/api/orders", "key-1", `{"user_id":1}`)
+	second := post(handler, "/api/orders", "key-1", `{"user_id":1}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if first.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("first response marked as replayed")
+	}
+	if second.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("%s = %q, want true", ReplayedHeader, second.Header().Get(ReplayedHeader))
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != `{"id":1}` {
+		t.Errorf("replayed %d %q, want 201 %q", second.Code, second.Body.String(), `{"id":1}`)
+	}
+	if got := second.Header().Get("Location"); got != "/api/orders/1" {
+		t.Errorf("replayed Location %q", got)
+	}
+	if got := second.Header().Get("X-Request-ID"); got != "" {
+		t.Errorf("replayed X-Request-ID %q, want it left out", got)
+	}
+
+	// Other clients, endpoints and requests without a key run the handler
+	post(handler, "/api/orders", "key-1", `{"user_id":1}`, "X-User-ID", "2")
+	post(handler, "/api/reviews", "key-1", `{"user_id":1}`)
+	post(handler, "/api/orders", "", `{"user_id":1}`)
+	if calls != 4 {
+		t.Errorf("handler ran %d times, want 4", calls)
+	}
+}
+
+func TestMiddlewareMismatch(t *testing.T) {
+	handler := Middleware(newMemoryStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post(handler, "/api/orders", "key-1", `{"user_id":1}`)
+	for _, tc := range []struct{ name, path string }{
+		{"body", "/api/orders"},
+		{"query", "/api/orders?dry_run=true"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := post(handler, tc.path, "key-1", `{"user_id":2}`)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status %d, want 422", rec.Code)
+			}
+		})
+	}
+}
+
+func TestMiddlewareInFlight(t *testing.T) {
+	var retry *httptest.ResponseRecorder
+	var handler http.Handler
+	handler = Middleware(newMemoryStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Retry while the first attempt is still being processed
+		if retry == nil {
+			retry = post(handler, "/api/orders", "key-1", `{"user_id":1}`)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	first := post(handler, "/api/orders", "key-1", `{"user_id":1}`)
+	if first.Code != http.StatusCreated {
+		t.Errorf("first status %d, want 201", first.Code)
+	}
+	if retry.Code != http.StatusConflict {
+		t.Errorf("retry status %d, want 409", retry.Code)
+	}
+	if retry.Header().Get("Retry-After") == "" {
+		t.Errorf("retry has no Retry-After header")
+	}
+}
+
+func TestMiddlewareReleasesServerErrors(t *testing.T) {
+	calls := 0
+	handler := Middleware(newMemoryStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	if rec := post(handler, "/api/orders", "key-1", `{}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first status %d, want 503", rec.Code)
+	}
+	rec := post(handler, "/api/orders", "key-1", `{}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("retry got %d replayed=%q, want a fresh 201", rec.Code, rec.Header().Get(ReplayedHeader))
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestMiddlewareRejectsLongKeys(t *testing.T) {
+	handler := Middleware(newMemoryStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran for an invalid key")
+	}))
+	rec := post(handler, "/api/orders", strings.Repeat("k", maxKeyLength+1), `{}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
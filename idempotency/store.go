@@ -0,0 +1,147 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrMismatch means the key was first used with a different request
+	ErrMismatch = errors.New("idempotency key was used with a different request")
+	// ErrInFlight means the first request with the key hasn't finished yet
+	ErrInFlight = errors.New("a request with this idempotency key is still being processed")
+)
+
+// A claim on a key whose request never completed (e.g. the replica died)
+// is abandoned after this long and can be taken over by a retry
+const inFlightTimeout = 2 * time.Minute
+
+// Response is a stored response to replay
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Store keeps idempotency keys and the responses stored for them
+type Store interface {
+	// Begin claims key for a request with the given hash. It returns a nil
+	// Response when the caller now owns the key and should process the
+	// request, or the stored Response when the request already completed.
+	// It returns ErrMismatch or ErrInFlight when the key can't be used.
+	Begin(ctx context.Context, key, requestHash string) (*Response, error)
+	// Complete stores the response for a key claimed with Begin
+	Complete(ctx context.Context, key string, resp Response) error
+	// Release gives up a claim without storing a response, so a retry is
+	// processed afresh
+	Release(ctx context.Context, key string) error
+}
+
+// PostgresStore keeps idempotency keys and their responses in PostgreSQL, so
+// every replica sees the same keys
+type PostgresStore struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+func NewStore(db *sql.DB, ttl time.Duration) *PostgresStore {
+	return &PostgresStore{db: db, ttl: ttl}
+}
+
+// Begin implements Store
+func (s *PostgresStore) Begin(ctx context.Context, key, requestHash string) (*Response, error) {
+	now := time.Now().UTC()
+
+	// Expired keys and abandoned claims no longer count
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys
+		WHERE key = $1 AND (expires_at < $2 OR (status_code IS NULL AND created_at < $3))`,
+		key, now, now.Add(-inFlightTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ExecContext(ctx, `INSERT INTO idempotency_keys (key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (key) DO NOTHING`, key, requestHash, now, now.Add(s.ttl))
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 1 {
+		return nil, nil
+	}
+
+	var storedHash string
+	var status sql.NullInt64
+	var header, body []byte
+	err = s.db.QueryRowContext(ctx, `SELECT request_hash, status_code, response_headers, response_body FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&storedHash, &status, &header, &body)
+	if err == sql.ErrNoRows {
+		// Purged between the insert and the read; let the client retry
+		return nil, ErrInFlight
+	}
+	if err != nil {
+		return nil, err
+	}
+	if storedHash != requestHash {
+		return nil, ErrMismatch
+	}
+	if !status.Valid {
+		return nil, ErrInFlight
+	}
+
+	resp := &Response{StatusCode: int(status.Int64), Body: body}
+	if err := json.Unmarshal(header, &resp.Header); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Complete implements Store
+func (s *PostgresStore) Complete(ctx context.Context, key string, resp Response) error {
+	// JSONB takes text; lib/pq would send a []byte as bytea
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE idempotency_keys SET status_code = $2, response_headers = $3, response_body = $4 WHERE key = $1`,
+		key, resp.StatusCode, string(header), resp.Body)
+	return err
+}
+
+// Release implements Store
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL`, key)
+	return err
+}
+
+// PurgeExpired deletes keys whose TTL has passed
+func (s *PostgresStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RunPurger deletes expired keys every interval until ctx is done
+func (s *PostgresStore) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n, err := s.PurgeExpired(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("purging idempotency keys failed", "error", err)
+		} else if n > 0 {
+			slog.Debug("purged expired idempotency keys", "count", n)
+		}
+	}
+}
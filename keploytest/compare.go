@@ -0,0 +1,205 @@
+package keploytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Diff is one mismatch between the recorded and the actual response.
+// Field is "status", "header.<Name>" or a body path like "body.items[0].id".
+type Diff struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// missing stands for a header or JSON field that isn't there
+const missing = "<missing>"
+
+// Headers that follow from the body or the connection, so comparing them
+// would only repeat the body comparison or report transport details
+var ignoredHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// noise decides which differences a test case tolerates
+type noise map[string][]string
+
+// tolerates reports whether a difference in field, or in any field it's
+// nested in, is noise. Array indexes are ignored, so "body.items.price"
+// covers the price of every item.
+func (n noise) tolerates(field, actual string) bool {
+	path := stripIndexes(field)
+	for {
+		for key, patterns := range n {
+			if !strings.EqualFold(key, path) {
+				continue
+			}
+			if len(patterns) == 0 {
+				return true
+			}
+			for _, pattern := range patterns {
+				if re, err := regexp.Compile(pattern); err == nil && re.MatchString(actual) {
+					return true
+				}
+			}
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
+
+var indexPattern = regexp.MustCompile(`\[\d+\]`)
+
+func stripIndexes(field string) string {
+	return indexPattern.ReplaceAllString(field, "")
+}
+
+// compare lists the differences between the expected and actual response
+// that the noise doesn't cover. JSON fields that only the actual response
+// has are differences only when strict, so adding a field to a response
+// doesn't break the recordings made before it existed.
+func compare(expected Response, status int, header http.Header, body []byte, n noise, strict bool) []Diff {
+	var diffs []Diff
+	add := func(field, want, got string) {
+		if !n.tolerates(field, got) {
+			diffs = append(diffs, Diff{Field: field, Expected: want, Actual: got})
+		}
+	}
+
+	if status != expected.StatusCode {
+		add("status", fmt.Sprint(expected.StatusCode), fmt.Sprint(status))
+	}
+
+	names := make([]string, 0, len(expected.Header))
+	for name := range expected.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if ignoredHeaders[canonical] {
+			continue
+		}
+		want := expected.Header[name]
+		got := missing
+		if values, ok := header[canonical]; ok {
+			got = strings.Join(values, ", ")
+		}
+		if got != want {
+			add("header."+canonical, want, got)
+		}
+	}
+
+	wantBody, gotBody := []byte(expected.Body), body
+	var wantJSON, gotJSON interface{}
+	if decodeJSON(wantBody, &wantJSON) && decodeJSON(gotBody, &gotJSON) {
+		compareJSON("body", wantJSON, gotJSON, strict, add)
+	} else if w, g := bytes.TrimSpace(wantBody), bytes.TrimSpace(gotBody); !bytes.Equal(w, g) {
+		add("body", string(w), string(g))
+	}
+	return diffs
+}
+
+func decodeJSON(data []byte, v interface{}) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v) == nil && !decoder.More()
+}
+
+// compareJSON walks two decoded JSON documents and reports each leaf,
+// missing field or array length that differs, and when strict each field
+// that only got has
+func compareJSON(path string, want, got interface{}, strict bool, add func(field, want, got string)) {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			add(path, render(want), render(got))
+			return
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inGot:
+				add(path+"."+k, render(wv), missing)
+			case !inWant:
+				if strict {
+					add(path+"."+k, missing, render(gv))
+				}
+			default:
+				compareJSON(path+"."+k, wv, gv, strict, add)
+			}
+		}
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			add(path, render(want), render(got))
+			return
+		}
+		for i := 0; i < len(w) || i < len(g); i++ {
+			field := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(g):
+				add(field, render(w[i]), missing)
+			case i >= len(w):
+				add(field, missing, render(g[i]))
+			default:
+				compareJSON(field, w[i], g[i], strict, add)
+			}
+		}
+	case json.Number:
+		g, ok := got.(json.Number)
+		if !ok || !sameNumber(w, g) {
+			add(path, render(want), render(got))
+		}
+	default:
+		if render(want) != render(got) {
+			add(path, render(want), render(got))
+		}
+	}
+}
+
+// sameNumber treats 4 and 4.0 as equal
+func sameNumber(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	x, errX := a.Float64()
+	y, errY := b.Float64()
+	return errX == nil && errY == nil && x == y
+}
+
+// render formats a decoded JSON value for a diff. Strings come out bare so
+// that noise patterns match the value itself rather than its quotes.
+func render(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
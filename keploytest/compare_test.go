@@ -0,0 +1,161 @@
+package keploytest
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNoiseTolerates(t *testing.T) {
+	n := noise(canonicalNoise(map[string][]string{
+		"header.date":      {},
+		"body.created_at":  {},
+		"body.items.price": {},
+		"body.meta":        {},
+		"body.id":          {`^[0-9a-f]{24}$`},
+		"body":             {`request_id: \S+`},
+	}))
+
+	tests := []struct {
+		field, actual string
+		want          bool
+	}{
+		{field: "header.Date", actual: "Mon, 01 Jan 2024 00:00:00 GMT", want: true},
+		{field: "header.Etag", actual: `"3"`, want: false},
+		{field: "body.created_at", actual: "2024-01-01T00:00:00Z", want: true},
+		{field: "BODY.Created_At", actual: "x", want: true},
+		// Array indexes are stripped, so one entry covers every element
+		{field: "body.items[0].price", actual: "9.99", want: true},
+		{field: "body.items[12].price", actual: "9.99", want: true},
+		{field: "body.items[0].name", actual: "Laptop", want: false},
+		// Noise on an object covers everything nested in it
+		{field: "body.meta.page", actual: "2", want: true},
+		{field: "body.meta.links[1].href", actual: "/next", want: true},
+		{field: "body.metadata", actual: "x", want: false},
+		// Regular expressions accept only matching values
+		{field: "body.id", actual: "6579a1b2c3d4e5f6a7b8c9d0", want: true},
+		{field: "body.id", actual: "42", want: false},
+		{field: "body", actual: "Order not found\nrequest_id: abc-123", want: true},
+		{field: "body", actual: "Order not found", want: false},
+		{field: "status", actual: "500", want: false},
+	}
+	for _, tt := range tests {
+		if got := n.tolerates(tt.field, tt.actual); got != tt.want {
+			t.Errorf("tolerates(%q, %q) = %v, want %v", tt.field, tt.actual, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	jsonHeader := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name     string
+		expected Response
+		status   int
+		header   http.Header
+		body     string
+		noise    map[string][]string
+		strict   bool
+		want     []Diff
+	}{
+		{
+			name:     "identical",
+			expected: Response{StatusCode: 200, Header: jsonHeader, Body: `{"id": 1, "tags": ["a", "b"]}`},
+			status:   200,
+			header:   http.Header{"Content-Type": {"application/json"}},
+			body:     `{"tags":["a","b"],"id":1}`,
+		},
+		{
+			name:     "4 and 4.0 are the same number",
+			expected: Response{StatusCode: 200, Body: `{"rating": 4, "price": 10.50}`},
+			status:   200,
+			body:     `{"rating": 4.0, "price": 10.5}`,
+		},
+		{
+			name:     "a number is not its string",
+			expected: Response{StatusCode: 200, Body: `{"rating": 4}`},
+			status:   200,
+			body:     `{"rating": "4"}`,
+			want:     []Diff{{Field: "body.rating", Expected: "4", Actual: "4"}},
+		},
+		{
+			name:     "status and header",
+			expected: Response{StatusCode: 201, Header: map[string]string{"content-type": "application/json", "Content-Length": "12"}},
+			status:   200,
+			header:   http.Header{"Content-Length": {"99"}},
+			want: []Diff{
+				{Field: "status", Expected: "201", Actual: "200"},
+				{Field: "header.Content-Type", Expected: "application/json", Actual: missing},
+			},
+		},
+		{
+			name:     "extra fields are allowed",
+			expected: Response{StatusCode: 200, Body: `{"id": 1, "items": [{"qty": 1}]}`},
+			status:   200,
+			body:     `{"id": 1, "version": 2, "items": [{"qty": 1, "price": 5}]}`,
+		},
+		{
+			name:     "extra array elements are not",
+			expected: Response{StatusCode: 200, Body: `[{"id": 1}]`},
+			status:   200,
+			body:     `[{"id": 1}, {"id": 2}]`,
+			want:     []Diff{{Field: "body[1]", Expected: missing, Actual: `{"id":2}`}},
+		},
+		{
+			name:     "missing, extra and changed fields when strict",
+			expected: Response{StatusCode: 200, Body: `{"id": 1, "name": "Ada", "items": [{"qty": 1}, {"qty": 2}]}`},
+			status:   200,
+			body:     `{"id": 1, "email": "ada@example.com", "items": [{"qty": 3}]}`,
+			strict:   true,
+			want: []Diff{
+				{Field: "body.email", Expected: missing, Actual: "ada@example.com"},
+				{Field: "body.items[0].qty", Expected: "1", Actual: "3"},
+				{Field: "body.items[1]", Expected: `{"qty":2}`, Actual: missing},
+				{Field: "body.name", Expected: "Ada", Actual: missing},
+			},
+		},
+		{
+			name:     "noise",
+			expected: Response{StatusCode: 200, Header: map[string]string{"Date": "yesterday"}, Body: `{"id": "a", "items": [{"price": 1, "qty": 1}], "updated_at": "then"}`},
+			status:   200,
+			header:   http.Header{"Date": {"today"}},
+			body:     `{"id": "6579a1b2c3d4e5f6a7b8c9d0", "items": [{"price": 2, "qty": 2}], "updated_at": "now"}`,
+			noise: map[string][]string{
+				"header.date":      {},
+				"body.id":          {`^[0-9a-f]{24}$`},
+				"body.items.price": {},
+				"body.updated_at":  {},
+			},
+			want: []Diff{{Field: "body.items[0].qty", Expected: "1", Actual: "2"}},
+		},
+		{
+			name:     "plain text",
+			expected: Response{StatusCode: 404, Body: "Order not found\n"},
+			status:   404,
+			body:     "Order not found\nrequest_id: abc\n",
+			want:     []Diff{{Field: "body", Expected: "Order not found", Actual: "Order not found\nrequest_id: abc"}},
+		},
+		{
+			name:     "plain text with noise",
+			expected: Response{StatusCode: 404, Body: "Order not found\n"},
+			status:   404,
+			body:     "Order not found\nrequest_id: abc\n",
+			noise:    map[string][]string{"body": {`^Order not found\nrequest_id: \S+$`}},
+		},
+		{
+			name:     "JSON expected, text received",
+			expected: Response{StatusCode: 200, Body: `{"id": 1}`},
+			status:   200,
+			body:     "internal error",
+			want:     []Diff{{Field: "body", Expected: `{"id": 1}`, Actual: "internal error"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compare(tt.expected, tt.status, tt.header, []byte(tt.body), canonicalNoise(tt.noise), tt.strict)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compare() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
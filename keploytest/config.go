@@ -0,0 +1,109 @@
+package keploytest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is where Keploy keeps its configuration, relative to the
+// application's directory
+const ConfigFile = "keploy.yml"
+
+// Config holds the parts of keploy.yml's test section that the runner
+// honours, so a test set can be adjusted without editing its captures
+type Config struct {
+	// GlobalNoise applies to every test set, and SetNoise to the named one.
+	// Keys are like TestCase.Noise, e.g. "body.rating" or "header.Date".
+	GlobalNoise map[string][]string
+	SetNoise    map[string]map[string][]string
+	// IgnoredTests names the cases to skip in each test set
+	IgnoredTests map[string][]string
+}
+
+// configFile mirrors the parts of Keploy's configuration the runner uses.
+// Noise is grouped by section ("body" or "header") and then by field.
+type configFile struct {
+	Test struct {
+		GlobalNoise struct {
+			Global   map[string]map[string][]string            `yaml:"global"`
+			TestSets map[string]map[string]map[string][]string `yaml:"test-sets"`
+		} `yaml:"globalNoise"`
+		IgnoredTests map[string][]string `yaml:"ignoredTests"`
+	} `yaml:"test"`
+}
+
+// LoadConfig reads a Keploy configuration file. A missing file is an empty
+// configuration.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	var f configFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	cfg := Config{
+		GlobalNoise:  flattenNoise(f.Test.GlobalNoise.Global),
+		SetNoise:     map[string]map[string][]string{},
+		IgnoredTests: f.Test.IgnoredTests,
+	}
+	for set, sections := range f.Test.GlobalNoise.TestSets {
+		cfg.SetNoise[set] = flattenNoise(sections)
+	}
+	return cfg, nil
+}
+
+// flattenNoise turns {"body": {"rating": [...]}} into {"body.rating": [...]}
+func flattenNoise(sections map[string]map[string][]string) map[string][]string {
+	noise := map[string][]string{}
+	for section, fields := range sections {
+		for field, patterns := range fields {
+			noise[section+"."+field] = patterns
+		}
+	}
+	return noise
+}
+
+// Apply drops the cases the configuration ignores for the test set in dir
+// and adds its noise to the rest. A case's own noise for a field wins.
+func (c Config) Apply(dir string, cases []TestCase) []TestCase {
+	set := TestSetName(dir)
+	ignored := map[string]bool{}
+	for _, name := range c.IgnoredTests[set] {
+		ignored[name] = true
+	}
+
+	var kept []TestCase
+	for _, tc := range cases {
+		if ignored[tc.Name] {
+			continue
+		}
+		noise := map[string][]string{}
+		for _, extra := range []map[string][]string{c.GlobalNoise, c.SetNoise[set], tc.Noise} {
+			for field, patterns := range extra {
+				noise[field] = patterns
+			}
+		}
+		tc.Noise = noise
+		kept = append(kept, tc)
+	}
+	return kept
+}
+
+// TestSetName is the name Keploy knows a test set by, given either its
+// directory or its tests/ subdirectory
+func TestSetName(dir string) string {
+	dir = filepath.Clean(dir)
+	if filepath.Base(dir) == "tests" {
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Base(dir)
+}
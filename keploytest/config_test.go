@@ -0,0 +1,80 @@
+package keploytest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keploy.yml")
+	err := os.WriteFile(path, []byte(`
+test:
+    globalNoise:
+        global:
+            header:
+                Date: []
+        test-sets:
+            test-set-0:
+                body:
+                    rating: []
+                    id: ["^[0-9a-f]{24}$"]
+    ignoredTests:
+        test-set-0:
+            - test-2
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		GlobalNoise: map[string][]string{"header.Date": {}},
+		SetNoise: map[string]map[string][]string{
+			"test-set-0": {"body.rating": {}, "body.id": {"^[0-9a-f]{24}$"}},
+		},
+		IgnoredTests: map[string][]string{"test-set-0": {"test-2"}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() =\n%+v\nwant\n%+v", cfg, want)
+	}
+
+	if cfg, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml")); err != nil || cfg.IgnoredTests != nil {
+		t.Errorf("LoadConfig(missing) = %+v, %v, want an empty config", cfg, err)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	cfg := Config{
+		GlobalNoise: map[string][]string{"header.Date": {}, "body.id": {}},
+		SetNoise: map[string]map[string][]string{
+			"test-set-0": {"body.rating": {}},
+			"test-set-1": {"body.name": {}},
+		},
+		IgnoredTests: map[string][]string{"test-set-0": {"test-2"}},
+	}
+	cases := []TestCase{
+		{Name: "test-1", Noise: map[string][]string{"body.id": {"^[0-9]+$"}}},
+		{Name: "test-2"},
+		{Name: "test-3"},
+	}
+
+	for _, dir := range []string{"keploy/test-set-0", "keploy/test-set-0/tests/"} {
+		got := cfg.Apply(dir, cases)
+		want := []TestCase{
+			// The case's own noise for a field wins
+			{Name: "test-1", Noise: map[string][]string{"header.Date": {}, "body.id": {"^[0-9]+$"}, "body.rating": {}}},
+			{Name: "test-3", Noise: map[string][]string{"header.Date": {}, "body.id": {}, "body.rating": {}}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Apply(%q) =\n%+v\nwant\n%+v", dir, got, want)
+		}
+	}
+	if cases[0].Noise["body.rating"] != nil {
+		t.Errorf("Apply changed the cases it was given")
+	}
+}
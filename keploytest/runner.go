@@ -0,0 +1,143 @@
+package keploytest
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// Result is the outcome of replaying one test case
+type Result struct {
+	Case  TestCase
+	Diffs []Diff
+}
+
+func (r Result) Passed() bool { return len(r.Diffs) == 0 }
+
+// Options control how responses are compared
+type Options struct {
+	// Strict also fails JSON fields the recording doesn't have
+	Strict bool
+}
+
+// Run sends each case's request to handler in process, in order, and
+// compares the responses with the recorded ones. It works the same from a
+// test, given the application's router:
+//
+//	cases, err := keploytest.LoadTestSet("keploy/test-set-0")
+//	...
+//	for _, r := range keploytest.Run(router, cases, keploytest.Options{}) {
+//		if !r.Passed() { t.Error(r) }
+//	}
+func Run(handler http.Handler, cases []TestCase, opts Options) []Result {
+	results := make([]Result, len(cases))
+	for i, c := range cases {
+		results[i] = runCase(handler, c, opts)
+	}
+	return results
+}
+
+func runCase(handler http.Handler, c TestCase, opts Options) Result {
+	req := httptest.NewRequest(c.Request.Method, c.Request.URL, strings.NewReader(c.Request.Body))
+	for name, value := range c.Request.Header {
+		req.Header.Set(name, value)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return Result{
+		Case:  c,
+		Diffs: compare(c.Response, rec.Code, rec.Header(), rec.Body.Bytes(), canonicalNoise(c.Noise), opts.Strict),
+	}
+}
+
+func (r Result) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FAIL %s (%s %s)\n", r.Case.Name, r.Case.Request.Method, requestPath(r.Case.Request.URL))
+	for _, d := range r.Diffs {
+		fmt.Fprintf(&b, "  %s:\n    expected: %s\n    actual:   %s\n", d.Field, truncate(d.Expected), truncate(d.Actual))
+	}
+	return b.String()
+}
+
+// WriteReport prints a diff for every failing case followed by a summary,
+// and returns the number of failures
+func WriteReport(w io.Writer, results []Result) int {
+	failed := 0
+	for _, r := range results {
+		if !r.Passed() {
+			failed++
+			fmt.Fprint(w, r)
+		}
+	}
+	fmt.Fprintf(w, "%d test cases: %d passed, %d failed\n", len(results), len(results)-failed, failed)
+	return failed
+}
+
+func requestPath(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.RequestURI()
+	}
+	return rawURL
+}
+
+// truncate keeps long bodies from drowning the report
+func truncate(s string) string {
+	const max = 200
+	if len(s) > max {
+		return s[:max] + fmt.Sprintf("… (%d bytes)", len(s))
+	}
+	return s
+}
+
+// RunCommand implements `keploy test [-strict] [test-set-dir]`, failing if
+// any case doesn't match its recording. The noise and ignored tests in
+// keploy.yml apply as they do for the Keploy CLI.
+func RunCommand(args []string, handler http.Handler, out io.Writer) error {
+	const usage = "usage: keploy test [-strict] [test-set-dir]"
+	if len(args) == 0 || args[0] != "test" {
+		return errors.New(usage)
+	}
+	fs := flag.NewFlagSet("keploy test", flag.ContinueOnError)
+	fs.SetOutput(out)
+	strict := fs.Bool("strict", false, "also fail on JSON fields the recordings don't have")
+	fs.Usage = func() {
+		fmt.Fprintln(out, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New(usage)
+	}
+	dir := "keploy/test-set-0"
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	cfg, err := LoadConfig(ConfigFile)
+	if err != nil {
+		return err
+	}
+	cases, err := LoadTestSet(dir)
+	if err != nil {
+		return err
+	}
+	cases = cfg.Apply(dir, cases)
+	if failed := WriteReport(out, Run(handler, cases, Options{Strict: *strict})); failed > 0 {
+		return fmt.Errorf("%d of %d test cases failed", failed, len(cases))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package keploytest
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestCase is one recorded Keploy Http test: a request and the response
+// the application gave when it was captured
+type TestCase struct {
+	Name     string
+	File     string
+	Request  Request
+	Response Response
+	// Noise lists the fields to ignore, keyed like "header.Date" or
+	// "body.created_at". An empty list ignores the field entirely; otherwise
+	// a differing value passes if it matches one of the regular expressions.
+	Noise map[string][]string
+}
+
+type Request struct {
+	Method    string
+	URL       string
+	Header    map[string]string
+	Body      string
+	Timestamp time.Time
+}
+
+type Response struct {
+	StatusCode int
+	Header     map[string]string
+	Body       string
+}
+
+// testCaseFile mirrors the parts of Keploy's YAML format the runner uses
+type testCaseFile struct {
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+	Spec struct {
+		Req struct {
+			Method    string            `yaml:"method"`
+			URL       string            `yaml:"url"`
+			Header    map[string]string `yaml:"header"`
+			Body      string            `yaml:"body"`
+			Timestamp time.Time         `yaml:"timestamp"`
+		} `yaml:"req"`
+		Resp struct {
+			StatusCode int               `yaml:"status_code"`
+			Header     map[string]string `yaml:"header"`
+			Body       string            `yaml:"body"`
+		} `yaml:"resp"`
+		Assertions struct {
+			Noise map[string][]string `yaml:"noise"`
+		} `yaml:"assertions"`
+	} `yaml:"spec"`
+}
+
+// LoadTestSet reads the Http test cases of a Keploy test set, given either
+// the set's directory or its tests/ subdirectory. Cases are returned in the
+// order they were recorded, since later ones may depend on data created by
+// earlier ones.
+func LoadTestSet(dir string) ([]TestCase, error) {
+	if info, err := os.Stat(filepath.Join(dir, "tests")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, "tests")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no test cases in %s", dir)
+	}
+
+	var cases []TestCase
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var f testCaseFile
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		if f.Kind != "Http" {
+			continue
+		}
+		req, resp := f.Spec.Req, f.Spec.Resp
+		cases = append(cases, TestCase{
+			Name: f.Name,
+			File: file,
+			Request: Request{
+				Method:    req.Method,
+				URL:       req.URL,
+				Header:    req.Header,
+				Body:      req.Body,
+				Timestamp: req.Timestamp,
+			},
+			Response: Response{
+				StatusCode: resp.StatusCode,
+				Header:     resp.Header,
+				Body:       resp.Body,
+			},
+			Noise: f.Spec.Assertions.Noise,
+		})
+	}
+
+	sort.SliceStable(cases, func(i, j int) bool {
+		return cases[i].Request.Timestamp.Before(cases[j].Request.Timestamp)
+	})
+	return cases, nil
+}
+
+// canonicalNoise normalises header noise keys so "header.date" and
+// "header.Date" are the same field
+func canonicalNoise(noise map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(noise))
+	for key, patterns := range noise {
+		if strings.HasPrefix(strings.ToLower(key), "header.") {
+			key = "header." + http.CanonicalHeaderKey(key[len("header."):])
+		}
+		out[key] = patterns
+	}
+	return out
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes one load-test run. Values come from the defaults, then
+// an optional YAML file (-config), then any flags given explicitly.
+type Config struct {
+	BaseURL string `yaml:"base_url"`
+	// Requests is the number of measured requests to send, or journeys
+	// with a Scenario; it is ignored when Duration is set, and for a Replay,
+	// which plays the recording once unless Duration is set
+	Requests int           `yaml:"requests"`
+	Duration time.Duration `yaml:"duration"`
+	// Concurrency caps the requests in flight. Without a Rate it is also
+	// the number of workers sending back-to-back (closed model).
+	Concurrency int `yaml:"concurrency"`
+	// Rate, in requests per second, switches to the open model: requests
+	// start on a fixed schedule whether or not earlier ones have finished
+	Rate float64 `yaml:"rate"`
+	// RampUp grows the rate (or the number of workers) linearly from zero
+	RampUp time.Duration `yaml:"ramp_up"`
+	// WarmUp requests are sent but left out of the results
+	WarmUp  time.Duration `yaml:"warm_up"`
+	Timeout time.Duration `yaml:"timeout"`
+	// JSONReport is a file to write the JSON report to; "-" writes it to
+	// stdout instead of the text report
+	JSONReport string `yaml:"json_report"`
+	// Scenario is a YAML file of weighted user journeys to run instead of
+	// the random mix of single requests
+	Scenario string `yaml:"scenario"`
+	// Replay is a Keploy test-set directory or requests.jsonl file whose
+	// requests are sent instead of generated ones, at ReplaySpeed: "max",
+	// or a factor applied to the recorded timing (1 is the original)
+	Replay      string `yaml:"replay"`
+	ReplaySpeed string `yaml:"replay_speed"`
+	// Thresholds are rules such as "p95 < 200ms" or "GetProducts:
+	// error_rate < 1%" that the run must meet; see parseThreshold
+	Thresholds []string `yaml:"thresholds"`
+	// Baseline is a JSON report from an earlier run to compare against.
+	// The run fails if latency or error rate grows, or throughput falls,
+	// by more than MaxRegression (a fraction).
+	Baseline      string  `yaml:"baseline"`
+	MaxRegression float64 `yaml:"max_regression"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		BaseURL:       "http://localhost:8080",
+		Requests:      1000,
+		Concurrency:   50,
+		Timeout:       10 * time.Second,
+		ReplaySpeed:   "1",
+		MaxRegression: 0.1,
+	}
+}
+
+// loadConfig parses args into a Config
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML file with load test settings")
+	flags := defaultConfig()
+	fs.StringVar(&flags.BaseURL, "url", flags.BaseURL, "base URL of the API under test")
+	fs.IntVar(&flags.Requests, "requests", flags.Requests, "number of measured requests, or journeys with -scenario (ignored with -duration)")
+	fs.DurationVar(&flags.Duration, "duration", flags.Duration, "run for this long instead of a fixed number of requests")
+	fs.IntVar(&flags.Concurrency, "concurrency", flags.Concurrency, "maximum requests in flight")
+	fs.Float64Var(&flags.Rate, "rps", flags.Rate, "target requests per second (open model); 0 sends as fast as concurrency allows")
+	fs.DurationVar(&flags.RampUp, "ramp-up", flags.RampUp, "ramp the rate or workers up linearly over this period")
+	fs.DurationVar(&flags.WarmUp, "warm-up", flags.WarmUp, "send requests for this long before measuring")
+	fs.DurationVar(&flags.Timeout, "timeout", flags.Timeout, "per-request timeout")
+	fs.StringVar(&flags.JSONReport, "json", flags.JSONReport, `write the report as JSON to this file ("-" for stdout)`)
+	fs.StringVar(&flags.Scenario, "scenario", flags.Scenario, "YAML file of weighted user journeys to run instead of random requests")
+	fs.StringVar(&flags.Replay, "replay", flags.Replay, "Keploy test-set directory or requests.jsonl file to replay instead of random requests")
+	fs.StringVar(&flags.ReplaySpeed, "replay-speed", flags.ReplaySpeed, `replay timing: 1 for the original, 10 for ten times faster, or "max"`)
+	fs.Var((*stringList)(&flags.Thresholds), "threshold", `rule the run must meet, e.g. "p95 < 200ms" or "GetProducts: error_rate < 1%" (repeatable)`)
+	fs.StringVar(&flags.Baseline, "baseline", flags.Baseline, "JSON report of an earlier run to check for regressions against")
+	fs.Float64Var(&flags.MaxRegression, "max-regression", flags.MaxRegression, "largest allowed regression against the baseline, as a fraction")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile != "" {
+		f, err := os.Open(*configFile)
+		if err != nil {
+			return cfg, err
+		}
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		err = decoder.Decode(&cfg)
+		f.Close()
+		if err != nil {
+			return cfg, fmt.Errorf("parsing %s: %w", *configFile, err)
+		}
+	}
+
+	// Flags given on the command line override the file
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "url":
+			cfg.BaseURL = flags.BaseURL
+		case "requests":
+			cfg.Requests = flags.Requests
+		case "duration":
+			cfg.Duration = flags.Duration
+		case "concurrency":
+			cfg.Concurrency = flags.Concurrency
+		case "rps":
+			cfg.Rate = flags.Rate
+		case "ramp-up":
+			cfg.RampUp = flags.RampUp
+		case "warm-up":
+			cfg.WarmUp = flags.WarmUp
+		case "timeout":
+			cfg.Timeout = flags.Timeout
+		case "json":
+			cfg.JSONReport = flags.JSONReport
+		case "scenario":
+			cfg.Scenario = flags.Scenario
+		case "replay":
+			cfg.Replay = flags.Replay
+		case "replay-speed":
+			cfg.ReplaySpeed = flags.ReplaySpeed
+		case "threshold":
+			cfg.Thresholds = flags.Thresholds
+		case "baseline":
+			cfg.Baseline = flags.Baseline
+		case "max-regression":
+			cfg.MaxRegression = flags.MaxRegression
+		}
+	})
+
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	var errs []error
+	if c.BaseURL == "" {
+		errs = append(errs, errors.New("base URL is required"))
+	}
+	if c.Duration <= 0 && c.Requests < 1 {
+		errs = append(errs, errors.New("requests must be at least 1 unless a duration is set"))
+	}
+	if c.Duration < 0 || c.RampUp < 0 || c.WarmUp < 0 {
+		errs = append(errs, errors.New("duration, ramp-up and warm-up must not be negative"))
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, errors.New("concurrency must be at least 1"))
+	}
+	if c.Rate < 0 {
+		errs = append(errs, errors.New("rps must not be negative"))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New("timeout must be positive"))
+	}
+	if c.Replay != "" {
+		if c.Scenario != "" {
+			errs = append(errs, errors.New("replay and scenario can't be combined"))
+		}
+		if c.Rate > 0 {
+			errs = append(errs, errors.New("replay sets its own rate; use replay speed instead of rps"))
+		}
+		if _, _, err := parseReplaySpeed(c.ReplaySpeed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseThresholds(c.Thresholds); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxRegression < 0 || c.MaxRegression >= 1 {
+		errs = append(errs, errors.New("max regression must be at least 0 and below 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
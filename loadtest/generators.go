@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+)
+
+// Sample data structures
+type User struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Address  string `json:"address"`
+	Phone    string `json:"phone"`
+}
+
+type Product struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Price       float64  `json:"price"`
+	Category    string   `json:"category"`
+	Brand       string   `json:"brand"`
+	ImageURL    string   `json:"image_url"`
+	Rating      float64  `json:"rating"`
+	Tags        []string `json:"tags"`
+}
+
+type Order struct {
+	UserID          int     `json:"user_id"`
+	TotalAmount     float64 `json:"total_amount"`
+	Status          string  `json:"status"`
+	PaymentMethod   string  `json:"payment_method"`
+	ShippingAddress string  `json:"shipping_address"`
+}
+
+type Review struct {
+	ProductID string `json:"product_id"`
+	UserID    int    `json:"user_id"`
+	Rating    int    `json:"rating"`
+	Comment   string `json:"comment"`
+}
+
+// generator produces one request for the random mix
+type generator struct {
+	name string
+	gen  func() (method, path string, body []byte)
+}
+
+var generators = []generator{
+	{"HealthCheck", generateHealthCheck},
+	{"GetUsers", generateGetUsers},
+	{"GetProducts", generateGetProducts},
+	{"GetOrders", generateGetOrders},
+	{"GetInventory", generateGetInventory},
+	{"GetCategories", generateGetCategories},
+	{"SearchProducts", generateSearchProducts},
+	{"GetAnalytics", generateGetAnalytics},
+	{"GetReviews", generateGetReviews},
+	{"CreateUser", generateCreateUser},
+	{"CreateProduct", generateCreateProduct},
+	{"CreateOrder", generateCreateOrder},
+	{"CreateReview", generateCreateReview},
+}
+
+// randomMix sends one request from a randomly chosen generator
+func randomMix(e *Executor) task {
+	return func(ctx context.Context, it *iteration) {
+		g := generators[rand.Intn(len(generators))]
+		method, path, body := g.gen()
+		e.Do(ctx, it, g.name, method, path, body)
+	}
+}
+
+// Request generators
+func generateHealthCheck() (string, string, []byte) {
+	return "GET", "/health", nil
+}
+
+func generateGetUsers() (string, string, []byte) {
+	return "GET", "/api/users", nil
+}
+
+func generateGetProducts() (string, string, []byte) {
+	return "GET", "/api/products", nil
+}
+
+func generateGetOrders() (string, string, []byte) {
+	return "GET", "/api/orders", nil
+}
+
+func generateGetInventory() (string, string, []byte) {
+	return "GET", "/api/inventory", nil
+}
+
+func generateGetCategories() (string, string, []byte) {
+	return "GET", "/api/categories", nil
+}
+
+func generateSearchProducts() (string, string, []byte) {
+	queries := []string{"laptop", "phone", "book", "shoes", "watch", "camera"}
+	query := queries[rand.Intn(len(queries))]
+	return "GET", "/api/products/search?q=" + query, nil
+}
+
+func generateGetAnalytics() (string, string, []byte) {
+	endpoints := []string{
+		"/api/analytics/sales?start_date=2024-01-01&end_date=2024-12-31",
+		"/api/analytics/popular-products",
+		"/api/analytics/revenue",
+	}
+	return "GET", endpoints[rand.Intn(len(endpoints))], nil
+}
+
+func generateGetReviews() (string, string, []byte) {
+	productID := fmt.Sprintf("prod%d", rand.Intn(100)+1)
+	return "GET", "/api/reviews/product/" + productID, nil
+}
+
+func generateCreateUser() (string, string, []byte) {
+	user := User{
+		Name:     fmt.Sprintf("User_%d", rand.Intn(10000)),
+		Email:    fmt.Sprintf("user%d@example.com", rand.Intn(10000)),
+		Password: "password123",
+		Address:  fmt.Sprintf("%d Main St, City, State", rand.Intn(1000)),
+		Phone:    fmt.Sprintf("+1-555-%04d", rand.Intn(10000)),
+	}
+	body, _ := json.Marshal(user)
+	return "POST", "/api/users", body
+}
+
+func generateCreateProduct() (string, string, []byte) {
+	categories := []string{"Electronics", "Clothing", "Books", "Home", "Sports"}
+	brands := []string{"BrandA", "BrandB", "BrandC", "BrandD", "BrandE"}
+	tags := [][]string{
+		{"new", "sale", "popular"},
+		{"featured", "bestseller"},
+		{"limited", "exclusive"},
+	}
+
+	product := Product{
+		Name:        fmt.Sprintf("Product_%d", rand.Intn(10000)),
+		Description: fmt.Sprintf("Description for product %d", rand.Intn(10000)),
+		Price:       float64(rand.Intn(1000)) + 0.99,
+		Category:    categories[rand.Intn(len(categories))],
+		Brand:       brands[rand.Intn(len(brands))],
+		ImageURL:    fmt.Sprintf("https://example.com/image%d.jpg", rand.Intn(100)),
+		Rating:      float64(rand.Intn(5)) + 1.0,
+		Tags:        tags[rand.Intn(len(tags))],
+	}
+	body, _ := json.Marshal(product)
+	return "POST", "/api/products", body
+}
+
+func generateCreateOrder() (string, string, []byte) {
+	statuses := []string{"pending", "processing", "shipped", "delivered"}
+	paymentMethods := []string{"credit_card", "debit_card", "paypal", "cash"}
+
+	order := Order{
+		UserID:          rand.Intn(100) + 1,
+		TotalAmount:     float64(rand.Intn(500)) + 0.99,
+		Status:          statuses[rand.Intn(len(statuses))],
+		PaymentMethod:   paymentMethods[rand.Intn(len(paymentMethods))],
+		ShippingAddress: fmt.Sprintf("%d Shipping St, City, State", rand.Intn(1000)),
+	}
+	body, _ := json.Marshal(order)
+	return "POST", "/api/orders", body
+}
+
+func generateCreateReview() (string, string, []byte) {
+	comments := []string{
+		"Great product!",
+		"Excellent quality",
+		"Very satisfied",
+		"Could be better",
+		"Amazing purchase",
+	}
+
+	review := Review{
+		ProductID: fmt.Sprintf("prod%d", rand.Intn(100)+1),
+		UserID:    rand.Intn(100) + 1,
+		Rating:    rand.Intn(5) + 1,
+		Comment:   comments[rand.Intn(len(comments))],
+	}
+	body, _ := json.Marshal(review)
+	return "POST", "/api/reviews", body
+}
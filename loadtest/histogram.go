@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram records latencies in log-linear buckets, in the manner of an
+// HDR histogram: values below 256µs are exact, and above that every power of
+// two is split into 128 buckets, so percentiles are within 1% of the true
+// value while memory stays constant however many samples are recorded.
+type Histogram struct {
+	counts []int64
+	count  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+const (
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+)
+
+func bucketIndex(micros uint64) int {
+	if micros < 2*subBucketCount {
+		return int(micros)
+	}
+	shift := bits.Len64(micros) - (subBucketBits + 1)
+	return (shift+1)*subBucketCount + int(micros>>shift) - subBucketCount
+}
+
+// bucketUpperBound is the largest value, in microseconds, that falls into
+// bucket i
+func bucketUpperBound(i int) uint64 {
+	if i < 2*subBucketCount {
+		return uint64(i)
+	}
+	shift := i/subBucketCount - 1
+	lower := uint64(i-shift*subBucketCount) << shift
+	return lower + (uint64(1) << shift) - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bucketIndex(uint64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		grown := make([]int64, i+1)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[i]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Merge adds other's samples to h
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		grown := make([]int64, len(other.counts))
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+func (h *Histogram) Count() int64 { return h.count }
+
+func (h *Histogram) Min() time.Duration { return h.min }
+
+func (h *Histogram) Max() time.Duration { return h.max }
+
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Percentile returns the latency at or below which p percent of samples
+// fall, reported as the upper bound of its bucket and capped at Max
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(p / 100 * float64(h.count)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			d := time.Duration(bucketUpperBound(i)) * time.Microsecond
+			if d > h.max {
+				d = h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	tests := []struct {
+		micros uint64
+		index  int
+		upper  uint64
+	}{
+		{micros: 0, index: 0, upper: 0},
+		{micros: 1, index: 1, upper: 1},
+		{micros: 255, index: 255, upper: 255},
+		{micros: 256, index: 256, upper: 257},
+		{micros: 257, index: 256, upper: 257},
+		{micros: 258, index: 257, upper: 259},
+		{micros: 511, index: 383, upper: 511},
+		{micros: 512, index: 384, upper: 515},
+		{micros: 1023, index: 511, upper: 1023},
+		{micros: 1024, index: 512, upper: 1031},
+		{micros: 1_000_000, index: 1780, upper: 1_003_519},
+	}
+	for _, tt := range tests {
+		if got := bucketIndex(tt.micros); got != tt.index {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tt.micros, got, tt.index)
+		}
+		if got := bucketUpperBound(tt.index); got != tt.upper {
+			t.Errorf("bucketUpperBound(%d) = %d, want %d", tt.index, got, tt.upper)
+		}
+	}
+}
+
+// TestBucketBounds checks that buckets are contiguous and that a value's
+// bucket overstates it by less than 1%
+func TestBucketBounds(t *testing.T) {
+	for i := 0; i < bucketIndex(uint64(time.Hour/time.Microsecond)); i++ {
+		upper := bucketUpperBound(i)
+		if got := bucketIndex(upper); got != i {
+			t.Fatalf("bucketIndex(bucketUpperBound(%d) = %d) = %d", i, upper, got)
+		}
+		if got := bucketIndex(upper + 1); got != i+1 {
+			t.Fatalf("bucketIndex(%d) = %d, want the next bucket %d", upper+1, got, i+1)
+		}
+		if i > 0 {
+			lower := bucketUpperBound(i-1) + 1
+			if err := float64(upper-lower) / float64(lower); err >= 0.01 {
+				t.Fatalf("bucket %d covers %d-%d, %.2f%% wide", i, lower, upper, err*100)
+			}
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	var h Histogram
+	if got := h.Percentile(50); got != 0 {
+		t.Errorf("empty Percentile(50) = %v, want 0", got)
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{p: 0, want: time.Millisecond},
+		{p: 50, want: 500 * time.Millisecond},
+		{p: 95, want: 950 * time.Millisecond},
+		{p: 99.9, want: 999 * time.Millisecond},
+		{p: 100, want: time.Second},
+	}
+	for _, tt := range tests {
+		got := h.Percentile(tt.p)
+		if got < tt.want || float64(got-tt.want) >= 0.01*float64(tt.want) {
+			t.Errorf("Percentile(%v) = %v, want within 1%% above %v", tt.p, got, tt.want)
+		}
+	}
+	if got := h.Percentile(100); got != h.Max() {
+		t.Errorf("Percentile(100) = %v, want Max %v", got, h.Max())
+	}
+	if h.Count() != 1000 || h.Min() != time.Millisecond || h.Max() != time.Second || h.Mean() != 500500*time.Microsecond {
+		t.Errorf("count %d, min %v, max %v, mean %v", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b, empty Histogram
+	a.Record(2 * time.Millisecond)
+	b.Record(time.Millisecond)
+	b.Record(time.Second)
+
+	a.Merge(&empty)
+	a.Merge(&b)
+	if a.Count() != 3 || a.Min() != time.Millisecond || a.Max() != time.Second {
+		t.Fatalf("merged count %d, min %v, max %v", a.Count(), a.Min(), a.Max())
+	}
+	if got := a.Percentile(50); got < 2*time.Millisecond || got > 2*time.Millisecond+20*time.Microsecond {
+		t.Errorf("merged Percentile(50) = %v, want about 2ms", got)
+	}
+
+	empty.Merge(&a)
+	if empty.Count() != 3 || empty.Min() != time.Millisecond {
+		t.Errorf("merge into empty: count %d, min %v", empty.Count(), empty.Min())
+	}
+}
@@ -1,320 +1,137 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
-	"sync"
-	"sync/atomic"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-const (
-	baseURL              = "http://localhost:8080"
-	totalRequests        = 1000
-	concurrentRequests   = 50
-	delayBetweenRequests = 50 * time.Millisecond // Delay between each request
-)
-
-var (
-	successCount uint64
-	failureCount uint64
-	totalLatency uint64
-)
-
-type Stats struct {
-	TotalRequests      int
-	SuccessfulRequests int
-	FailedRequests     int
-	AverageLatency     time.Duration
-	TotalDuration      time.Duration
-	RequestsPerSecond  float64
-}
-
-// Sample data structures
-type User struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Address  string `json:"address"`
-	Phone    string `json:"phone"`
-}
-
-type Product struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Price       float64  `json:"price"`
-	Category    string   `json:"category"`
-	Brand       string   `json:"brand"`
-	ImageURL    string   `json:"image_url"`
-	Rating      float64  `json:"rating"`
-	Tags        []string `json:"tags"`
-}
-
-type Order struct {
-	UserID          int     `json:"user_id"`
-	TotalAmount     float64 `json:"total_amount"`
-	Status          string  `json:"status"`
-	PaymentMethod   string  `json:"payment_method"`
-	ShippingAddress string  `json:"shipping_address"`
-}
-
-type Review struct {
-	ProductID string `json:"product_id"`
-	UserID    int    `json:"user_id"`
-	Rating    int    `json:"rating"`
-	Comment   string `json:"comment"`
-}
-
 func main() {
-	log.Println("Starting load test...")
-	log.Printf("Target: %s", baseURL)
-	log.Printf("Total Requests: %d", totalRequests)
-	log.Printf("Concurrent Requests: %d", concurrentRequests)
-	log.Printf("Delay Between Requests: %v", delayBetweenRequests)
-
-	// Check if server is up
-	resp, err := http.Get(baseURL + "/health")
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("Server is not reachable: %v", err)
+		fatal(err)
 	}
-	resp.Body.Close()
-	log.Println("Server is healthy, starting load test...")
-
-	startTime := time.Now()
-
-	// Create a semaphore to limit concurrent requests
-	semaphore := make(chan struct{}, concurrentRequests)
-	var wg sync.WaitGroup
-
-	// Generate and execute requests
-	for i := 0; i < totalRequests; i++ {
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire semaphore
-
-		go func(requestNum int) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // Release semaphore
-
-			makeRandomRequest(requestNum)
-		}(i)
-
-		// Add delay between each request
-		time.Sleep(delayBetweenRequests)
+	var scenario *Scenario
+	if cfg.Scenario != "" {
+		if scenario, err = loadScenario(cfg.Scenario); err != nil {
+			fatal(err)
+		}
 	}
-
-	wg.Wait()
-	totalDuration := time.Since(startTime)
-
-	// Print statistics
-	printStats(Stats{
-		TotalRequests:      totalRequests,
-		SuccessfulRequests: int(atomic.LoadUint64(&successCount)),
-		FailedRequests:     int(atomic.LoadUint64(&failureCount)),
-		AverageLatency:     time.Duration(atomic.LoadUint64(&totalLatency) / uint64(totalRequests)),
-		TotalDuration:      totalDuration,
-		RequestsPerSecond:  float64(totalRequests) / totalDuration.Seconds(),
-	})
-}
-
-func makeRandomRequest(requestNum int) {
-	// Randomly select an endpoint to test
-	endpoints := []func() (string, string, []byte){
-		generateHealthCheck,
-		generateGetUsers,
-		generateGetProducts,
-		generateGetOrders,
-		generateGetInventory,
-		generateGetCategories,
-		generateSearchProducts,
-		generateGetAnalytics,
-		generateGetReviews,
-		generateCreateUser,
-		generateCreateProduct,
-		generateCreateOrder,
-		generateCreateReview,
+	var recording *Recording
+	var replayArrivals arrivals
+	if cfg.Replay != "" {
+		if recording, err = loadRecording(cfg.Replay); err != nil {
+			fatal(err)
+		}
+		if cfg.Duration == 0 {
+			cfg.Requests = len(recording.Requests)
+		}
+		speed, max, _ := parseReplaySpeed(cfg.ReplaySpeed)
+		if !max && recording.Span == 0 && len(recording.Requests) > 1 {
+			fatal(errors.New(`the recording has no timestamps to reproduce; use a replay speed of "max"`))
+		}
+		if !max {
+			replayArrivals = recording.arrivals(speed)
+		}
+	}
+	thresholds, _ := parseThresholds(cfg.Thresholds)
+	var baseline *Report
+	if cfg.Baseline != "" {
+		if baseline, err = loadBaseline(cfg.Baseline); err != nil {
+			fatal(err)
+		}
 	}
 
-	requestStart := time.Now()
-
-	endpoint := endpoints[rand.Intn(len(endpoints))]
-	method, url, body := endpoint()
-
-	var req *http.Request
-	var err error
-
-	if body != nil {
-		req, err = http.NewRequest(method, baseURL+url, bytes.NewBuffer(body))
-		req.Header.Set("Content-Type", "application/json")
+	log.Println("Starting load test...")
+	log.Printf("Target: %s", cfg.BaseURL)
+	if cfg.Duration > 0 {
+		log.Printf("Duration: %v", cfg.Duration)
 	} else {
-		req, err = http.NewRequest(method, baseURL+url, nil)
+		log.Printf("Total Requests: %d", cfg.Requests)
 	}
-
-	if err != nil {
-		atomic.AddUint64(&failureCount, 1)
-		log.Printf("Request #%d: Failed to create request: %v", requestNum, err)
-		return
+	log.Printf("Concurrency: %d", cfg.Concurrency)
+	if scenario != nil {
+		log.Printf("Scenario: %s (%d journeys)", cfg.Scenario, len(scenario.Journeys))
 	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if recording != nil {
+		log.Printf("Replay: %s (%d requests over %v), speed %s", cfg.Replay, len(recording.Requests),
+			recording.Span.Round(time.Millisecond), cfg.ReplaySpeed)
 	}
-
-	resp, err := client.Do(req)
-	latency := time.Since(requestStart)
-	atomic.AddUint64(&totalLatency, uint64(latency))
-
-	if err != nil {
-		atomic.AddUint64(&failureCount, 1)
-		log.Printf("Request #%d: Failed (%s %s): %v", requestNum, method, url, err)
-		return
+	if cfg.Rate > 0 {
+		log.Printf("Target Rate: %.1f req/s (open model)", cfg.Rate)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		atomic.AddUint64(&successCount, 1)
-		if requestNum%100 == 0 {
-			log.Printf("Request #%d: Success (%s %s) - Status: %d, Latency: %v",
-				requestNum, method, url, resp.StatusCode, latency)
-		}
-	} else {
-		atomic.AddUint64(&failureCount, 1)
-		log.Printf("Request #%d: Failed (%s %s) - Status: %d, Latency: %v",
-			requestNum, method, url, resp.StatusCode, latency)
+	if cfg.RampUp > 0 || cfg.WarmUp > 0 {
+		log.Printf("Ramp-up: %v, Warm-up: %v", cfg.RampUp, cfg.WarmUp)
 	}
-}
-
-// Request generators
-func generateHealthCheck() (string, string, []byte) {
-	return "GET", "/health", nil
-}
-
-func generateGetUsers() (string, string, []byte) {
-	return "GET", "/api/users", nil
-}
-
-func generateGetProducts() (string, string, []byte) {
-	return "GET", "/api/products", nil
-}
-
-func generateGetOrders() (string, string, []byte) {
-	return "GET", "/api/orders", nil
-}
-
-func generateGetInventory() (string, string, []byte) {
-	return "GET", "/api/inventory", nil
-}
 
-func generateGetCategories() (string, string, []byte) {
-	return "GET", "/api/categories", nil
-}
-
-func generateSearchProducts() (string, string, []byte) {
-	queries := []string{"laptop", "phone", "book", "shoes", "watch", "camera"}
-	query := queries[rand.Intn(len(queries))]
-	return "GET", "/api/products/search?q=" + query, nil
-}
-
-func generateGetAnalytics() (string, string, []byte) {
-	endpoints := []string{
-		"/api/analytics/sales?start_date=2024-01-01&end_date=2024-12-31",
-		"/api/analytics/popular-products",
-		"/api/analytics/revenue",
+	// Check if server is up, giving up after the per-request timeout
+	health := &http.Client{Timeout: cfg.Timeout}
+	resp, err := health.Get(cfg.BaseURL + "/health")
+	if err != nil {
+		fatal(fmt.Errorf("server is not reachable: %w", err))
 	}
-	return "GET", endpoints[rand.Intn(len(endpoints))], nil
-}
+	resp.Body.Close()
+	log.Println("Server is healthy, starting load test...")
 
-func generateGetReviews() (string, string, []byte) {
-	productID := fmt.Sprintf("prod%d", rand.Intn(100)+1)
-	return "GET", "/api/reviews/product/" + productID, nil
-}
+	// Ctrl-C stops sending and reports what has been measured so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func generateCreateUser() (string, string, []byte) {
-	user := User{
-		Name:     fmt.Sprintf("User_%d", rand.Intn(10000)),
-		Email:    fmt.Sprintf("user%d@example.com", rand.Intn(10000)),
-		Password: "password123",
-		Address:  fmt.Sprintf("%d Main St, City, State", rand.Intn(1000)),
-		Phone:    fmt.Sprintf("+1-555-%04d", rand.Intn(10000)),
+	recorder := NewRecorder()
+	executor := newExecutor(cfg, recorder)
+	work := randomMix(executor)
+	var arrive arrivals
+	if cfg.Rate > 0 {
+		arrive = steadyArrivals(cfg.Rate, cfg.RampUp)
 	}
-	body, _ := json.Marshal(user)
-	return "POST", "/api/users", body
-}
-
-func generateCreateProduct() (string, string, []byte) {
-	categories := []string{"Electronics", "Clothing", "Books", "Home", "Sports"}
-	brands := []string{"BrandA", "BrandB", "BrandC", "BrandD", "BrandE"}
-	tags := [][]string{
-		{"new", "sale", "popular"},
-		{"featured", "bestseller"},
-		{"limited", "exclusive"},
+	switch {
+	case scenario != nil:
+		work = scenario.task(executor)
+	case recording != nil:
+		work, arrive = recording.task(executor), replayArrivals
 	}
+	elapsed := run(ctx, cfg, work, arrive)
 
-	product := Product{
-		Name:        fmt.Sprintf("Product_%d", rand.Intn(10000)),
-		Description: fmt.Sprintf("Description for product %d", rand.Intn(10000)),
-		Price:       float64(rand.Intn(1000)) + 0.99,
-		Category:    categories[rand.Intn(len(categories))],
-		Brand:       brands[rand.Intn(len(brands))],
-		ImageURL:    fmt.Sprintf("https://example.com/image%d.jpg", rand.Intn(100)),
-		Rating:      float64(rand.Intn(5)) + 1.0,
-		Tags:        tags[rand.Intn(len(tags))],
+	report := recorder.Report(cfg, elapsed)
+	report.Checks = evaluateThresholds(thresholds, report)
+	if baseline != nil {
+		report.Checks = append(report.Checks, compareBaseline(baseline, report, cfg.MaxRegression)...)
 	}
-	body, _ := json.Marshal(product)
-	return "POST", "/api/products", body
-}
-
-func generateCreateOrder() (string, string, []byte) {
-	statuses := []string{"pending", "processing", "shipped", "delivered"}
-	paymentMethods := []string{"credit_card", "debit_card", "paypal", "cash"}
-
-	order := Order{
-		UserID:          rand.Intn(100) + 1,
-		TotalAmount:     float64(rand.Intn(500)) + 0.99,
-		Status:          statuses[rand.Intn(len(statuses))],
-		PaymentMethod:   paymentMethods[rand.Intn(len(paymentMethods))],
-		ShippingAddress: fmt.Sprintf("%d Shipping St, City, State", rand.Intn(1000)),
+	if cfg.JSONReport != "-" {
+		report.printText(os.Stdout)
 	}
-	body, _ := json.Marshal(order)
-	return "POST", "/api/orders", body
-}
-
-func generateCreateReview() (string, string, []byte) {
-	comments := []string{
-		"Great product!",
-		"Excellent quality",
-		"Very satisfied",
-		"Could be better",
-		"Amazing purchase",
+	if cfg.JSONReport != "" {
+		if err := report.writeJSON(cfg.JSONReport); err != nil {
+			fatal(fmt.Errorf("writing JSON report: %w", err))
+		}
 	}
 
-	review := Review{
-		ProductID: fmt.Sprintf("prod%d", rand.Intn(100)+1),
-		UserID:    rand.Intn(100) + 1,
-		Rating:    rand.Intn(5) + 1,
-		Comment:   comments[rand.Intn(len(comments))],
+	if failed := failedChecks(report.Checks); failed > 0 {
+		log.Printf("%d of %d checks failed", failed, len(report.Checks))
+		os.Exit(exitChecksFailed)
+	}
+	if len(report.Checks) > 0 {
+		log.Printf("All %d checks passed", len(report.Checks))
 	}
-	body, _ := json.Marshal(review)
-	return "POST", "/api/reviews", body
 }
 
-func printStats(stats Stats) {
-	separator := "============================================================"
-	fmt.Println("\n" + separator)
-	fmt.Println("LOAD TEST RESULTS")
-	fmt.Println(separator)
-	fmt.Printf("Total Requests:       %d\n", stats.TotalRequests)
-	fmt.Printf("Successful Requests:  %d (%.2f%%)\n",
-		stats.SuccessfulRequests,
-		float64(stats.SuccessfulRequests)/float64(stats.TotalRequests)*100)
-	fmt.Printf("Failed Requests:      %d (%.2f%%)\n",
-		stats.FailedRequests,
-		float64(stats.FailedRequests)/float64(stats.TotalRequests)*100)
-	fmt.Printf("Average Latency:      %v\n", stats.AverageLatency)
-	fmt.Printf("Total Duration:       %v\n", stats.TotalDuration)
-	fmt.Printf("Requests/Second:      %.2f\n", stats.RequestsPerSecond)
-	fmt.Println(separator)
+// Exit codes, so CI can tell a performance regression from a broken run
+const (
+	exitChecksFailed = 1
+	exitError        = 2
+)
+
+func fatal(err error) {
+	log.Print(err)
+	os.Exit(exitError)
 }
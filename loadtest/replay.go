@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RecordedRequest is one captured request to replay. In a requests.jsonl
+// stream each line holds one, e.g.
+//
+//	{"timestamp": "2025-12-11T12:05:18.69Z", "method": "GET", "url": "/api/products?limit=10",
+//	 "headers": {"Accept": "application/json"}, "body": ""}
+//
+// url may be absolute; only its path and query are replayed.
+type RecordedRequest struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+}
+
+// Recording is a sequence of captured requests, ordered by timestamp
+type Recording struct {
+	Requests []replayRequest
+	// Span is the time from the first request to the last
+	Span time.Duration
+}
+
+// replayRequest is a RecordedRequest prepared for sending
+type replayRequest struct {
+	name   string
+	method string
+	path   string
+	header http.Header
+	body   []byte
+	offset time.Duration
+}
+
+// Headers that describe the original connection rather than the request;
+// the client sets its own
+var skippedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+// keployTest is the part of a Keploy test case the replay needs
+type keployTest struct {
+	Kind string `yaml:"kind"`
+	Spec struct {
+		Req struct {
+			Method    string            `yaml:"method"`
+			URL       string            `yaml:"url"`
+			Header    map[string]string `yaml:"header"`
+			Body      string            `yaml:"body"`
+			Timestamp time.Time         `yaml:"timestamp"`
+		} `yaml:"req"`
+	} `yaml:"spec"`
+}
+
+// loadRecording reads a Keploy test-set directory (the set itself or its
+// tests/ subdirectory) or a requests.jsonl file
+func loadRecording(source string) (*Recording, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	var recorded []RecordedRequest
+	if info.IsDir() {
+		recorded, err = readKeployTests(source)
+	} else {
+		recorded, err = readRequestsJSONL(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("%s: no requests to replay", source)
+	}
+	return newRecording(recorded)
+}
+
+func readKeployTests(dir string) ([]RecordedRequest, error) {
+	if info, err := os.Stat(filepath.Join(dir, "tests")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, "tests")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var recorded []RecordedRequest
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var test keployTest
+		if err := yaml.Unmarshal(data, &test); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		if test.Kind != "Http" {
+			continue
+		}
+		req := test.Spec.Req
+		recorded = append(recorded, RecordedRequest{
+			Timestamp: req.Timestamp,
+			Method:    req.Method,
+			URL:       req.URL,
+			Headers:   req.Header,
+			Body:      req.Body,
+		})
+	}
+	return recorded, nil
+}
+
+func readRequestsJSONL(file string) ([]RecordedRequest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recorded []RecordedRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var r RecordedRequest
+		if err := json.Unmarshal([]byte(text), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		recorded = append(recorded, r)
+	}
+	return recorded, scanner.Err()
+}
+
+func newRecording(recorded []RecordedRequest) (*Recording, error) {
+	sort.SliceStable(recorded, func(i, j int) bool {
+		return recorded[i].Timestamp.Before(recorded[j].Timestamp)
+	})
+
+	first := recorded[0].Timestamp
+	rec := &Recording{Requests: make([]replayRequest, 0, len(recorded))}
+	for i, r := range recorded {
+		u, err := url.Parse(r.URL)
+		if err != nil || r.Method == "" {
+			return nil, fmt.Errorf("request %d: needs a method and a valid URL", i+1)
+		}
+		header := http.Header{}
+		for key, value := range r.Headers {
+			if key = http.CanonicalHeaderKey(key); !skippedHeaders[key] {
+				header.Set(key, value)
+			}
+		}
+		var body []byte
+		if r.Body != "" {
+			body = []byte(r.Body)
+		}
+		var offset time.Duration
+		if !first.IsZero() && !r.Timestamp.IsZero() {
+			offset = r.Timestamp.Sub(first)
+		}
+		rec.Requests = append(rec.Requests, replayRequest{
+			name:   r.Method + " " + routeName(u.Path),
+			method: r.Method,
+			path:   u.RequestURI(),
+			header: header,
+			body:   body,
+			offset: offset,
+		})
+		rec.Span = offset
+	}
+	return rec, nil
+}
+
+// routeName groups requests by endpoint for the report by replacing path
+// segments that look like IDs (anything containing a digit) with {id}
+func routeName(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.ContainsAny(s, "0123456789") {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// task sends the recorded requests in order, starting over at the end
+func (rec *Recording) task(e *Executor) task {
+	return func(ctx context.Context, it *iteration) {
+		r := rec.Requests[it.seq%len(rec.Requests)]
+		e.Send(ctx, it, r.name, r.method, r.path, r.header, r.body)
+	}
+}
+
+// arrivals reproduces the recorded timing, compressed by speed (2 replays
+// twice as fast). When the recording loops, the next pass starts one
+// average gap after the last request.
+func (rec *Recording) arrivals(speed float64) arrivals {
+	n := len(rec.Requests)
+	period := rec.Span
+	if n > 1 {
+		period += rec.Span / time.Duration(n-1)
+	}
+	return func(i int) time.Duration {
+		offset := time.Duration(i/n)*period + rec.Requests[i%n].offset
+		return time.Duration(float64(offset) / speed)
+	}
+}
+
+// parseReplaySpeed parses -replay-speed: "max", or a positive factor where
+// 1 is the original timing
+func parseReplaySpeed(s string) (speed float64, max bool, err error) {
+	if s == "max" {
+		return 0, true, nil
+	}
+	speed, err = strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, false, errors.New(`replay speed must be "max" or a positive factor such as 1 or 10`)
+	}
+	return speed, false, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/", want: "/"},
+		{path: "/api/products", want: "/api/products"},
+		{path: "/api/users/42", want: "/api/users/{id}"},
+		{path: "/api/products/6579a1b2c3d4e5f6a7b8c9d0/reviews", want: "/api/products/{id}/reviews"},
+		{path: "/api/cart/7/items/12", want: "/api/cart/{id}/items/{id}"},
+		{path: "/api/orders/", want: "/api/orders/"},
+		{path: "/health", want: "/health"},
+	}
+	for _, tt := range tests {
+		if got := routeName(tt.path); got != tt.want {
+			t.Errorf("routeName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRecordingJSONL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "requests.jsonl")
+	writeFile(t, file, `{"timestamp": "2025-12-11T12:00:02Z", "method": "POST", "url": "http://localhost:8080/api/users", "headers": {"content-type": "application/json", "host": "localhost:8080", "Content-Length": "17"}, "body": "{\"name\": \"Ada\"}"}
+
+{"timestamp": "2025-12-11T12:00:00Z", "method": "GET", "url": "/api/products?limit=10", "headers": {"Accept": "application/json", "Accept-Encoding": "gzip"}}
+{"timestamp": "2025-12-11T12:00:05Z", "method": "GET", "url": "/api/users/42"}
+`)
+
+	rec, err := loadRecording(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Span != 5*time.Second {
+		t.Errorf("Span = %v, want 5s", rec.Span)
+	}
+
+	want := []struct {
+		name, method, path, body string
+		offset                   time.Duration
+		header                   map[string]string
+	}{
+		{name: "GET /api/products", method: "GET", path: "/api/products?limit=10",
+			header: map[string]string{"Accept": "application/json"}},
+		{name: "POST /api/users", method: "POST", path: "/api/users", body: `{"name": "Ada"}`, offset: 2 * time.Second,
+			header: map[string]string{"Content-Type": "application/json"}},
+		{name: "GET /api/users/{id}", method: "GET", path: "/api/users/42", offset: 5 * time.Second},
+	}
+	if len(rec.Requests) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(rec.Requests), len(want))
+	}
+	for i, w := range want {
+		r := rec.Requests[i]
+		if r.name != w.name || r.method != w.method || r.path != w.path || string(r.body) != w.body || r.offset != w.offset {
+			t.Errorf("request %d = %s %s %q body %q at %v, want %s %s %q body %q at %v",
+				i, r.name, r.method, r.path, r.body, r.offset, w.name, w.method, w.path, w.body, w.offset)
+		}
+		if len(r.header) != len(w.header) {
+			t.Errorf("request %d headers = %v, want %v", i, r.header, w.header)
+		}
+		for key, value := range w.header {
+			if got := r.header.Get(key); got != value {
+				t.Errorf("request %d header %s = %q, want %q", i, key, got, value)
+			}
+		}
+	}
+}
+
+func TestLoadRecordingKeploy(t *testing.T) {
+	set := t.TempDir()
+	writeFile(t, filepath.Join(set, "tests", "test-1.yaml"), `version: api.keploy.io/v1beta1
+kind: Http
+name: test-1
+spec:
+  req:
+    method: GET
+    url: http://localhost:8080/api/products/6579a1b2c3d4e5f6a7b8c9d0
+    header:
+      Accept: application/json
+    timestamp: 2025-12-11T12:05:19Z
+`)
+	writeFile(t, filepath.Join(set, "tests", "test-2.yaml"), `version: api.keploy.io/v1beta1
+kind: Http
+name: test-2
+spec:
+  req:
+    method: DELETE
+    url: http://localhost:8080/api/cart/3
+    timestamp: 2025-12-11T12:05:18Z
+`)
+	writeFile(t, filepath.Join(set, "tests", "mock.yaml"), `version: api.keploy.io/v1beta1
+kind: Postgres
+name: mock-0
+`)
+
+	// Both the test set and its tests/ directory can be given
+	for _, source := range []string{set, filepath.Join(set, "tests")} {
+		rec, err := loadRecording(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.Requests) != 2 {
+			t.Fatalf("%s: got %d requests, want 2", source, len(rec.Requests))
+		}
+		if got := rec.Requests[0].name; got != "DELETE /api/cart/{id}" {
+			t.Errorf("%s: first request %q, want the earlier DELETE", source, got)
+		}
+		if got := rec.Requests[1]; got.name != "GET /api/products/{id}" || got.offset != time.Second {
+			t.Errorf("%s: second request %q at %v, want GET /api/products/{id} at 1s", source, got.name, got.offset)
+		}
+	}
+}
+
+func TestLoadRecordingErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "empty.jsonl", content: "\n", wantErr: "no requests to replay"},
+		{name: "bad.jsonl", content: `{"method": "GET", "url": "/a"}` + "\n{not json}\n", wantErr: "bad.jsonl:2"},
+		{name: "no-method.jsonl", content: `{"url": "/a"}`, wantErr: "request 1: needs a method"},
+		{name: "bad-url.jsonl", content: `{"method": "GET", "url": "http://[::1"}`, wantErr: "request 1: needs a method and a valid URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name)
+			writeFile(t, file, tt.content)
+			if _, err := loadRecording(file); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := loadRecording(filepath.Join(dir, "missing.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("missing file: error = %v, want not exist", err)
+	}
+}
+
+func TestArrivals(t *testing.T) {
+	recording := func(offsets ...time.Duration) *Recording {
+		rec := &Recording{}
+		for _, offset := range offsets {
+			rec.Requests = append(rec.Requests, replayRequest{offset: offset})
+			rec.Span = offset
+		}
+		return rec
+	}
+
+	tests := []struct {
+		name  string
+		rec   *Recording
+		speed float64
+		want  []time.Duration
+	}{
+		{
+			name:  "original timing",
+			rec:   recording(0, time.Second, 3*time.Second),
+			speed: 1,
+			want:  []time.Duration{0, time.Second, 3 * time.Second},
+		},
+		{
+			// The next pass starts one average gap (1.5s) after the last
+			// request
+			name:  "loops",
+			rec:   recording(0, time.Second, 3*time.Second),
+			speed: 1,
+			want: []time.Duration{0, time.Second, 3 * time.Second,
+				4500 * time.Millisecond, 5500 * time.Millisecond, 7500 * time.Millisecond, 9 * time.Second},
+		},
+		{
+			name:  "faster",
+			rec:   recording(0, time.Second, 3*time.Second),
+			speed: 2,
+			want:  []time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond, 2250 * time.Millisecond},
+		},
+		{
+			name:  "slower",
+			rec:   recording(0, time.Second, 3*time.Second),
+			speed: 0.5,
+			want:  []time.Duration{0, 2 * time.Second, 6 * time.Second, 9 * time.Second},
+		},
+		{
+			name:  "single request",
+			rec:   recording(0),
+			speed: 1,
+			want:  []time.Duration{0, 0, 0},
+		},
+		{
+			name:  "simultaneous requests",
+			rec:   recording(0, 0),
+			speed: 1,
+			want:  []time.Duration{0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrive := tt.rec.arrivals(tt.speed)
+			for i, want := range tt.want {
+				if got := arrive(i); got != want {
+					t.Errorf("arrival %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
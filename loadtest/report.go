@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Report is the machine-readable result of a run, written with -json.
+// Latencies are in milliseconds and rates are fractions (0.01 = 1%).
+type Report struct {
+	BaseURL     string           `json:"base_url"`
+	Mode        string           `json:"mode"`
+	Concurrency int              `json:"concurrency"`
+	TargetRate  float64          `json:"target_rate,omitempty"`
+	DurationSec float64          `json:"duration_seconds"`
+	Overall     EndpointReport   `json:"overall"`
+	Endpoints   []EndpointReport `json:"endpoints"`
+	// Checks are the threshold and baseline results; the run fails if any
+	// didn't pass
+	Checks []Check `json:"checks,omitempty"`
+}
+
+type EndpointReport struct {
+	Name        string         `json:"name"`
+	Requests    int            `json:"requests"`
+	Successes   int            `json:"successes"`
+	Failures    int            `json:"failures"`
+	ErrorRate   float64        `json:"error_rate"`
+	Throughput  float64        `json:"throughput"`
+	Latency     LatencyReport  `json:"latency_ms"`
+	StatusCodes map[string]int `json:"status_codes"`
+	Errors      map[string]int `json:"errors"`
+}
+
+type LatencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// writeJSON writes the report to path, or to stdout for "-"
+func (r Report) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (r Report) printText(w io.Writer) {
+	separator := strings.Repeat("=", 100)
+	fmt.Fprintln(w, "\n"+separator)
+	fmt.Fprintln(w, "LOAD TEST RESULTS")
+	fmt.Fprintln(w, separator)
+
+	o := r.Overall
+	fmt.Fprintf(w, "Total Requests:       %d\n", o.Requests)
+	fmt.Fprintf(w, "Successful Requests:  %d (%.2f%%)\n", o.Successes, percent(o.Successes, o.Requests))
+	fmt.Fprintf(w, "Failed Requests:      %d (%.2f%%)\n", o.Failures, percent(o.Failures, o.Requests))
+	fmt.Fprintf(w, "Total Duration:       %.2fs\n", r.DurationSec)
+	fmt.Fprintf(w, "Requests/Second:      %.2f\n", o.Throughput)
+	fmt.Fprintf(w, "Latency (ms):         p50 %.1f | p90 %.1f | p95 %.1f | p99 %.1f | max %.1f | mean %.1f\n",
+		o.Latency.P50, o.Latency.P90, o.Latency.P95, o.Latency.P99, o.Latency.Max, o.Latency.Mean)
+	fmt.Fprintln(w, separator)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Endpoint\tRequests\tErrors\tRPS\tp50\tp90\tp95\tp99\tMax\t")
+	rows := append([]EndpointReport{}, r.Endpoints...)
+	for _, e := range append(rows, o) {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			e.Name, e.Requests, e.ErrorRate*100, e.Throughput,
+			e.Latency.P50, e.Latency.P90, e.Latency.P95, e.Latency.P99, e.Latency.Max)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "(latencies in ms)")
+
+	fmt.Fprintln(w)
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(w, "%-24s status %s", e.Name, formatCounts(e.StatusCodes))
+		if len(e.Errors) > 0 {
+			fmt.Fprintf(w, "  errors %s", formatCounts(e.Errors))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, separator)
+
+	if len(r.Checks) > 0 {
+		fmt.Fprintln(w, "CHECKS")
+		for _, c := range r.Checks {
+			fmt.Fprintln(w, c)
+		}
+		fmt.Fprintln(w, separator)
+	}
+}
+
+// formatCounts renders {"200": 5, "500": 1} as "200×5 500×1"
+func formatCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s×%d", k, counts[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
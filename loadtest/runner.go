@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// iteration is one unit of scheduled work: a single request for the random
+// mix or a replay, or a whole journey for a scenario. Requests in an
+// iteration started during warm-up aren't recorded.
+type iteration struct {
+	// seq numbers iterations from 0 in the order they were started
+	seq      int
+	measured bool
+	// scheduled is when the open model meant the iteration to start. The
+	// first request's latency is measured from it, so time spent waiting
+	// for a free slot counts against the server (no coordinated omission).
+	scheduled time.Time
+}
+
+// task runs one iteration
+type task func(ctx context.Context, it *iteration)
+
+// Executor sends requests and records their results
+type Executor struct {
+	baseURL  string
+	client   *http.Client
+	recorder *Recorder
+}
+
+func newExecutor(cfg Config, recorder *Recorder) *Executor {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.Concurrency
+	return &Executor{
+		baseURL:  cfg.BaseURL,
+		client:   &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		recorder: recorder,
+	}
+}
+
+// Do sends one request, records it under name and returns the status code
+// and response body
+func (e *Executor) Do(ctx context.Context, it *iteration, name, method, path string, body []byte) (int, []byte, error) {
+	return e.Send(ctx, it, name, method, path, nil, body)
+}
+
+// Send is Do with extra request headers
+func (e *Executor) Send(ctx context.Context, it *iteration, name, method, path string, header http.Header, body []byte) (int, []byte, error) {
+	start := time.Now()
+	if !it.scheduled.IsZero() {
+		start, it.scheduled = it.scheduled, time.Time{}
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.baseURL+path, reader)
+	if err != nil {
+		e.record(it, name, start, 0, err)
+		return 0, nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		e.record(it, name, start, 0, err)
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	e.record(it, name, start, resp.StatusCode, err)
+	return resp.StatusCode, respBody, err
+}
+
+func (e *Executor) record(it *iteration, name string, start time.Time, status int, err error) {
+	if it.measured {
+		e.recorder.Record(Result{Name: name, Latency: time.Since(start), Status: status, Err: err})
+	}
+}
+
+// arrivals gives the offset from the start of the run at which the i-th
+// iteration is due, for the open model
+type arrivals func(i int) time.Duration
+
+// run executes iterations of t until the request budget or duration is
+// used up, or ctx is cancelled: on the schedule given by arrive if it's
+// set, otherwise back-to-back. It returns how long the measured part of
+// the run took.
+func run(ctx context.Context, cfg Config, t task, arrive arrivals) time.Duration {
+	start := time.Now()
+	measureFrom := start.Add(cfg.WarmUp)
+	deadline := measureFrom.Add(cfg.Duration)
+	var claimed int64
+
+	// claim decides whether an iteration starting at the given time should
+	// run, and whether it is measured
+	claim := func(at time.Time) (measured, ok bool) {
+		if ctx.Err() != nil {
+			return false, false
+		}
+		if at.Before(measureFrom) {
+			return false, true
+		}
+		if cfg.Duration > 0 {
+			return true, at.Before(deadline)
+		}
+		return true, atomic.AddInt64(&claimed, 1) <= int64(cfg.Requests)
+	}
+
+	var wg sync.WaitGroup
+	if arrive != nil {
+		runOpen(ctx, cfg, start, arrive, claim, t, &wg)
+	} else {
+		runClosed(ctx, cfg, claim, t, &wg)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(measureFrom); elapsed > 0 {
+		return elapsed
+	}
+	return 0
+}
+
+// runClosed keeps Concurrency workers sending back-to-back. With a ramp-up
+// the workers start one by one, evenly spread over the period.
+func runClosed(ctx context.Context, cfg Config, claim func(time.Time) (bool, bool), t task, wg *sync.WaitGroup) {
+	var started int64
+	for w := 0; w < cfg.Concurrency; w++ {
+		delay := time.Duration(int64(cfg.RampUp) * int64(w) / int64(cfg.Concurrency))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !sleepUntil(ctx, time.Now().Add(delay)) {
+				return
+			}
+			for {
+				measured, ok := claim(time.Now())
+				if !ok {
+					return
+				}
+				seq := int(atomic.AddInt64(&started, 1) - 1)
+				t(ctx, &iteration{seq: seq, measured: measured})
+			}
+		}()
+	}
+}
+
+// runOpen starts iterations on a fixed arrival schedule, with at most
+// Concurrency in flight. When every slot is busy the next iteration waits
+// for one, and that wait is part of its latency.
+func runOpen(ctx context.Context, cfg Config, start time.Time, arrive arrivals, claim func(time.Time) (bool, bool), t task, wg *sync.WaitGroup) {
+	slots := make(chan struct{}, cfg.Concurrency)
+	for i := 0; ; i++ {
+		at := start.Add(arrive(i))
+		if !sleepUntil(ctx, at) {
+			return
+		}
+		measured, ok := claim(at)
+		if !ok {
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			t(ctx, &iteration{seq: seq, measured: measured, scheduled: at})
+		}(i)
+	}
+}
+
+// steadyArrivals schedules iterations at rate per second. During the
+// ramp-up the rate climbs linearly from 0 to rate, so i iterations have
+// arrived by t = sqrt(2·rampUp·i / rate); afterwards arrivals are 1/rate
+// apart.
+func steadyArrivals(rate float64, rampUp time.Duration) arrivals {
+	return func(i int) time.Duration {
+		return arrivalOffset(i, rate, rampUp)
+	}
+}
+
+func arrivalOffset(i int, rate float64, rampUp time.Duration) time.Duration {
+	ramp := rampUp.Seconds()
+	rampArrivals := rate * ramp / 2
+	var seconds float64
+	if float64(i) < rampArrivals {
+		seconds = math.Sqrt(2 * ramp * float64(i) / rate)
+	} else {
+		seconds = ramp + (float64(i)-rampArrivals)/rate
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// sleepUntil waits until t, returning false if ctx is cancelled first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
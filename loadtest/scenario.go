@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a weighted set of user journeys, loaded from YAML. Each
+// iteration of the run picks one journey by weight and runs its steps in
+// order, so -requests counts journeys rather than individual requests.
+type Scenario struct {
+	Journeys []*Journey `yaml:"journeys"`
+
+	totalWeight int
+}
+
+// Journey is a sequence of requests made by one simulated user
+type Journey struct {
+	Name   string  `yaml:"name"`
+	Weight int     `yaml:"weight"`
+	Steps  []*Step `yaml:"steps"`
+	// ThinkTime is the pause between steps, unless a step sets its own
+	ThinkTime time.Duration `yaml:"think_time"`
+}
+
+// Step is one request. Path and Body are Go templates that can use values
+// captured by earlier steps (e.g. {{.user_id}}) and the helper functions
+// in templateFuncs. Capture maps variable names to paths into the JSON
+// response, such as "id", "items.0.id" or "*.id"; a "*" picks a random
+// array element, and every capture in a step that goes through the same
+// array picks the same element.
+type Step struct {
+	Name      string            `yaml:"name"`
+	Method    string            `yaml:"method"`
+	Path      string            `yaml:"path"`
+	Body      string            `yaml:"body"`
+	Capture   map[string]string `yaml:"capture"`
+	ThinkTime *time.Duration    `yaml:"think_time"`
+
+	path *template.Template
+	body *template.Template
+}
+
+// errJourneyAborted is recorded for a step whose journey can't continue
+// because an earlier step failed or a value couldn't be captured
+var errJourneyAborted = errors.New("journey aborted")
+
+// sequence numbers values such as e-mail addresses so they are unique
+// across the whole run
+var sequence int64
+
+var templateFuncs = template.FuncMap{
+	// randInt returns a random integer in [min, max]
+	"randInt": func(min, max int) int { return min + mathrand.Intn(max-min+1) },
+	// pick returns one of its arguments at random
+	"pick": func(options ...string) string { return options[mathrand.Intn(len(options))] },
+	// uuid returns a random 128-bit hex string
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	},
+	// seq returns a number unique within the run
+	"seq": func() int64 { return atomic.AddInt64(&sequence, 1) },
+	// pathEscape escapes a captured value for use as a path segment
+	"pathEscape": url.PathEscape,
+}
+
+func loadScenario(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var s Scenario
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) compile() error {
+	if len(s.Journeys) == 0 {
+		return errors.New("no journeys defined")
+	}
+	for _, j := range s.Journeys {
+		if j.Name == "" {
+			return errors.New("every journey needs a name")
+		}
+		if j.Weight < 0 {
+			return fmt.Errorf("journey %s: weight must not be negative", j.Name)
+		}
+		if j.Weight == 0 {
+			j.Weight = 1
+		}
+		if len(j.Steps) == 0 {
+			return fmt.Errorf("journey %s: no steps", j.Name)
+		}
+		s.totalWeight += j.Weight
+
+		for i, step := range j.Steps {
+			if step.Name == "" {
+				step.Name = strconv.Itoa(i + 1)
+			}
+			if step.Method == "" {
+				step.Method = "GET"
+			}
+			step.Method = strings.ToUpper(step.Method)
+			where := fmt.Sprintf("journey %s, step %s", j.Name, step.Name)
+			if step.Path == "" {
+				return fmt.Errorf("%s: path is required", where)
+			}
+			var err error
+			if step.path, err = parseTemplate(step.Path); err != nil {
+				return fmt.Errorf("%s: path: %w", where, err)
+			}
+			if step.Body != "" {
+				if step.body, err = parseTemplate(step.Body); err != nil {
+					return fmt.Errorf("%s: body: %w", where, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// pick chooses a journey at random, in proportion to the weights
+func (s *Scenario) pick() *Journey {
+	n := mathrand.Intn(s.totalWeight)
+	for _, j := range s.Journeys {
+		if n < j.Weight {
+			return j
+		}
+		n -= j.Weight
+	}
+	return s.Journeys[len(s.Journeys)-1]
+}
+
+// task runs one weighted-random journey per iteration
+func (s *Scenario) task(e *Executor) task {
+	return func(ctx context.Context, it *iteration) {
+		s.pick().run(ctx, e, it)
+	}
+}
+
+// run performs the journey's steps in order. Results are named
+// "journey/step". If a step fails, the remaining steps are recorded as
+// aborted rather than sent with missing data.
+func (j *Journey) run(ctx context.Context, e *Executor, it *iteration) {
+	vars := map[string]string{}
+	for i, step := range j.Steps {
+		name := j.Name + "/" + step.Name
+		if i > 0 {
+			pause := j.ThinkTime
+			if step.ThinkTime != nil {
+				pause = *step.ThinkTime
+			}
+			if !sleepUntil(ctx, time.Now().Add(pause)) {
+				return
+			}
+		}
+
+		path, body, err := step.render(vars)
+		if err != nil {
+			e.abort(it, j, i, err)
+			return
+		}
+		status, respBody, err := e.Do(ctx, it, name, step.Method, path, body)
+		if err != nil || status < 200 || status > 299 {
+			e.abort(it, j, i+1, errJourneyAborted)
+			return
+		}
+		if err := step.capture(respBody, vars); err != nil {
+			e.abort(it, j, i+1, err)
+			return
+		}
+	}
+}
+
+// abort records the journey's steps from index from onwards as failed
+func (e *Executor) abort(it *iteration, j *Journey, from int, err error) {
+	for _, step := range j.Steps[from:] {
+		e.record(it, j.Name+"/"+step.Name, time.Now(), 0, err)
+	}
+}
+
+func (s *Step) render(vars map[string]string) (string, []byte, error) {
+	var path strings.Builder
+	if err := s.path.Execute(&path, vars); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errJourneyAborted, err)
+	}
+	if s.body == nil {
+		return path.String(), nil, nil
+	}
+	var body strings.Builder
+	if err := s.body.Execute(&body, vars); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errJourneyAborted, err)
+	}
+	return path.String(), []byte(body.String()), nil
+}
+
+// capture stores the step's captured values from a JSON response body
+func (s *Step) capture(body []byte, vars map[string]string) error {
+	if len(s.Capture) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("%w: response is not JSON", errJourneyAborted)
+	}
+
+	chosen := map[string]int{}
+	for name, path := range s.Capture {
+		value, err := lookup(doc, path, chosen)
+		if err != nil {
+			return fmt.Errorf("%w: capturing %s: %v", errJourneyAborted, name, err)
+		}
+		vars[name] = value
+	}
+	return nil
+}
+
+// lookup follows a dotted path through a decoded JSON document. chosen
+// remembers the element picked for each "*" so related captures agree.
+func lookup(doc interface{}, path string, chosen map[string]int) (string, error) {
+	current := doc
+	var walked []string
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[part]
+			if !ok {
+				return "", fmt.Errorf("no field %q", part)
+			}
+			current = value
+		case []interface{}:
+			if len(node) == 0 {
+				return "", errors.New("empty array")
+			}
+			var i int
+			if part == "*" {
+				prefix := strings.Join(walked, ".")
+				idx, ok := chosen[prefix]
+				if !ok || idx >= len(node) {
+					idx = mathrand.Intn(len(node))
+					chosen[prefix] = idx
+				}
+				i = idx
+			} else {
+				n, err := strconv.Atoi(part)
+				if err != nil || n < 0 || n >= len(node) {
+					return "", fmt.Errorf("bad array index %q", part)
+				}
+				i = n
+			}
+			current = node[i]
+		default:
+			return "", fmt.Errorf("can't index %q into a scalar", part)
+		}
+		walked = append(walked, part)
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", errors.New("value is null")
+	default:
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	}
+}
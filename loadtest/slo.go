@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is a pass/fail rule on one metric, such as "p95 < 200ms" or
+// "GetProducts: error_rate < 1%"
+type Threshold struct {
+	// Endpoint is an endpoint name or glob ("shopper/*"); empty means the
+	// overall results
+	Endpoint string
+	Metric   string
+	Op       string
+	// Limit is in the report's units: milliseconds, a fraction or req/s
+	Limit float64
+}
+
+// Check is the outcome of a threshold or baseline comparison on one
+// endpoint
+type Check struct {
+	// Kind is "threshold" or "baseline"
+	Kind     string  `json:"kind"`
+	Endpoint string  `json:"endpoint"`
+	Metric   string  `json:"metric"`
+	Op       string  `json:"op"`
+	Limit    float64 `json:"limit"`
+	Actual   float64 `json:"actual"`
+	Passed   bool    `json:"passed"`
+	// Baseline is the previous run's value a baseline check's limit is
+	// derived from
+	Baseline *float64 `json:"baseline,omitempty"`
+	// Error explains a check that couldn't be evaluated
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	// Baseline latency checks allow this much absolute slack, so a 0.5ms
+	// endpoint doesn't fail for taking 0.6ms
+	baselineLatencySlackMs = 1.0
+	// Error rates may rise by this much (one percentage point) before a
+	// baseline check fails; a relative limit is too noisy near zero
+	baselineErrorRateSlack = 0.01
+	// Endpoints with fewer requests than this in either run aren't compared,
+	// since their percentiles mostly reflect chance
+	baselineMinRequests = 100
+)
+
+var thresholdPattern = regexp.MustCompile(`^\s*(?:([^:]+?)\s*:\s*)?([a-z0-9_]+)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+// parseThreshold parses "[endpoint:] metric op value". Latency metrics
+// (min, mean, p50, p90, p95, p99, max) take a duration or milliseconds,
+// error_rate a percentage or fraction and throughput requests per second.
+func parseThreshold(rule string) (Threshold, error) {
+	m := thresholdPattern.FindStringSubmatch(rule)
+	if m == nil {
+		return Threshold{}, fmt.Errorf("threshold %q: want [endpoint:] metric op value, e.g. \"p95 < 200ms\"", rule)
+	}
+	t := Threshold{Endpoint: m[1], Metric: m[2], Op: m[3]}
+	if t.Endpoint == "overall" {
+		t.Endpoint = ""
+	}
+
+	var err error
+	switch {
+	case isLatencyMetric(t.Metric):
+		var d time.Duration
+		if d, err = time.ParseDuration(m[4]); err == nil {
+			t.Limit = millis(d)
+		} else {
+			t.Limit, err = strconv.ParseFloat(m[4], 64)
+		}
+	case t.Metric == "error_rate":
+		if pct, ok := strings.CutSuffix(m[4], "%"); ok {
+			t.Limit, err = strconv.ParseFloat(pct, 64)
+			t.Limit /= 100
+		} else {
+			t.Limit, err = strconv.ParseFloat(m[4], 64)
+		}
+	case t.Metric == "throughput":
+		t.Limit, err = strconv.ParseFloat(strings.TrimSuffix(m[4], "/s"), 64)
+	default:
+		return Threshold{}, fmt.Errorf("threshold %q: unknown metric %q", rule, t.Metric)
+	}
+	if err != nil {
+		return Threshold{}, fmt.Errorf("threshold %q: bad value %q", rule, m[4])
+	}
+	return t, nil
+}
+
+func parseThresholds(rules []string) ([]Threshold, error) {
+	thresholds := make([]Threshold, 0, len(rules))
+	var errs []error
+	for _, rule := range rules {
+		t, err := parseThreshold(rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, errors.Join(errs...)
+}
+
+func isLatencyMetric(metric string) bool {
+	switch metric {
+	case "min", "mean", "p50", "p90", "p95", "p99", "max":
+		return true
+	}
+	return false
+}
+
+func metricValue(e EndpointReport, metric string) float64 {
+	switch metric {
+	case "min":
+		return e.Latency.Min
+	case "mean":
+		return e.Latency.Mean
+	case "p50":
+		return e.Latency.P50
+	case "p90":
+		return e.Latency.P90
+	case "p95":
+		return e.Latency.P95
+	case "p99":
+		return e.Latency.P99
+	case "max":
+		return e.Latency.Max
+	case "error_rate":
+		return e.ErrorRate
+	default:
+		return e.Throughput
+	}
+}
+
+func compare(actual float64, op string, limit float64) bool {
+	switch op {
+	case "<":
+		return actual < limit
+	case "<=":
+		return actual <= limit
+	case ">":
+		return actual > limit
+	default:
+		return actual >= limit
+	}
+}
+
+// evaluateThresholds checks each threshold against every endpoint it
+// matches. A threshold that matches nothing fails, so a renamed endpoint
+// can't silently stop being checked.
+func evaluateThresholds(thresholds []Threshold, r Report) []Check {
+	var checks []Check
+	for _, t := range thresholds {
+		var targets []EndpointReport
+		if t.Endpoint == "" {
+			targets = []EndpointReport{r.Overall}
+		} else {
+			for _, e := range r.Endpoints {
+				if endpointMatches(t.Endpoint, e.Name) {
+					targets = append(targets, e)
+				}
+			}
+		}
+		if len(targets) == 0 {
+			checks = append(checks, Check{Kind: "threshold", Endpoint: t.Endpoint, Metric: t.Metric,
+				Op: t.Op, Limit: t.Limit, Error: "no requests to a matching endpoint"})
+			continue
+		}
+		for _, e := range targets {
+			actual := metricValue(e, t.Metric)
+			checks = append(checks, Check{Kind: "threshold", Endpoint: e.Name, Metric: t.Metric,
+				Op: t.Op, Limit: t.Limit, Actual: actual, Passed: compare(actual, t.Op, t.Limit)})
+		}
+	}
+	return checks
+}
+
+// endpointMatches reports whether a threshold's endpoint glob matches an
+// endpoint name. Replayed endpoints are named "GET /api/products", so the
+// glob is also tried against the path alone: "/api/products" covers every
+// method.
+func endpointMatches(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	if _, route, found := strings.Cut(name, " "); found {
+		ok, _ := path.Match(pattern, route)
+		return ok
+	}
+	return false
+}
+
+// loadBaseline reads a report written by a previous run with -json
+func loadBaseline(file string) (*Report, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parsing baseline %s: %w", file, err)
+	}
+	return &r, nil
+}
+
+// compareBaseline fails an endpoint whose p95 latency grew by more than
+// maxRegression (a fraction) over the baseline, or whose error rate rose
+// by more than baselineErrorRateSlack. Overall, p99 latency and throughput
+// are compared too. Endpoints missing from either run, or with too few
+// requests to compare, are skipped.
+func compareBaseline(baseline *Report, r Report, maxRegression float64) []Check {
+	previous := map[string]EndpointReport{}
+	for _, e := range baseline.Endpoints {
+		previous[e.Name] = e
+	}
+
+	var checks []Check
+	check := func(e EndpointReport, metric, op string, limit, base float64) {
+		actual := metricValue(e, metric)
+		checks = append(checks, Check{Kind: "baseline", Endpoint: e.Name, Metric: metric, Op: op,
+			Limit: limit, Actual: actual, Passed: compare(actual, op, limit), Baseline: &base})
+	}
+	compareLatency := func(e, prev EndpointReport, metric string) {
+		base := metricValue(prev, metric)
+		check(e, metric, "<=", math.Max(base*(1+maxRegression), base+baselineLatencySlackMs), base)
+	}
+	compareEndpoint := func(e, prev EndpointReport) bool {
+		if e.Requests < baselineMinRequests || prev.Requests < baselineMinRequests {
+			return false
+		}
+		compareLatency(e, prev, "p95")
+		check(e, "error_rate", "<=", prev.ErrorRate+baselineErrorRateSlack, prev.ErrorRate)
+		return true
+	}
+
+	for _, e := range r.Endpoints {
+		if prev, ok := previous[e.Name]; ok {
+			compareEndpoint(e, prev)
+		}
+	}
+	if compareEndpoint(r.Overall, baseline.Overall) {
+		compareLatency(r.Overall, baseline.Overall, "p99")
+		base := baseline.Overall.Throughput
+		check(r.Overall, "throughput", ">=", base*(1-maxRegression), base)
+	}
+	return checks
+}
+
+func formatMetric(metric string, v float64) string {
+	switch {
+	case isLatencyMetric(metric):
+		return fmt.Sprintf("%.1fms", v)
+	case metric == "error_rate":
+		return fmt.Sprintf("%.2f%%", v*100)
+	default:
+		return fmt.Sprintf("%.1f/s", v)
+	}
+}
+
+func (c Check) String() string {
+	verdict := "PASS"
+	if !c.Passed {
+		verdict = "FAIL"
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "overall"
+	}
+	s := fmt.Sprintf("%s  %-24s %s %s %s", verdict, endpoint, c.Metric, c.Op, formatMetric(c.Metric, c.Limit))
+	switch {
+	case c.Error != "":
+		s += "  (" + c.Error + ")"
+	case c.Baseline != nil:
+		s += fmt.Sprintf("  actual %s, baseline %s", formatMetric(c.Metric, c.Actual), formatMetric(c.Metric, *c.Baseline))
+	default:
+		s += "  actual " + formatMetric(c.Metric, c.Actual)
+	}
+	return s
+}
+
+func failedChecks(checks []Check) int {
+	n := 0
+	for _, c := range checks {
+		if !c.Passed {
+			n++
+		}
+	}
+	return n
+}
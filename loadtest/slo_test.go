@@ -0,0 +1,234 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    Threshold
+		wantErr string
+	}{
+		{rule: "p95 < 200ms", want: Threshold{Metric: "p95", Op: "<", Limit: 200}},
+		{rule: "p99<=1.5s", want: Threshold{Metric: "p99", Op: "<=", Limit: 1500}},
+		{rule: "mean < 250", want: Threshold{Metric: "mean", Op: "<", Limit: 250}},
+		{rule: "overall: max < 2s", want: Threshold{Metric: "max", Op: "<", Limit: 2000}},
+		{rule: "GetProducts: error_rate < 1%", want: Threshold{Endpoint: "GetProducts", Metric: "error_rate", Op: "<", Limit: 0.01}},
+		{rule: "error_rate <= 0.005", want: Threshold{Metric: "error_rate", Op: "<=", Limit: 0.005}},
+		{rule: "throughput >= 150/s", want: Threshold{Metric: "throughput", Op: ">=", Limit: 150}},
+		{rule: "shopper/*: p90 > 1ms", want: Threshold{Endpoint: "shopper/*", Metric: "p90", Op: ">", Limit: 1}},
+		{rule: "GET /api/products/{id}: p95 < 100ms", want: Threshold{Endpoint: "GET /api/products/{id}", Metric: "p95", Op: "<", Limit: 100}},
+		{rule: "p95 200ms", wantErr: "want [endpoint:] metric op value"},
+		{rule: "latency < 1s", wantErr: `unknown metric "latency"`},
+		{rule: "p95 < fast", wantErr: `bad value "fast"`},
+		{rule: "error_rate < 1%%", wantErr: "bad value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := parseThreshold(tt.rule)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateThresholds(t *testing.T) {
+	endpoint := func(name string, p95 float64) EndpointReport {
+		return EndpointReport{Name: name, Requests: 100, Latency: LatencyReport{P95: p95}}
+	}
+	r := Report{
+		Overall: EndpointReport{Name: "", Requests: 600, ErrorRate: 0.02, Latency: LatencyReport{P95: 120}},
+		Endpoints: []EndpointReport{
+			endpoint("GetProducts", 80),
+			endpoint("shopper/browse", 90),
+			endpoint("shopper/checkout", 300),
+			endpoint("GET /api/products", 50),
+			endpoint("POST /api/products", 250),
+			endpoint("GET /api/products/{id}", 40),
+		},
+	}
+
+	tests := []struct {
+		rule string
+		// want maps each endpoint the rule should be checked against to
+		// whether it passes
+		want map[string]bool
+		// unmatched means the rule matches no endpoint
+		unmatched bool
+	}{
+		{rule: "p95 < 200ms", want: map[string]bool{"": true}},
+		{rule: "error_rate < 1%", want: map[string]bool{"": false}},
+		{rule: "GetProducts: p95 < 100ms", want: map[string]bool{"GetProducts": true}},
+		{rule: "shopper/*: p95 < 200ms", want: map[string]bool{"shopper/browse": true, "shopper/checkout": false}},
+		{rule: "GET /api/products: p95 < 100ms", want: map[string]bool{"GET /api/products": true}},
+		{rule: "/api/products: p95 < 200ms", want: map[string]bool{"GET /api/products": true, "POST /api/products": false}},
+		{rule: "/api/products/*: p95 < 100ms", want: map[string]bool{"GET /api/products/{id}": true}},
+		{rule: "GET /api/*: p95 < 100ms", want: map[string]bool{"GET /api/products": true}},
+		{rule: "GetCart: p95 < 100ms", unmatched: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			threshold, err := parseThreshold(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checks := evaluateThresholds([]Threshold{threshold}, r)
+			if tt.unmatched {
+				if len(checks) != 1 || checks[0].Passed || checks[0].Error == "" {
+					t.Fatalf("checks = %+v, want one failed check with an error", checks)
+				}
+				return
+			}
+			got := map[string]bool{}
+			for _, c := range checks {
+				if c.Error != "" {
+					t.Errorf("%s: unexpected error %q", c.Endpoint, c.Error)
+				}
+				got[c.Endpoint] = c.Passed
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("checked %v, want %v", got, tt.want)
+			}
+			for name, passed := range tt.want {
+				if p, ok := got[name]; !ok || p != passed {
+					t.Errorf("%q: passed = %v (checked %v), want %v", name, p, ok, passed)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareBaseline(t *testing.T) {
+	type metrics struct {
+		requests   int
+		p95, p99   float64
+		errorRate  float64
+		throughput float64
+	}
+	endpoint := func(name string, m metrics) EndpointReport {
+		return EndpointReport{Name: name, Requests: m.requests, ErrorRate: m.errorRate, Throughput: m.throughput,
+			Latency: LatencyReport{P95: m.p95, P99: m.p99}}
+	}
+	overall := metrics{requests: 1000, p95: 100, p99: 200, errorRate: 0.001, throughput: 100}
+
+	tests := []struct {
+		name            string
+		before, after   metrics
+		overallAfter    *metrics
+		want            map[string]bool
+		wantSkippedName bool
+	}{
+		{
+			name:   "within the allowed regression",
+			before: metrics{requests: 500, p95: 100},
+			after:  metrics{requests: 500, p95: 109},
+			want:   map[string]bool{"p95": true, "error_rate": true},
+		},
+		{
+			name:   "p95 regression",
+			before: metrics{requests: 500, p95: 100},
+			after:  metrics{requests: 500, p95: 111},
+			want:   map[string]bool{"p95": false, "error_rate": true},
+		},
+		{
+			name:   "slack for fast endpoints",
+			before: metrics{requests: 500, p95: 0.5},
+			after:  metrics{requests: 500, p95: 1.4},
+			want:   map[string]bool{"p95": true, "error_rate": true},
+		},
+		{
+			name:   "error rate within a percentage point",
+			before: metrics{requests: 500, p95: 10, errorRate: 0.01},
+			after:  metrics{requests: 500, p95: 10, errorRate: 0.019},
+			want:   map[string]bool{"p95": true, "error_rate": true},
+		},
+		{
+			name:   "error rate rise",
+			before: metrics{requests: 500, p95: 10, errorRate: 0.01},
+			after:  metrics{requests: 500, p95: 10, errorRate: 0.03},
+			want:   map[string]bool{"p95": true, "error_rate": false},
+		},
+		{
+			name:   "too few requests now",
+			before: metrics{requests: 500, p95: 10},
+			after:  metrics{requests: 99, p95: 100},
+			want:   map[string]bool{},
+		},
+		{
+			name:   "too few requests in the baseline",
+			before: metrics{requests: 50, p95: 10},
+			after:  metrics{requests: 500, p95: 100},
+			want:   map[string]bool{},
+		},
+		{
+			name:            "endpoint missing from the baseline",
+			after:           metrics{requests: 500, p95: 100},
+			want:            map[string]bool{},
+			wantSkippedName: true,
+		},
+		{
+			name:         "overall throughput drop",
+			overallAfter: &metrics{requests: 1000, p95: 100, p99: 200, errorRate: 0.001, throughput: 80},
+			want:         map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline := &Report{Overall: endpoint("", overall)}
+			r := Report{Overall: endpoint("", overall)}
+			if tt.overallAfter != nil {
+				r.Overall = endpoint("", *tt.overallAfter)
+			}
+			if tt.before.requests > 0 {
+				baseline.Endpoints = []EndpointReport{endpoint("GetProducts", tt.before)}
+			}
+			if tt.after.requests > 0 {
+				name := "GetProducts"
+				if tt.wantSkippedName {
+					name = "GetCart"
+				}
+				r.Endpoints = []EndpointReport{endpoint(name, tt.after)}
+			}
+
+			got := map[string]bool{}
+			overallPassed := map[string]bool{}
+			for _, c := range compareBaseline(baseline, r, 0.1) {
+				if c.Baseline == nil {
+					t.Errorf("%s %s: no baseline value", c.Endpoint, c.Metric)
+				}
+				if c.Endpoint == "" {
+					overallPassed[c.Metric] = c.Passed
+				} else {
+					got[c.Metric] = c.Passed
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("endpoint checks = %v, want %v", got, tt.want)
+			}
+			for metric, passed := range tt.want {
+				if got[metric] != passed {
+					t.Errorf("%s: passed = %v, want %v", metric, got[metric], passed)
+				}
+			}
+
+			wantOverall := map[string]bool{"p95": true, "error_rate": true, "p99": true, "throughput": tt.overallAfter == nil}
+			for metric, passed := range wantOverall {
+				if p, ok := overallPassed[metric]; !ok || p != passed {
+					t.Errorf("overall %s: passed = %v (checked %v), want %v", metric, p, ok, passed)
+				}
+			}
+		})
+	}
+}
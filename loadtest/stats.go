@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Result is the outcome of one measured request
+type Result struct {
+	Name    string
+	Latency time.Duration
+	// Status is the HTTP status code, or 0 if no response was received
+	Status int
+	Err    error
+}
+
+func (r Result) success() bool {
+	return r.Err == nil && r.Status >= 200 && r.Status < 300
+}
+
+// endpointStats accumulates the results for one generator or step
+type endpointStats struct {
+	requests    int
+	failures    int
+	statusCodes map[int]int
+	errors      map[string]int
+	// latency only covers requests that got a response, since a refused
+	// connection says nothing about how fast the server is
+	latency Histogram
+}
+
+func newEndpointStats() *endpointStats {
+	return &endpointStats{statusCodes: map[int]int{}, errors: map[string]int{}}
+}
+
+func (s *endpointStats) add(r Result) {
+	s.requests++
+	if !r.success() {
+		s.failures++
+	}
+	if r.Status != 0 {
+		s.statusCodes[r.Status]++
+		s.latency.Record(r.Latency)
+	}
+	if r.Err != nil {
+		s.errors[errorType(r.Err)]++
+	}
+}
+
+func (s *endpointStats) merge(other *endpointStats) {
+	s.requests += other.requests
+	s.failures += other.failures
+	for code, n := range other.statusCodes {
+		s.statusCodes[code] += n
+	}
+	for kind, n := range other.errors {
+		s.errors[kind] += n
+	}
+	s.latency.Merge(&other.latency)
+}
+
+// errorType groups transport errors into a few kinds for the report
+func errorType(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, errJourneyAborted):
+		return "aborted"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection_reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	default:
+		return "other"
+	}
+}
+
+// Recorder accumulates results from concurrent requests, per endpoint
+type Recorder struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointStats
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{endpoints: map[string]*endpointStats{}}
+}
+
+func (rec *Recorder) Record(r Result) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	s, ok := rec.endpoints[r.Name]
+	if !ok {
+		s = newEndpointStats()
+		rec.endpoints[r.Name] = s
+	}
+	s.add(r)
+}
+
+// Report summarises everything recorded over a measured period of elapsed
+func (rec *Recorder) Report(cfg Config, elapsed time.Duration) Report {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	report := Report{
+		BaseURL:     cfg.BaseURL,
+		Mode:        "closed",
+		Concurrency: cfg.Concurrency,
+		TargetRate:  cfg.Rate,
+		DurationSec: elapsed.Seconds(),
+		Endpoints:   []EndpointReport{},
+	}
+	switch {
+	case cfg.Replay != "":
+		report.Mode = "replay"
+	case cfg.Rate > 0:
+		report.Mode = "open"
+	}
+
+	overall := newEndpointStats()
+	names := make([]string, 0, len(rec.endpoints))
+	for name, s := range rec.endpoints {
+		names = append(names, name)
+		overall.merge(s)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.Endpoints = append(report.Endpoints, rec.endpoints[name].report(name, elapsed))
+	}
+	report.Overall = overall.report("overall", elapsed)
+	return report
+}
+
+func (s *endpointStats) report(name string, elapsed time.Duration) EndpointReport {
+	r := EndpointReport{
+		Name:        name,
+		Requests:    s.requests,
+		Successes:   s.requests - s.failures,
+		Failures:    s.failures,
+		StatusCodes: map[string]int{},
+		Errors:      map[string]int{},
+		Latency: LatencyReport{
+			Min:  millis(s.latency.Min()),
+			Mean: millis(s.latency.Mean()),
+			P50:  millis(s.latency.Percentile(50)),
+			P90:  millis(s.latency.Percentile(90)),
+			P95:  millis(s.latency.Percentile(95)),
+			P99:  millis(s.latency.Percentile(99)),
+			Max:  millis(s.latency.Max()),
+		},
+	}
+	if s.requests > 0 {
+		r.ErrorRate = float64(s.failures) / float64(s.requests)
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(s.requests) / elapsed.Seconds()
+	}
+	for code, n := range s.statusCodes {
+		r.StatusCodes[strconv.Itoa(code)] = n
+	}
+	for kind, n := range s.errors {
+		r.Errors[kind] = n
+	}
+	return r
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
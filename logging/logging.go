@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"sample-application/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming request IDs, which end up in every log
+// line of the request
+const maxRequestIDLength = 128
+
+type (
+	contextKey   struct{}
+	requestIDKey struct{}
+)
+
+// Init installs a slog default logger with the given level and format
+// (json or text). Output from the standard log package is routed through it
+// as well, so existing log.Printf calls become structured.
+func Init(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json", "":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want json or text)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// FromContext returns the request-scoped logger, or the default logger when
+// called outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns the request ID for the current request, if any
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// RequestIDMiddleware propagates an incoming X-Request-ID or generates a new
+// one, echoes it on the response and attaches a logger carrying it to the
+// request context. Incoming IDs longer than maxRequestIDLength or using
+// characters other than letters, digits, '.', '_' and '-' are replaced. It
+// should wrap the whole router so unmatched routes get an ID too.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, contextKey{}, slog.Default().With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog writes one log line per request with the route template, status,
+// response size, latency and the user the request acts on
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := middleware.NewResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		attrs := []any{
+			"method", r.Method,
+			"route", middleware.RouteTemplate(r),
+			"path", r.URL.Path,
+			"status", rec.Status,
+			"bytes", rec.Bytes,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if userID := userIDFromRequest(r); userID != "" {
+			attrs = append(attrs, "user_id", userID)
+		}
+
+		level := slog.LevelInfo
+		if rec.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if rec.Status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		FromContext(r.Context()).Log(r.Context(), level, "http request", attrs...)
+	})
+}
+
+// userIDFromRequest picks the user ID out of the route variables, using
+// {user_id} on cart/wishlist routes and {id} on user routes
+func userIDFromRequest(r *http.Request) string {
+	vars := mux.Vars(r)
+	if id := vars["user_id"]; id != "" {
+		return id
+	}
+	if strings.HasPrefix(middleware.RouteTemplate(r), "/api/users/") {
+		return vars["id"]
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := RequestID(r.Context()); got != w.Header().Get(RequestIDHeader) {
+			t.Errorf("context request ID %q, response header %q", got, w.Header().Get(RequestIDHeader))
+		}
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "Product not found", http.StatusNotFound)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"conflict"}`))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+
+	for _, tc := range []struct {
+		name, path, incoming string
+		keep                 bool
+		body                 string
+	}{
+		{"generated", "/", "", false, "ok"},
+		{"propagated", "/", "abc-123_x.y", true, "ok"},
+		{"too long", "/", strings.Repeat("a", maxRequestIDLength+1), false, "ok"},
+		{"longest", "/", strings.Repeat("a", maxRequestIDLength), true, "ok"},
+		{"bad characters", "/", "abc\r\nX-Injected: 1", false, "ok"},
+		{"spaces", "/", "abc def", false, "ok"},
+		{"plain error", "/missing", "req-1", true, "Product not found\n"},
+		{"json error", "/json", "req-2", true, `{"error":"conflict"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.incoming != "" {
+				req.Header.Set(RequestIDHeader, tc.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tc.keep && id != tc.incoming {
+				t.Errorf("request ID %q, want %q", id, tc.incoming)
+			}
+			if !tc.keep && (id == tc.incoming || !validRequestID(id)) {
+				t.Errorf("request ID %q, want a new valid one", id)
+			}
+			if got := rec.Body.String(); got != tc.body {
+				t.Errorf("body %q, want %q", got, tc.body)
+			}
+		})
+	}
+}
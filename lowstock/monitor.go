@@ -0,0 +1,104 @@
+package lowstock
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"sample-application/metrics"
+	"sample-application/models"
+)
+
+// Alert reports a product whose stock has fallen to or below its threshold
+type Alert struct {
+	ProductID         string    `json:"product_id"`
+	Quantity          int       `json:"quantity"`
+	LowStockThreshold int       `json:"low_stock_threshold"`
+	WarehouseLocation string    `json:"warehouse_location,omitempty"`
+	DetectedAt        time.Time `json:"detected_at"`
+}
+
+// Notifier delivers alerts to one destination
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Source lists the inventory rows currently at or below their threshold
+type Source func(ctx context.Context) ([]models.Inventory, error)
+
+// Monitor polls inventory and alerts once per product each time it falls to
+// or below its low-stock threshold. A product that recovers is re-armed, so
+// it alerts again the next time it runs low.
+type Monitor struct {
+	source    Source
+	interval  time.Duration
+	notifiers []Notifier
+
+	mu      sync.Mutex
+	alerted map[string]bool
+}
+
+func NewMonitor(source Source, interval time.Duration, notifiers ...Notifier) *Monitor {
+	return &Monitor{
+		source:    source,
+		interval:  interval,
+		notifiers: notifiers,
+		alerted:   map[string]bool{},
+	}
+}
+
+// Run checks inventory immediately and then every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("low stock check failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs a single pass, notifying about products that have newly gone low
+func (m *Monitor) Check(ctx context.Context) error {
+	items, err := m.source(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	low := make(map[string]bool, len(items))
+	var alerts []Alert
+	now := time.Now().UTC()
+	for _, item := range items {
+		low[item.ProductID] = true
+		if m.alerted[item.ProductID] {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			ProductID:         item.ProductID,
+			Quantity:          item.Quantity,
+			LowStockThreshold: item.LowStockThreshold,
+			WarehouseLocation: item.WarehouseLocation,
+			DetectedAt:        now,
+		})
+	}
+	m.alerted = low
+	m.mu.Unlock()
+
+	for _, alert := range alerts {
+		metrics.LowStockAlerts.Inc()
+		for _, n := range m.notifiers {
+			if err := n.Notify(ctx, alert); err != nil {
+				slog.Error("low stock notification failed", "product_id", alert.ProductID, "error", err)
+			}
+		}
+	}
+	return nil
+}
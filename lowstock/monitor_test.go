@@ -0,0 +1,113 @@
+package lowstock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"sample-application/models"
+)
+
+// drain returns the product IDs of the alerts waiting on n
+func drain(n *ChannelNotifier) []string {
+	var products []string
+	for {
+		select {
+		case alert := <-n.C:
+			products = append(products, alert.ProductID)
+		default:
+			return products
+		}
+	}
+}
+
+func TestMonitorCheck(t *testing.T) {
+	var low []models.Inventory
+	var sourceErr error
+	source := func(ctx context.Context) ([]models.Inventory, error) { return low, sourceErr }
+	notifier := NewChannelNotifier(10)
+	monitor := NewMonitor(source, 0, notifier)
+
+	steps := []struct {
+		name string
+		low  []string
+		want []string
+	}{
+		{"first pass alerts", []string{"p1", "p2"}, []string{"p1", "p2"}},
+		{"still low stays quiet", []string{"p1", "p2"}, nil},
+		{"newly low alerts", []string{"p1", "p2", "p3"}, []string{"p3"}},
+		{"recovered re-arms", []string{"p1"}, nil},
+		{"low again alerts again", []string{"p1", "p2"}, []string{"p2"}},
+	}
+	for _, step := range steps {
+		low = nil
+		for _, id := range step.low {
+			low = append(low, models.Inventory{ProductID: id, Quantity: 1, LowStockThreshold: 5})
+		}
+		if err := monitor.Check(context.Background()); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := drain(notifier); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: alerted %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// A failed check leaves the alerted set alone
+	sourceErr = errors.New("database down")
+	if err := monitor.Check(context.Background()); err == nil {
+		t.Errorf("Check() succeeded with a failing source")
+	}
+	sourceErr = nil
+	if err := monitor.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(notifier); got != nil {
+		t.Errorf("alerted %v after a failed check, want nothing", got)
+	}
+}
+
+func TestChannelNotifierDropsWhenFull(t *testing.T) {
+	n := NewChannelNotifier(1)
+	if err := n.Notify(context.Background(), Alert{ProductID: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), Alert{ProductID: "p2"}); err == nil {
+		t.Errorf("Notify() on a full channel succeeded")
+	}
+	if got := drain(n); !reflect.DeepEqual(got, []string{"p1"}) {
+		t.Errorf("channel held %v, want [p1]", got)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	status := http.StatusNoContent
+	var received Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(server.URL)
+	alert := Alert{ProductID: "p1", Quantity: 2, LowStockThreshold: 5}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if received != alert {
+		t.Errorf("webhook received %+v, want %+v", received, alert)
+	}
+
+	status = http.StatusBadGateway
+	if err := n.Notify(context.Background(), alert); err == nil {
+		t.Errorf("Notify() succeeded on a 502")
+	}
+}
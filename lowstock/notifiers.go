@@ -0,0 +1,74 @@
+package lowstock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// LogNotifier writes each alert to the structured log at warn level
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, alert Alert) error {
+	slog.WarnContext(ctx, "low stock",
+		"product_id", alert.ProductID,
+		"quantity", alert.Quantity,
+		"low_stock_threshold", alert.LowStockThreshold,
+	)
+	return nil
+}
+
+// WebhookNotifier POSTs each alert as JSON to a URL
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// ChannelNotifier hands alerts to in-process consumers. Alerts are dropped
+// rather than blocking the monitor when the buffer is full.
+type ChannelNotifier struct {
+	C chan Alert
+}
+
+func NewChannelNotifier(buffer int) *ChannelNotifier {
+	return &ChannelNotifier{C: make(chan Alert, buffer)}
+}
+
+func (n *ChannelNotifier) Notify(ctx context.Context, alert Alert) error {
+	select {
+	case n.C <- alert:
+		return nil
+	default:
+		return fmt.Errorf("alert channel full, dropped alert for %s", alert.ProductID)
+	}
+}
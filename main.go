@@ -1,27 +1,149 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"sample-application/capture"
 	"sample-application/config"
 	"sample-application/handlers"
+	"sample-application/idempotency"
+	"sample-application/logging"
+	"sample-application/lowstock"
+	"sample-application/metrics"
+	"sample-application/tracing"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := logging.Init(os.Stderr, cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+
+	// Subcommands such as `migrate up` run instead of the server
+	if len(args) > 0 {
+		if err := runCommand(cfg, args[0], args[1:]); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	}
+
+	// Initialize tracing before the database clients so they pick up the provider
+	shutdownTracing, err := tracing.Init(cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database connections
-	config.InitDatabases()
+	config.InitDatabases(cfg)
 	defer config.CloseDatabases()
 
-	// Create router
+	if cfg.Features.MigrateOnStart {
+		if err := migrateUp(context.Background()); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+		log.Println("Database migrations applied")
+	}
+
+	if cfg.Features.Metrics {
+		// Register database pool and business metrics
+		metrics.RegisterDBStats(config.PostgresDB, "postgres")
+		metrics.RegisterDBStats(config.MySQLDB, "mysql")
+		metrics.RegisterLowStockGauge(handlers.CountLowStockItems)
+	}
+
+	// Background workers run until shutdown
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	if cfg.Inventory.LowStockMonitor {
+		notifiers := []lowstock.Notifier{lowstock.LogNotifier{}}
+		if cfg.Inventory.LowStockWebhook != "" {
+			notifiers = append(notifiers, lowstock.NewWebhookNotifier(cfg.Inventory.LowStockWebhook))
+		}
+		go lowstock.NewMonitor(handlers.ListLowStockItems, cfg.Inventory.LowStockInterval, notifiers...).Run(workers)
+	}
+
+	// Expired idempotency keys are purged in the background; lookups ignore
+	// them regardless
+	go idempotency.NewStore(config.PostgresDB, cfg.Idempotency.TTL).RunPurger(workers, time.Hour)
+
+	server := &http.Server{
+		Addr:         ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:      logging.RequestIDMiddleware(newRouter(cfg)),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+
+	// Drain in-flight requests on SIGINT/SIGTERM so rolling updates don't drop them
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		stopWorkers()
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error during shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("Server starting on port %d", cfg.Server.Port)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
+}
+
+// newRouter registers every API route on a new router
+func newRouter(cfg *config.Config) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(tracing.Middleware)
+	if cfg.Features.AccessLog {
+		router.Use(logging.AccessLog)
+	}
+	if cfg.Features.Metrics {
+		router.Use(metrics.Middleware)
+	}
+	if cfg.Capture.Enabled {
+		recorder, err := capture.New(capture.Options{
+			Dir:           cfg.Capture.Dir,
+			Format:        cfg.Capture.Format,
+			SampleRate:    cfg.Capture.SampleRate,
+			RedactFields:  cfg.Capture.RedactFields,
+			RedactHeaders: cfg.Capture.RedactHeaders,
+			MaxBodyBytes:  cfg.Capture.MaxBodyBytes,
+			ExcludePaths:  cfg.Capture.ExcludePaths,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize traffic capture: %v", err)
+		}
+		router.Use(recorder.Middleware)
+	}
+	// Runs innermost so replayed responses are still logged and measured
+	router.Use(idempotency.Middleware(idempotency.NewStore(config.PostgresDB, cfg.Idempotency.TTL)))
 
 	// Health check
 	router.HandleFunc("/health", handlers.HealthCheck).Methods("GET")
 
+	// Prometheus metrics
+	if cfg.Features.Metrics {
+		router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	}
 	// User routes (PostgreSQL)
 	router.HandleFunc("/api/users", handlers.CreateUser).Methods("POST")
 	router.HandleFunc("/api/users", handlers.GetAllUsers).Methods("GET")
@@ -29,6 +151,7 @@ func main() {
 	router.HandleFunc("/api/users/{id}", handlers.UpdateUser).Methods("PUT")
 	router.HandleFunc("/api/users/{id}", handlers.DeleteUser).Methods("DELETE")
 	router.HandleFunc("/api/users/{id}/orders", handlers.GetUserOrders).Methods("GET")
+	router.HandleFunc("/api/users/{id}/export", handlers.ExportUser).Methods("GET")
 
 	// Product routes (MongoDB)
 	// Note: Specific routes must come before parameterized routes
@@ -39,6 +162,7 @@ func main() {
 	router.HandleFunc("/api/products/{id}", handlers.GetProductByID).Methods("GET")
 	router.HandleFunc("/api/products/{id}", handlers.UpdateProduct).Methods("PUT")
 	router.HandleFunc("/api/products/{id}", handlers.DeleteProduct).Methods("DELETE")
+	router.HandleFunc("/api/products/{id}/rating-summary", handlers.GetRatingSummary).Methods("GET")
 
 	// Order routes (PostgreSQL)
 	router.HandleFunc("/api/orders", handlers.CreateOrder).Methods("POST")
@@ -47,18 +171,41 @@ func main() {
 	router.HandleFunc("/api/orders/{id}/status", handlers.UpdateOrderStatus).Methods("PATCH")
 	router.HandleFunc("/api/orders/{id}/cancel", handlers.CancelOrder).Methods("POST")
 
+	// Warehouse routes (MySQL)
+	router.HandleFunc("/api/warehouses", handlers.CreateWarehouse).Methods("POST")
+	router.HandleFunc("/api/warehouses", handlers.GetAllWarehouses).Methods("GET")
+
 	// Inventory routes (MySQL)
 	router.HandleFunc("/api/inventory", handlers.GetAllInventory).Methods("GET")
+	// Fixed paths must be registered before /api/inventory/{product_id}, which would otherwise match them
+	router.HandleFunc("/api/inventory/low-stock", handlers.GetLowStockItems).Methods("GET")
+	router.HandleFunc("/api/inventory/reorder-suggestions", handlers.GetReorderSuggestions).Methods("GET")
+	router.HandleFunc("/api/inventory/transfers", handlers.CreateStockTransfer).Methods("POST")
+	router.HandleFunc("/api/inventory/transfers", handlers.GetStockTransfers).Methods("GET")
+	router.HandleFunc("/api/inventory/transfers/{id}/receive", handlers.ReceiveStockTransfer).Methods("POST")
+	router.HandleFunc("/api/inventory/transfers/{id}/cancel", handlers.CancelStockTransfer).Methods("POST")
 	router.HandleFunc("/api/inventory/{product_id}", handlers.GetInventoryByProduct).Methods("GET")
 	router.HandleFunc("/api/inventory/{product_id}", handlers.UpdateInventory).Methods("PUT")
 	router.HandleFunc("/api/inventory/{product_id}/restock", handlers.RestockInventory).Methods("POST")
-	router.HandleFunc("/api/inventory/low-stock", handlers.GetLowStockItems).Methods("GET")
+	router.HandleFunc("/api/inventory/{product_id}/availability", handlers.GetProductAvailability).Methods("GET")
+	router.HandleFunc("/api/inventory/{product_id}/warehouses/{warehouse_id}", handlers.SetWarehouseStock).Methods("PUT")
+	router.HandleFunc("/api/inventory/{product_id}/movements", handlers.GetStockMovements).Methods("GET")
+	router.HandleFunc("/api/inventory/{product_id}/movements", handlers.RecordStockMovement).Methods("POST")
+	router.HandleFunc("/api/inventory/{product_id}/stock", handlers.GetStockAt).Methods("GET")
 
 	// Review routes (MongoDB)
 	router.HandleFunc("/api/reviews", handlers.CreateReview).Methods("POST")
 	router.HandleFunc("/api/reviews/product/{product_id}", handlers.GetProductReviews).Methods("GET")
+	router.HandleFunc("/api/reviews/{id}", handlers.UpdateReview).Methods("PUT")
 	router.HandleFunc("/api/reviews/{id}", handlers.DeleteReview).Methods("DELETE")
+	router.HandleFunc("/api/reviews/{id}/votes", handlers.VoteOnReview).Methods("POST")
 	router.HandleFunc("/api/reviews/{id}/helpful", handlers.MarkReviewHelpful).Methods("POST")
+	router.HandleFunc("/api/reviews/{id}/report", handlers.ReportReview).Methods("POST")
+
+	// Review moderation routes (MongoDB)
+	router.HandleFunc("/api/moderation/reviews", handlers.GetModerationQueue).Methods("GET")
+	router.HandleFunc("/api/moderation/reviews/{id}/approve", handlers.ApproveReview).Methods("POST")
+	router.HandleFunc("/api/moderation/reviews/{id}/reject", handlers.RejectReview).Methods("POST")
 
 	// Category routes (MongoDB)
 	router.HandleFunc("/api/categories", handlers.CreateCategory).Methods("POST")
@@ -82,12 +229,11 @@ func main() {
 	router.HandleFunc("/api/wishlist/{user_id}", handlers.GetWishlist).Methods("GET")
 	router.HandleFunc("/api/wishlist/{user_id}/items", handlers.AddToWishlist).Methods("POST")
 	router.HandleFunc("/api/wishlist/{user_id}/items/{product_id}", handlers.RemoveFromWishlist).Methods("DELETE")
+	router.HandleFunc("/api/wishlist/{user_id}/price-drops", handlers.GetPriceDrops).Methods("GET")
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	// Admin routes (all stores)
+	router.HandleFunc("/api/admin/consistency", handlers.GetConsistencyReport).Methods("GET")
+	router.HandleFunc("/api/admin/consistency/repair", handlers.RepairConsistency).Methods("POST")
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	return router
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"sample-application/config"
+	"sample-application/keploytest"
+	"sample-application/logging"
+)
+
+// TestKeployTestSet replays keploy/test-set-0 against the router, like
+// `go run . keploy test`, with the noise and ignored tests in keploy.yml.
+// It needs the databases configured through the environment, seeded as
+// they were when the set was recorded, and is skipped when they aren't
+// configured or can't be reached.
+func TestKeployTestSet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("replays against live databases")
+	}
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		t.Skipf("databases not configured: %v", err)
+	}
+	for _, addr := range []string{
+		net.JoinHostPort(cfg.Postgres.Host, strconv.Itoa(cfg.Postgres.Port)),
+		net.JoinHostPort(cfg.MySQL.Host, strconv.Itoa(cfg.MySQL.Port)),
+		net.JoinHostPort(cfg.Mongo.Host, strconv.Itoa(cfg.Mongo.Port)),
+	} {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			t.Skipf("database not reachable: %v", err)
+		}
+		conn.Close()
+	}
+
+	config.InitDatabases(cfg)
+	defer config.CloseDatabases()
+
+	keployCfg, err := keploytest.LoadConfig(keploytest.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := keploytest.LoadTestSet("keploy/test-set-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases = keployCfg.Apply("keploy/test-set-0", cases)
+	for _, r := range keploytest.Run(logging.RequestIDMiddleware(newRouter(cfg)), cases, keploytest.Options{}) {
+		if !r.Passed() {
+			t.Error(r)
+		}
+	}
+}
+
+// TestKeployConfig checks that keploy.yml parses and only ignores tests
+// that exist, so a renamed capture can't silently stop being skipped
+func TestKeployConfig(t *testing.T) {
+	keployCfg, err := keploytest.LoadConfig(keploytest.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for set, names := range keployCfg.IgnoredTests {
+		cases, err := keploytest.LoadTestSet(filepath.Join("keploy", set))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded := map[string]bool{}
+		for _, c := range cases {
+			recorded[c.Name] = true
+		}
+		for _, name := range names {
+			if !recorded[name] {
+				t.Errorf("keploy.yml ignores %s/%s, which isn't recorded", set, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sample-application/middleware"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// HTTP metrics, labelled by mux route template rather than raw path so
+	// IDs in the URL don't blow up cardinality
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route template and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// MongoDB command latency, fed by the driver's command monitor
+	MongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongodb_command_duration_seconds",
+		Help:    "MongoDB command latency by command name and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command", "outcome"})
+
+	// Business metrics
+	OrdersCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "orders_created_total",
+		Help: "Total orders successfully created.",
+	})
+
+	CheckoutFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "checkout_failures_total",
+		Help: "Total order creations that failed after the request was accepted.",
+	})
+
+	LowStockAlerts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "inventory_low_stock_alerts_total",
+		Help: "Total low-stock alerts raised by the background monitor.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HTTPRequests,
+		HTTPDuration,
+		MongoCommandDuration,
+		OrdersCreated,
+		CheckoutFailures,
+		LowStockAlerts,
+	)
+}
+
+// Handler serves the default registry in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDBStats exposes sql.DB.Stats() for a connection pool
+func RegisterDBStats(db *sql.DB, name string) {
+	if db == nil {
+		return
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// lowStockScrapeTimeout bounds the query behind the low-stock gauge, so a
+// slow MySQL can't stall every scrape
+const lowStockScrapeTimeout = 2 * time.Second
+
+// RegisterLowStockGauge exposes the number of low-stock items, computed at
+// scrape time by the given function. It reports -1 if counting fails or
+// takes longer than lowStockScrapeTimeout.
+func RegisterLowStockGauge(count func(ctx context.Context) (int, error)) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "inventory_low_stock_items",
+		Help: "Number of inventory rows at or below their low stock threshold.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), lowStockScrapeTimeout)
+		defer cancel()
+		n, err := count(ctx)
+		if err != nil {
+			log.Printf("Error counting low stock items: %v", err)
+			return -1
+		}
+		return float64(n)
+	}))
+}
+
+// Middleware records request count and latency per route template
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := middleware.NewResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		route := middleware.RouteTemplate(r)
+		status := strconv.Itoa(rec.Status)
+		HTTPRequests.WithLabelValues(r.Method, route, status).Inc()
+		HTTPDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//go:embed postgres/*.sql mysql/*.sql
+var files embed.FS
+
+// Migration is a single versioned schema change with its inverse
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied to a store
+type Status struct {
+	Store     string
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator applies versioned migrations to one data store
+type Migrator interface {
+	Store() string
+	Up(ctx context.Context) error
+	Down(ctx context.Context, steps int) error
+	Status(ctx context.Context) ([]Status, error)
+}
+
+// loadSQL reads <dir>/NNNN_name.up.sql and NNNN_name.down.sql pairs from the
+// embedded filesystem, ordered by version
+func loadSQL(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s/%s: expected NNNN_name", dir, name)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s/%s: invalid version: %w", dir, name, err)
+		}
+
+		body, err := fs.ReadFile(files, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s/%04d_%s has no up script", dir, m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits a script into statements on semicolons that end a
+// line. Migrations don't define procedures, so this is sufficient and avoids
+// relying on the MySQL driver's multiStatements mode.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSpace(current.String()); stmt != ";" {
+				statements = append(statements, strings.TrimSuffix(stmt, ";"))
+			}
+			current.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+// RunCommand implements `migrate up|down [steps]|status` across all stores
+func RunCommand(ctx context.Context, args []string, migrators []Migrator, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		for _, m := range migrators {
+			if err := m.Up(ctx); err != nil {
+				return fmt.Errorf("%s: %w", m.Store(), err)
+			}
+			fmt.Fprintf(out, "%s: up to date\n", m.Store())
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		// Roll back in reverse store order
+		for i := len(migrators) - 1; i >= 0; i-- {
+			if err := migrators[i].Down(ctx, steps); err != nil {
+				return fmt.Errorf("%s: %w", migrators[i].Store(), err)
+			}
+			fmt.Fprintf(out, "%s: rolled back up to %d migration(s)\n", migrators[i].Store(), steps)
+		}
+	case "status":
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "STORE\tVERSION\tNAME\tAPPLIED AT")
+		for _, m := range migrators {
+			statuses, err := m.Status(ctx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", m.Store(), err)
+			}
+			for _, s := range statuses {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%s\t%04d\t%s\t%s\n", s.Store, s.Version, s.Name, applied)
+			}
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or status)", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{name: "empty", script: "", want: nil},
+		{name: "one", script: "CREATE TABLE t (id INT);\n", want: []string{"CREATE TABLE t (id INT)"}},
+		{
+			name:   "comments and blank lines",
+			script: "-- first\nCREATE TABLE a (id INT);\n\n-- second\nCREATE TABLE b (id INT);\n",
+			want:   []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:   "multi-line",
+			script: "SET @stmt = IF(x,\n\t'DO 0', 'DROP INDEX i ON t');\nPREPARE stmt FROM @stmt;\n",
+			want:   []string{"SET @stmt = IF(x,\n\t'DO 0', 'DROP INDEX i ON t')", "PREPARE stmt FROM @stmt"},
+		},
+		{name: "no trailing semicolon", script: "SELECT 1", want: []string{"SELECT 1"}},
+		{name: "stray semicolon", script: "SELECT 1;\n;\n", want: []string{"SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMySQLStatements checks that the MySQL migrations, which run outside a
+// transaction one statement at a time, split into single statements
+func TestMySQLStatements(t *testing.T) {
+	migrations, err := loadSQL("mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		for _, script := range []string{m.Up, m.Down} {
+			for _, stmt := range splitStatements(script) {
+				if strings.Contains(stmt, ";") {
+					t.Errorf("%04d_%s: statement contains a semicolon:\n%s", m.Version, m.Name, stmt)
+				}
+			}
+		}
+	}
+}
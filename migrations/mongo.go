@@ -0,0 +1,501 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoMigration is a versioned change to MongoDB collections, typically
+// index definitions
+type MongoMigration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+var mongoMigrations = []MongoMigration{
+	{
+		Version: 1,
+		Name:    "initial_indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := createIndex(ctx, db, "products", "products_category", bson.D{{Key: "category", Value: 1}}, false); err != nil {
+				return err
+			}
+			if err := createIndex(ctx, db, "reviews", "reviews_product_id", bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}}, false); err != nil {
+				return err
+			}
+			return createIndex(ctx, db, "wishlist", "wishlist_user_id", bson.D{{Key: "user_id", Value: 1}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, map[string]string{
+				"products": "products_category",
+				"reviews":  "reviews_product_id",
+				"wishlist": "wishlist_user_id",
+			})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "document_versions",
+		// Start every existing product and category at version 1 so
+		// If-Match comparisons have something to compare against
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, collection := range []string{"products", "categories"} {
+				_, err := db.Collection(collection).UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, collection := range []string{"products", "categories"} {
+				if _, err := db.Collection(collection).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"version": ""}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "reviews_one_per_user",
+		// Keep each user's latest review of a product before enforcing one
+		// review per (user, product). The others are moved to
+		// reviews_dedup_backup, which Down restores them from. Run `ratings
+		// repair` afterwards in either direction.
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := dedupe(ctx, db, "reviews", "created_at", -1); err != nil {
+				return err
+			}
+			return createIndex(ctx, db, "reviews", "reviews_user_product", bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}}, true)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(ctx, db, map[string]string{"reviews": "reviews_user_product"}); err != nil {
+				return err
+			}
+			return restoreDeduped(ctx, db, "reviews")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "review_votes",
+		// Existing helpful counts become up votes that nobody can retract,
+		// and every review gets the helpful_score that votes keep up to date
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("reviews").UpdateMany(ctx, bson.M{}, mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{
+					"helpful":     bson.M{"$ifNull": bson.A{"$helpful", 0}},
+					"not_helpful": bson.M{"$ifNull": bson.A{"$not_helpful", 0}},
+				}}},
+				{{Key: "$set", Value: bson.M{"helpful_score": wilsonLowerBound("$helpful", "$not_helpful")}}},
+			})
+			if err != nil {
+				return err
+			}
+			return createIndex(ctx, db, "review_votes", "review_votes_review_user", bson.D{{Key: "review_id", Value: 1}, {Key: "user_id", Value: 1}}, true)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, map[string]string{"review_votes": "review_votes_review_user"})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "review_moderation",
+		// Reviews published before moderation existed stay published
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("reviews").UpdateMany(ctx, bson.M{"status": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"status": "approved", "report_count": 0}})
+			if err != nil {
+				return err
+			}
+			if err := createIndex(ctx, db, "reviews", "reviews_status_created_at", bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}, false); err != nil {
+				return err
+			}
+			return createIndex(ctx, db, "review_reports", "review_reports_review_user", bson.D{{Key: "review_id", Value: 1}, {Key: "user_id", Value: 1}}, true)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, map[string]string{
+				"reviews":        "reviews_status_created_at",
+				"review_reports": "review_reports_review_user",
+			})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "wishlist_unique_and_prices",
+		// Keep the earliest of any duplicate wishlist entries, moving the
+		// others to wishlist_dedup_backup for Down to restore, and record
+		// today's price as the baseline for items added before prices were
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			wishlist := db.Collection("wishlist")
+			if err := dedupe(ctx, db, "wishlist", "added_at", 1); err != nil {
+				return err
+			}
+
+			missing := bson.M{"price_at_add": bson.M{"$exists": false}}
+			productIDs, err := wishlist.Distinct(ctx, "product_id", missing)
+			if err != nil {
+				return err
+			}
+			for _, id := range productIDs {
+				productID, _ := id.(string)
+				oid, err := primitive.ObjectIDFromHex(productID)
+				if err != nil {
+					continue
+				}
+				var product struct {
+					Price float64 `bson:"price"`
+				}
+				err = db.Collection("products").FindOne(ctx, bson.M{"_id": oid}).Decode(&product)
+				if err == mongo.ErrNoDocuments {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				_, err = wishlist.UpdateMany(ctx, bson.M{"product_id": productID, "price_at_add": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"price_at_add": product.Price}})
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := createIndex(ctx, db, "wishlist", "wishlist_user_product", bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}}, true); err != nil {
+				return err
+			}
+			if err := createIndex(ctx, db, "wishlist", "wishlist_product_id", bson.D{{Key: "product_id", Value: 1}}, false); err != nil {
+				return err
+			}
+			return createIndex(ctx, db, "price_drop_events", "price_drop_events_user_created_at", bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{"wishlist_user_product", "wishlist_product_id"} {
+				if err := dropIndexes(ctx, db, map[string]string{"wishlist": name}); err != nil {
+					return err
+				}
+			}
+			if err := dropIndexes(ctx, db, map[string]string{"price_drop_events": "price_drop_events_user_created_at"}); err != nil {
+				return err
+			}
+			return restoreDeduped(ctx, db, "wishlist")
+		},
+	},
+}
+
+const (
+	mongoMigrationsCollection = "schema_migrations"
+	mongoLockID               = "migration_lock"
+	// A lock older than this is assumed to belong to a crashed replica
+	mongoLockStaleAfter = 5 * time.Minute
+	// The holder refreshes the lock this often, so a long migration never
+	// looks stale
+	mongoLockRefreshInterval = time.Minute
+	// dedupBackupSuffix names the collection duplicates are moved to
+	dedupBackupSuffix = "_dedup_backup"
+)
+
+// errMongoLockLost cancels a migration whose lock was taken over
+var errMongoLockLost = errors.New("lost the migration lock")
+
+// MongoMigrator applies mongoMigrations, recording applied versions in the
+// schema_migrations collection
+type MongoMigrator struct {
+	db         *mongo.Database
+	migrations []MongoMigration
+}
+
+func NewMongo(db *mongo.Database) *MongoMigrator {
+	return &MongoMigrator{db: db, migrations: mongoMigrations}
+}
+
+func (m *MongoMigrator) Store() string {
+	return "mongodb"
+}
+
+func (m *MongoMigrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		collection := m.db.Collection(mongoMigrationsCollection)
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := migration.Up(ctx, m.db); err != nil {
+				return fmt.Errorf("applying %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err := collection.InsertOne(ctx, bson.M{"_id": migration.Version, "name": migration.Name, "applied_at": time.Now()})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *MongoMigrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		collection := m.db.Collection(mongoMigrationsCollection)
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := migration.Down(ctx, m.db); err != nil {
+				return fmt.Errorf("rolling back %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func (m *MongoMigrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Store: m.Store(), Version: migration.Version, Name: migration.Name}
+		if at, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (m *MongoMigrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	cursor, err := m.db.Collection(mongoMigrationsCollection).Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []struct {
+		Version   int       `bson:"_id"`
+		AppliedAt time.Time `bson:"applied_at"`
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// withLock takes a lock document in schema_migrations so only one replica
+// migrates at a time. Stale locks from crashed replicas are taken over. The
+// lock records a token for its holder, which refreshes it while fn runs and
+// only deletes it if it still holds it. If the lock is lost anyway, fn's
+// context is cancelled.
+func (m *MongoMigrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	collection := m.db.Collection(mongoMigrationsCollection)
+	owner := primitive.NewObjectID().Hex()
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		now := time.Now()
+		filter := bson.M{"_id": mongoLockID, "locked_at": bson.M{"$lt": now.Add(-mongoLockStaleAfter)}}
+		update := bson.M{"$set": bson.M{"locked_at": now, "owner": owner}}
+		_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err == nil {
+			break
+		}
+		// A duplicate key on upsert means another replica holds a fresh lock
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		if now.After(deadline) {
+			return errors.New("timed out waiting for migration lock")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	held := bson.M{"_id": mongoLockID, "owner": owner}
+	defer collection.DeleteOne(context.Background(), held)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(mongoLockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			result, err := collection.UpdateOne(ctx, held, bson.M{"$set": bson.M{"locked_at": time.Now()}})
+			switch {
+			case err != nil:
+				log.Printf("mongodb: refreshing migration lock: %v", err)
+			case result.MatchedCount == 0:
+				cancel(errMongoLockLost)
+				return
+			}
+		}
+	}()
+
+	err := fn(ctx)
+	if err != nil && context.Cause(ctx) == errMongoLockLost {
+		return fmt.Errorf("%w: %w", errMongoLockLost, err)
+	}
+	return err
+}
+
+// wilsonLowerBound is the lower bound of the 95% Wilson score interval for
+// the share of up votes, rounded to four decimals, or 0 without votes. It
+// repeats the handlers' helpfulScoreExpr rather than importing it, so the
+// migration keeps computing what it did when it was written.
+func wilsonLowerBound(up, down string) bson.M {
+	const z = 1.96
+	n := bson.M{"$add": bson.A{up, down}}
+	return bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{n, 0}},
+		0,
+		bson.M{"$let": bson.M{
+			"vars": bson.M{"n": n, "p": bson.M{"$divide": bson.A{up, n}}},
+			// (p + z²/2n - z·√((p(1-p) + z²/4n) / n)) / (1 + z²/n)
+			"in": bson.M{"$round": bson.A{bson.M{"$divide": bson.A{
+				bson.M{"$subtract": bson.A{
+					bson.M{"$add": bson.A{"$$p", bson.M{"$divide": bson.A{z * z / 2, "$$n"}}}},
+					bson.M{"$multiply": bson.A{z, bson.M{"$sqrt": bson.M{"$divide": bson.A{
+						bson.M{"$add": bson.A{
+							bson.M{"$multiply": bson.A{"$$p", bson.M{"$subtract": bson.A{1, "$$p"}}}},
+							bson.M{"$divide": bson.A{z * z / 4, "$$n"}},
+						}},
+						"$$n",
+					}}}}},
+				}},
+				bson.M{"$add": bson.A{1, bson.M{"$divide": bson.A{z * z, "$$n"}}}},
+			}}, 4}},
+		}},
+	}}
+}
+
+// dedupe keeps one document per (user_id, product_id) in a collection, the
+// first in sortField order, and moves the others to
+// <collection>_dedup_backup. Copying before deleting lets an interrupted run
+// be repeated without losing documents.
+func dedupe(ctx context.Context, db *mongo.Database, collection, sortField string, order int) error {
+	source := db.Collection(collection)
+	backup := db.Collection(collection + dedupBackupSuffix)
+	cursor, err := source.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: sortField, Value: order}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"user_id": "$user_id", "product_id": "$product_id"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	moved := 0
+	for cursor.Next(ctx) {
+		var group struct {
+			IDs bson.A `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		extra := bson.M{"_id": bson.M{"$in": group.IDs[1:]}}
+		var docs []interface{}
+		found, err := source.Find(ctx, extra)
+		if err != nil {
+			return err
+		}
+		if err := found.All(ctx, &docs); err != nil {
+			return err
+		}
+		// Documents already copied by an earlier, interrupted run are
+		// duplicates here and can be skipped
+		_, err = backup.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if _, err := source.DeleteMany(ctx, extra); err != nil {
+			return err
+		}
+		moved += len(docs)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if moved > 0 {
+		log.Printf("Moved %d duplicate %s documents to %s%s", moved, collection, collection, dedupBackupSuffix)
+	}
+	return nil
+}
+
+// restoreDeduped moves the documents dedupe set aside back into the
+// collection and drops the backup. Documents whose _id is back already are
+// skipped.
+func restoreDeduped(ctx context.Context, db *mongo.Database, collection string) error {
+	backup := db.Collection(collection + dedupBackupSuffix)
+	var docs []interface{}
+	cursor, err := backup.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	if len(docs) > 0 {
+		_, err := db.Collection(collection).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return backup.Drop(ctx)
+}
+
+func createIndex(ctx context.Context, db *mongo.Database, collection, name string, keys bson.D, unique bool) error {
+	model := mongo.IndexModel{Keys: keys, Options: options.Index().SetName(name).SetUnique(unique)}
+	_, err := db.Collection(collection).Indexes().CreateOne(ctx, model)
+	return err
+}
+
+func dropIndexes(ctx context.Context, db *mongo.Database, indexes map[string]string) error {
+	for collection, name := range indexes {
+		if _, err := db.Collection(collection).Indexes().DropOne(ctx, name); err != nil {
+			// Dropping an index that is already gone is not an error
+			var cmdErr mongo.CommandError
+			if errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound" {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sales_analytics;
+DROP TABLE IF EXISTS inventory;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS inventory (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	product_id VARCHAR(100) UNIQUE NOT NULL,
+	quantity INT NOT NULL DEFAULT 0,
+	warehouse_location VARCHAR(255),
+	last_restocked TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	low_stock_threshold INT DEFAULT 10,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sales_analytics (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	product_id VARCHAR(100) NOT NULL,
+	quantity_sold INT NOT NULL,
+	revenue DECIMAL(10, 2) NOT NULL,
+	sale_date DATE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
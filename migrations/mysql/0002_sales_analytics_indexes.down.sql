@@ -0,0 +1,13 @@
+SET @stmt = IF(EXISTS(SELECT 1 FROM information_schema.statistics
+	WHERE table_schema = DATABASE() AND table_name = 'sales_analytics' AND index_name = 'sales_analytics_product_id_idx'),
+	'DROP INDEX sales_analytics_product_id_idx ON sales_analytics', 'DO 0');
+PREPARE stmt FROM @stmt;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @stmt = IF(EXISTS(SELECT 1 FROM information_schema.statistics
+	WHERE table_schema = DATABASE() AND table_name = 'sales_analytics' AND index_name = 'sales_analytics_sale_date_idx'),
+	'DROP INDEX sales_analytics_sale_date_idx ON sales_analytics', 'DO 0');
+PREPARE stmt FROM @stmt;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
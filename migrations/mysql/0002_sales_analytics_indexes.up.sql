@@ -0,0 +1,16 @@
+-- MySQL commits DDL implicitly, so a failed migration is retried rather
+-- than rolled back. MySQL 8.0 has no CREATE INDEX IF NOT EXISTS, so each
+-- index is only created when information_schema doesn't list it yet.
+SET @stmt = IF(EXISTS(SELECT 1 FROM information_schema.statistics
+	WHERE table_schema = DATABASE() AND table_name = 'sales_analytics' AND index_name = 'sales_analytics_sale_date_idx'),
+	'DO 0', 'CREATE INDEX sales_analytics_sale_date_idx ON sales_analytics (sale_date)');
+PREPARE stmt FROM @stmt;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @stmt = IF(EXISTS(SELECT 1 FROM information_schema.statistics
+	WHERE table_schema = DATABASE() AND table_name = 'sales_analytics' AND index_name = 'sales_analytics_product_id_idx'),
+	'DO 0', 'CREATE INDEX sales_analytics_product_id_idx ON sales_analytics (product_id)');
+PREPARE stmt FROM @stmt;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
@@ -0,0 +1,59 @@
+CREATE TABLE IF NOT EXISTS warehouses (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	code VARCHAR(50) NOT NULL UNIQUE,
+	name VARCHAR(255) NOT NULL,
+	address TEXT,
+	city VARCHAR(100),
+	state VARCHAR(100),
+	country VARCHAR(100),
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_stock (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	product_id VARCHAR(100) NOT NULL,
+	warehouse_id INT NOT NULL,
+	quantity INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	UNIQUE KEY warehouse_stock_product_warehouse (product_id, warehouse_id),
+	KEY warehouse_stock_warehouse_id_idx (warehouse_id),
+	CONSTRAINT warehouse_stock_warehouse_fk FOREIGN KEY (warehouse_id) REFERENCES warehouses (id)
+);
+
+CREATE TABLE IF NOT EXISTS stock_transfers (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	product_id VARCHAR(100) NOT NULL,
+	from_warehouse_id INT NOT NULL,
+	to_warehouse_id INT NOT NULL,
+	quantity INT NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'in_transit',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	completed_at TIMESTAMP NULL,
+	KEY stock_transfers_product_status_idx (product_id, status),
+	CONSTRAINT stock_transfers_from_fk FOREIGN KEY (from_warehouse_id) REFERENCES warehouses (id),
+	CONSTRAINT stock_transfers_to_fk FOREIGN KEY (to_warehouse_id) REFERENCES warehouses (id)
+);
+
+-- Turn each distinct free-text warehouse_location into a warehouse, with a
+-- catch-all for rows that never had one. Locations differing only in case or
+-- spacing share a code, so they become one warehouse named after the first.
+-- Every statement can be re-run after a partial failure.
+INSERT IGNORE INTO warehouses (code, name)
+SELECT code, MIN(name)
+FROM (
+	SELECT LEFT(UPPER(REPLACE(TRIM(warehouse_location), ' ', '-')), 50) AS code, TRIM(warehouse_location) AS name
+	FROM inventory
+	WHERE warehouse_location IS NOT NULL AND TRIM(warehouse_location) <> ''
+) locations
+GROUP BY code;
+
+INSERT IGNORE INTO warehouses (code, name) VALUES ('DEFAULT', 'Default warehouse');
+
+INSERT IGNORE INTO warehouse_stock (product_id, warehouse_id, quantity)
+SELECT i.product_id, COALESCE(w.id, d.id), i.quantity
+FROM inventory i
+JOIN warehouses d ON d.code = 'DEFAULT'
+LEFT JOIN warehouses w ON w.code = LEFT(UPPER(REPLACE(TRIM(i.warehouse_location), ' ', '-')), 50);
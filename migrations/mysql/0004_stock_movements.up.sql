@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS stock_movements (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	product_id VARCHAR(100) NOT NULL,
+	warehouse_id INT NOT NULL,
+	movement_type VARCHAR(20) NOT NULL,
+	quantity INT NOT NULL,
+	balance_after INT NOT NULL,
+	reason VARCHAR(255),
+	actor VARCHAR(100) NOT NULL,
+	reference_id VARCHAR(100),
+	created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	KEY stock_movements_product_created_idx (product_id, created_at),
+	KEY stock_movements_reference_idx (reference_id),
+	CONSTRAINT stock_movements_warehouse_fk FOREIGN KEY (warehouse_id) REFERENCES warehouses (id)
+);
+
+-- Open the ledger with the stock each warehouse already holds, so balances
+-- derived from it agree with warehouse_stock. Stock that already has an
+-- opening balance is skipped, so a retry after a failure doesn't open it
+-- twice.
+INSERT INTO stock_movements (product_id, warehouse_id, movement_type, quantity, balance_after, reason, actor)
+SELECT ws.product_id, ws.warehouse_id, 'adjustment', ws.quantity, ws.quantity, 'opening balance', 'migration'
+FROM warehouse_stock ws
+WHERE ws.quantity <> 0
+	AND NOT EXISTS (
+		SELECT 1 FROM stock_movements m
+		WHERE m.product_id = ws.product_id AND m.warehouse_id = ws.warehouse_id
+			AND m.actor = 'migration' AND m.reason = 'opening balance'
+	);
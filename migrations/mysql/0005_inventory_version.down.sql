@@ -0,0 +1,6 @@
+SET @stmt = IF(EXISTS(SELECT 1 FROM information_schema.columns
+	WHERE table_schema = DATABASE() AND table_name = 'inventory' AND column_name = 'version'),
+	'ALTER TABLE inventory DROP COLUMN version', 'DO 0');
+PREPARE stmt FROM @stmt;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
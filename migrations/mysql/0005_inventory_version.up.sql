@@ -0,0 +1,8 @@
+-- Version counter for optimistic concurrency (ETag / If-Match). MySQL 8.0
+-- has no ADD COLUMN IF NOT EXISTS, so a retry checks information_schema.
+SET @stmt = IF(EXISTS(SELECT 1 FROM information_schema.columns
+	WHERE table_schema = DATABASE() AND table_name = 'inventory' AND column_name = 'version'),
+	'DO 0', 'ALTER TABLE inventory ADD COLUMN version INT NOT NULL DEFAULT 1');
+PREPARE stmt FROM @stmt;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	address TEXT,
+	phone VARCHAR(50),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id),
+	total_amount DECIMAL(10, 2) NOT NULL,
+	status VARCHAR(50) DEFAULT 'pending',
+	payment_method VARCHAR(50),
+	shipping_address TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+	id SERIAL PRIMARY KEY,
+	order_id INTEGER REFERENCES orders(id),
+	product_id VARCHAR(100) NOT NULL,
+	quantity INTEGER NOT NULL,
+	price DECIMAL(10, 2) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS cart (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id),
+	product_id VARCHAR(100) NOT NULL,
+	quantity INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
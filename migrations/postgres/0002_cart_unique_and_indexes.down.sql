@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS order_items_order_id_idx;
+DROP INDEX IF EXISTS orders_user_id_idx;
+DROP INDEX IF EXISTS cart_user_product_key;
@@ -0,0 +1,17 @@
+-- Merge duplicate cart rows left behind by the old insert fallback before
+-- adding the unique key that AddToCart's ON CONFLICT relies on
+UPDATE cart SET quantity = d.total
+FROM (
+	SELECT MIN(id) AS keep_id, SUM(quantity) AS total
+	FROM cart
+	GROUP BY user_id, product_id
+	HAVING COUNT(*) > 1
+) d
+WHERE cart.id = d.keep_id;
+
+DELETE FROM cart c USING cart k
+WHERE c.user_id = k.user_id AND c.product_id = k.product_id AND c.id > k.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS cart_user_product_key ON cart (user_id, product_id);
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+CREATE INDEX IF NOT EXISTS order_items_order_id_idx ON order_items (order_id);
@@ -0,0 +1,13 @@
+-- Responses to POST requests sent with an Idempotency-Key, replayed on retry.
+-- status_code is NULL while the first request is still being processed.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key VARCHAR(255) PRIMARY KEY,
+	request_hash CHAR(64) NOT NULL,
+	status_code INTEGER,
+	response_headers JSONB,
+	response_body BYTEA,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
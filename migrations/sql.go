@@ -0,0 +1,228 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// Arbitrary key shared by all replicas for pg_advisory_lock
+	postgresLockKey = 7263540012
+	mysqlLockName   = "ecommerce_schema_migrations"
+	lockTimeout     = 60 * time.Second
+)
+
+type dialect struct {
+	store string
+	dir   string
+	// transactional is true when DDL can be rolled back (Postgres), so each
+	// migration and its schema_migrations row commit together
+	transactional bool
+	lock          func(ctx context.Context, conn *sql.Conn) error
+	unlock        func(ctx context.Context, conn *sql.Conn) error
+	placeholder   func(n int) string
+}
+
+var postgresDialect = dialect{
+	store:         "postgres",
+	dir:           "postgres",
+	transactional: true,
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+		defer cancel()
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgresLockKey)
+		return err
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, postgresLockKey)
+		return err
+	},
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+}
+
+var mysqlDialect = dialect{
+	store: "mysql",
+	dir:   "mysql",
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		var acquired sql.NullInt64
+		err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, mysqlLockName, int(lockTimeout.Seconds())).Scan(&acquired)
+		if err != nil {
+			return err
+		}
+		if acquired.Int64 != 1 {
+			return fmt.Errorf("timed out waiting for migration lock")
+		}
+		return nil
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, mysqlLockName)
+		return err
+	},
+	placeholder: func(int) string { return "?" },
+}
+
+// SQLMigrator applies the embedded migrations for one SQL database. A
+// database-level lock is held for the duration so replicas starting at the
+// same time apply each migration exactly once.
+type SQLMigrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+func NewPostgres(db *sql.DB) (*SQLMigrator, error) {
+	return newSQLMigrator(db, postgresDialect)
+}
+
+func NewMySQL(db *sql.DB) (*SQLMigrator, error) {
+	return newSQLMigrator(db, mysqlDialect)
+}
+
+func newSQLMigrator(db *sql.DB, d dialect) (*SQLMigrator, error) {
+	migrations, err := loadSQL(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLMigrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+func (m *SQLMigrator) Store() string {
+	return m.dialect.store
+}
+
+func (m *SQLMigrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			insert := fmt.Sprintf(`INSERT INTO schema_migrations (version, name) VALUES (%s, %s)`,
+				m.dialect.placeholder(1), m.dialect.placeholder(2))
+			if err := m.run(ctx, conn, migration.Up, insert, migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("applying %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *SQLMigrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			remove := fmt.Sprintf(`DELETE FROM schema_migrations WHERE version = %s`, m.dialect.placeholder(1))
+			if err := m.run(ctx, conn, migration.Down, remove, migration.Version); err != nil {
+				return fmt.Errorf("rolling back %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func (m *SQLMigrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			status := Status{Store: m.dialect.store, Version: migration.Version, Name: migration.Name}
+			if at, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &at
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// run executes a migration script followed by the bookkeeping statement,
+// inside a transaction where the dialect supports transactional DDL
+func (m *SQLMigrator) run(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	if !m.dialect.transactional {
+		for _, stmt := range splitStatements(script) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		_, err := conn.ExecContext(ctx, bookkeeping, args...)
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *SQLMigrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// withLock runs fn on a dedicated connection holding the migration lock,
+// since both advisory locks are scoped to the session that took them
+func (m *SQLMigrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.dialect.lock(ctx, conn); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer m.dialect.unlock(context.Background(), conn)
+
+	return fn(conn)
+}
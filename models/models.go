@@ -12,6 +12,7 @@ type User struct {
 	Password  string    `json:"password,omitempty"`
 	Address   string    `json:"address"`
 	Phone     string    `json:"phone"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -25,13 +26,16 @@ type Product struct {
 	Category    string    `json:"category" bson:"category"`
 	Brand       string    `json:"brand" bson:"brand"`
 	ImageURL    string    `json:"image_url" bson:"image_url"`
-	Rating      float64   `json:"rating" bson:"rating"`
+	Rating      float64   `json:"rating" bson:"rating,omitempty"`
+	ReviewCount int       `json:"review_count" bson:"review_count,omitempty"`
 	Tags        []string  `json:"tags" bson:"tags"`
+	Version     int       `json:"version" bson:"version,omitempty"`
 	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
 }
 
-// Order represents an order (PostgreSQL)
+// Order represents an order (PostgreSQL). UserID is 0 once the customer
+// has been deleted.
 type Order struct {
 	ID              int         `json:"id"`
 	UserID          int         `json:"user_id"`
@@ -39,6 +43,8 @@ type Order struct {
 	Status          string      `json:"status"`
 	PaymentMethod   string      `json:"payment_method"`
 	ShippingAddress string      `json:"shipping_address"`
+	WarehouseID     *int        `json:"warehouse_id,omitempty"`
+	Version         int         `json:"version"`
 	CreatedAt       time.Time   `json:"created_at"`
 	UpdatedAt       time.Time   `json:"updated_at"`
 	Items           []OrderItem `json:"items,omitempty"`
@@ -61,19 +67,128 @@ type Inventory struct {
 	WarehouseLocation string    `json:"warehouse_location"`
 	LastRestocked     time.Time `json:"last_restocked"`
 	LowStockThreshold int       `json:"low_stock_threshold"`
+	Version           int       `json:"version"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
 
-// Review represents a product review (MongoDB)
+// ReorderSuggestion recommends restocking a product based on its recent
+// sales velocity
+type ReorderSuggestion struct {
+	ProductID         string  `json:"product_id"`
+	OnHand            int     `json:"on_hand"`
+	InTransit         int     `json:"in_transit"`
+	LowStockThreshold int     `json:"low_stock_threshold"`
+	DailyVelocity     float64 `json:"daily_velocity"`
+	ReorderPoint      int     `json:"reorder_point"`
+	SuggestedQuantity int     `json:"suggested_quantity"`
+}
+
+// Warehouse represents a stock location (MySQL)
+type Warehouse struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	City      string    `json:"city"`
+	State     string    `json:"state"`
+	Country   string    `json:"country"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WarehouseStock represents on-hand stock of a product at one warehouse (MySQL)
+type WarehouseStock struct {
+	ProductID     string    `json:"product_id"`
+	WarehouseID   int       `json:"warehouse_id"`
+	WarehouseCode string    `json:"warehouse_code"`
+	Quantity      int       `json:"quantity"`
+	InboundQty    int       `json:"inbound_quantity"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// StockTransfer represents stock moving between warehouses (MySQL)
+type StockTransfer struct {
+	ID              int        `json:"id"`
+	ProductID       string     `json:"product_id"`
+	FromWarehouseID int        `json:"from_warehouse_id"`
+	ToWarehouseID   int        `json:"to_warehouse_id"`
+	Quantity        int        `json:"quantity"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"created_at"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+// ProductAvailability aggregates a product's stock across warehouses
+type ProductAvailability struct {
+	ProductID  string           `json:"product_id"`
+	OnHand     int              `json:"on_hand"`
+	InTransit  int              `json:"in_transit"`
+	Warehouses []WarehouseStock `json:"warehouses"`
+}
+
+// StockMovement is one append-only entry in the inventory ledger (MySQL).
+// Quantity is the signed change; BalanceAfter is the warehouse's on-hand
+// quantity once the movement was applied.
+type StockMovement struct {
+	ID           int64     `json:"id"`
+	ProductID    string    `json:"product_id"`
+	WarehouseID  int       `json:"warehouse_id"`
+	Type         string    `json:"type"`
+	Quantity     int       `json:"quantity"`
+	BalanceAfter int       `json:"balance_after"`
+	Reason       string    `json:"reason,omitempty"`
+	Actor        string    `json:"actor"`
+	ReferenceID  string    `json:"reference_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// StockSnapshot is a product's stock as of a point in time, derived from the
+// inventory ledger
+type StockSnapshot struct {
+	ProductID  string              `json:"product_id"`
+	At         time.Time           `json:"at"`
+	Quantity   int                 `json:"quantity"`
+	Warehouses []WarehouseQuantity `json:"warehouses"`
+}
+
+// WarehouseQuantity is the quantity of a product held at one warehouse
+type WarehouseQuantity struct {
+	WarehouseID int `json:"warehouse_id"`
+	Quantity    int `json:"quantity"`
+}
+
+// Review represents a product review (MongoDB). Status is pending,
+// approved, rejected or flagged; only approved reviews are listed and
+// counted in the product's rating.
 type Review struct {
-	ID        string    `json:"id" bson:"_id,omitempty"`
-	ProductID string    `json:"product_id" bson:"product_id"`
-	UserID    int       `json:"user_id" bson:"user_id"`
-	Rating    int       `json:"rating" bson:"rating"`
-	Comment   string    `json:"comment" bson:"comment"`
-	Helpful   int       `json:"helpful" bson:"helpful"`
-	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ID                string     `json:"id" bson:"_id,omitempty"`
+	ProductID         string     `json:"product_id" bson:"product_id"`
+	UserID            int        `json:"user_id" bson:"user_id"`
+	Rating            int        `json:"rating" bson:"rating"`
+	Comment           string     `json:"comment" bson:"comment"`
+	Helpful           int        `json:"helpful" bson:"helpful"`
+	NotHelpful        int        `json:"not_helpful" bson:"not_helpful"`
+	HelpfulScore      float64    `json:"helpful_score" bson:"helpful_score"`
+	VerifiedPurchase  bool       `json:"verified_purchase" bson:"verified_purchase"`
+	Status            string     `json:"status" bson:"status"`
+	ModerationReasons []string   `json:"moderation_reasons,omitempty" bson:"moderation_reasons,omitempty"`
+	ReportCount       int        `json:"report_count" bson:"report_count"`
+	ModerationNote    string     `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	ModeratedBy       string     `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt       *time.Time `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+// RatingSummary aggregates a product's reviews (MongoDB). Histogram maps
+// each star rating "1" to "5" to its number of reviews.
+type RatingSummary struct {
+	ProductID   string         `json:"product_id"`
+	Average     float64        `json:"average"`
+	ReviewCount int            `json:"review_count"`
+	Histogram   map[string]int `json:"histogram"`
 }
 
 // Category represents a product category (MongoDB)
@@ -83,6 +198,7 @@ type Category struct {
 	Description string    `json:"description" bson:"description"`
 	ParentID    string    `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
 	ImageURL    string    `json:"image_url" bson:"image_url"`
+	Version     int       `json:"version" bson:"version,omitempty"`
 	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
 }
@@ -107,10 +223,55 @@ type SalesAnalytics struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
-// Wishlist represents user wishlist items (MongoDB)
-type Wishlist struct {
+// ReviewVote is one user's helpfulness vote on a review (MongoDB)
+type ReviewVote struct {
+	ID        string    `json:"id" bson:"_id,omitempty"`
+	ReviewID  string    `json:"review_id" bson:"review_id"`
+	UserID    int       `json:"user_id" bson:"user_id"`
+	Vote      string    `json:"vote" bson:"vote"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ReviewReport is a customer's report of an inappropriate review (MongoDB)
+type ReviewReport struct {
 	ID        string    `json:"id" bson:"_id,omitempty"`
+	ReviewID  string    `json:"review_id" bson:"review_id"`
 	UserID    int       `json:"user_id" bson:"user_id"`
-	ProductID string    `json:"product_id" bson:"product_id"`
-	AddedAt   time.Time `json:"added_at" bson:"added_at"`
+	Reason    string    `json:"reason" bson:"reason"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Wishlist represents user wishlist items (MongoDB)
+type Wishlist struct {
+	ID         string    `json:"id" bson:"_id,omitempty"`
+	UserID     int       `json:"user_id" bson:"user_id"`
+	ProductID  string    `json:"product_id" bson:"product_id"`
+	PriceAtAdd float64   `json:"price_at_add" bson:"price_at_add"`
+	AddedAt    time.Time `json:"added_at" bson:"added_at"`
+	// LastNotifiedPrice is the price of the last price-drop event sent for
+	// this item, so each drop is only announced once
+	LastNotifiedPrice *float64 `json:"-" bson:"last_notified_price,omitempty"`
+}
+
+// WishlistItem is a wishlist entry joined with the product's current
+// details. Product is null if the product has since been deleted.
+type WishlistItem struct {
+	Wishlist
+	Product *Product `json:"product"`
+	// PriceDrop is how much cheaper the product is than when it was added
+	PriceDrop   float64 `json:"price_drop"`
+	StockStatus string  `json:"stock_status"`
+}
+
+// PriceDropEvent records that a wishlisted product became cheaper than it
+// was when the user added it (MongoDB)
+type PriceDropEvent struct {
+	ID          string    `json:"id" bson:"_id,omitempty"`
+	UserID      int       `json:"user_id" bson:"user_id"`
+	ProductID   string    `json:"product_id" bson:"product_id"`
+	ProductName string    `json:"product_name" bson:"product_name"`
+	PriceAtAdd  float64   `json:"price_at_add" bson:"price_at_add"`
+	NewPrice    float64   `json:"new_price" bson:"new_price"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
 }
@@ -0,0 +1,141 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Spam heuristics. They are deliberately conservative, since a hit only
+// holds the review for a moderator rather than rejecting it.
+const (
+	// A run of this many identical characters ("!!!!!!", "soooooo")
+	maxRepeatedRun = 6
+	// Shouting: at least this many letters, most of them capitals
+	minLettersForCaps = 20
+	maxCapsRatio      = 0.7
+	// Repetition: at least this many words, one of them making up most
+	minWordsForRepetition = 10
+	maxSingleWordRatio    = 0.4
+)
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+
+// Screener checks review text against a word list, regular expressions, a
+// link limit and the spam heuristics above
+type Screener struct {
+	words    map[string]bool
+	phrases  []string
+	patterns []*regexp.Regexp
+	maxLinks int
+}
+
+// NewScreener builds a Screener. Words are matched case-insensitively as
+// whole words; entries containing spaces are matched as phrases.
+func NewScreener(words, patterns []string, maxLinks int) (*Screener, error) {
+	s := &Screener{words: map[string]bool{}, maxLinks: maxLinks}
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		switch {
+		case w == "":
+		case strings.ContainsAny(w, " \t"):
+			s.phrases = append(s.phrases, strings.Join(strings.Fields(w), " "))
+		default:
+			s.words[w] = true
+		}
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("blocked pattern %q: %w", p, err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+	return s, nil
+}
+
+// Screen returns the reasons text should be held for moderation, or nil if
+// it passes
+func (s *Screener) Screen(text string) []string {
+	var reasons []string
+
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		if s.words[token] && !seen[token] {
+			seen[token] = true
+			reasons = append(reasons, "blocked word: "+token)
+		}
+	}
+	normalized := strings.Join(tokens, " ")
+	for _, phrase := range s.phrases {
+		if strings.Contains(" "+normalized+" ", " "+phrase+" ") {
+			reasons = append(reasons, "blocked phrase: "+phrase)
+		}
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(text) {
+			reasons = append(reasons, "matches blocked pattern: "+re.String())
+		}
+	}
+
+	if links := len(linkPattern.FindAllStringIndex(text, -1)); links > s.maxLinks {
+		reasons = append(reasons, fmt.Sprintf("too many links (%d)", links))
+	}
+	if hasRepeatedRun(text) {
+		reasons = append(reasons, "repeated characters")
+	}
+	if mostlyCapitals(text) {
+		reasons = append(reasons, "mostly capital letters")
+	}
+	if repetitive(tokens) {
+		reasons = append(reasons, "repeated words")
+	}
+	return reasons
+}
+
+func hasRepeatedRun(text string) bool {
+	var last rune
+	run := 0
+	for _, r := range text {
+		if r == last && !unicode.IsSpace(r) {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		if run >= maxRepeatedRun {
+			return true
+		}
+	}
+	return false
+}
+
+func mostlyCapitals(text string) bool {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= minLettersForCaps && float64(upper) > maxCapsRatio*float64(letters)
+}
+
+func repetitive(tokens []string) bool {
+	if len(tokens) < minWordsForRepetition {
+		return false
+	}
+	counts := map[string]int{}
+	for _, t := range tokens {
+		counts[t]++
+		if float64(counts[t]) > maxSingleWordRatio*float64(len(tokens)) {
+			return true
+		}
+	}
+	return false
+}
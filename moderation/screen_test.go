@@ -0,0 +1,93 @@
+package moderation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewScreener(t *testing.T) {
+	s, err := NewScreener([]string{" Scam ", "", "free  money", "BUY\tNOW"}, []string{`\d{3}-\d{4}`}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.words, map[string]bool{"scam": true}) {
+		t.Errorf("words = %v, want scam", s.words)
+	}
+	if !reflect.DeepEqual(s.phrases, []string{"free money", "buy now"}) {
+		t.Errorf("phrases = %q, want free money and buy now", s.phrases)
+	}
+
+	if _, err := NewScreener(nil, []string{`(unclosed`}, 0); err == nil || !strings.Contains(err.Error(), `"(unclosed"`) {
+		t.Errorf("invalid pattern: error = %v, want it to name the pattern", err)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	s, err := NewScreener([]string{"scam", "free money"}, []string{`(?i)call \d{3}-\d{4}`}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "clean", text: "Great laptop, the battery lasts all day. Would buy again!"},
+		{name: "empty", text: ""},
+		{name: "blocked word", text: "This is a SCAM.", want: []string{"blocked word: scam"}},
+		{name: "blocked word reported once", text: "scam, scam and more scam", want: []string{"blocked word: scam"}},
+		{name: "only whole words", text: "Scampi was fine"},
+		{name: "blocked phrase", text: "Get FREE\nmoney here", want: []string{"blocked phrase: free money"}},
+		{name: "phrase across punctuation", text: "free, money!", want: []string{"blocked phrase: free money"}},
+		{name: "phrase only at word boundaries", text: "carefree money management"},
+		{name: "blocked pattern", text: "Call 555-1234 now", want: []string{`matches blocked pattern: (?i)call \d{3}-\d{4}`}},
+		{name: "one link allowed", text: "Specs at https://example.com"},
+		{name: "too many links", text: "See http://a.example and www.b.example", want: []string{"too many links (2)"}},
+		{name: "repeated characters", text: "Sooooooo good", want: []string{"repeated characters"}},
+		{name: "short runs allowed", text: "Sooooo good!!!!!"},
+		{name: "repeated spaces allowed", text: "good" + strings.Repeat(" ", 10) + "value"},
+		{name: "mostly capitals", text: "THIS IS THE BEST PRODUCT EVER MADE", want: []string{"mostly capital letters"}},
+		{name: "short shouting allowed", text: "LOVE IT"},
+		{name: "acronyms allowed", text: "The USB-C and HDMI ports work with my TV"},
+		{name: "repeated words", text: "buy buy buy buy buy this phone it is a phone", want: []string{"repeated words"}},
+		{name: "short repetition allowed", text: "good good good good"},
+		{
+			name: "several reasons",
+			text: "SCAM SCAM SCAM SCAM SCAM SCAM SCAM SCAM SCAM SCAM!!!!!!",
+			want: []string{"blocked word: scam", "repeated characters", "mostly capital letters", "repeated words"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Screen(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Screen(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeuristicThresholds(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() bool
+		want bool
+	}{
+		{name: "run one short of the limit", fn: func() bool { return hasRepeatedRun(strings.Repeat("a", maxRepeatedRun-1)) }},
+		{name: "run at the limit", fn: func() bool { return hasRepeatedRun(strings.Repeat("a", maxRepeatedRun)) }, want: true},
+		{name: "capitals with too few letters", fn: func() bool { return mostlyCapitals(strings.Repeat("A", minLettersForCaps-1)) }},
+		{name: "capitals at the letter minimum", fn: func() bool { return mostlyCapitals(strings.Repeat("A", minLettersForCaps)) }, want: true},
+		{name: "capitals at the ratio", fn: func() bool { return mostlyCapitals(strings.Repeat("A", 14) + strings.Repeat("a", 6)) }},
+		{name: "capitals above the ratio", fn: func() bool { return mostlyCapitals(strings.Repeat("A", 15) + strings.Repeat("a", 5)) }, want: true},
+		{name: "capitals ignore digits and punctuation", fn: func() bool { return mostlyCapitals("OK 1234567890 !!! ???") }},
+		{name: "repetition with too few words", fn: func() bool { return repetitive(strings.Fields(strings.Repeat("a ", minWordsForRepetition-1))) }},
+		{name: "repetition at the ratio", fn: func() bool { return repetitive(strings.Fields("a a a a b c d e f g")) }},
+		{name: "repetition above the ratio", fn: func() bool { return repetitive(strings.Fields("a a a a a b c d e f")) }, want: true},
+	}
+	for _, tt := range tests {
+		if got := tt.fn(); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package seed
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dataset is everything a seed run writes, generated up front so that the
+// same options always produce the same data. Records refer to each other by
+// index; database IDs are only known once the rows are written.
+type dataset struct {
+	users      []user
+	categories []category
+	products   []product
+	warehouses []warehouse
+	stock      []stockLevel
+	inventory  []inventoryRow
+	sales      []sale
+	orders     []order
+	reviews    []review
+	wishlists  []wishlistItem
+}
+
+type user struct {
+	name, email, password, address, phone string
+	state                                 string
+	createdAt                             time.Time
+}
+
+type category struct {
+	id                          primitive.ObjectID
+	name, description, imageURL string
+	createdAt                   time.Time
+}
+
+type product struct {
+	id                                           primitive.ObjectID
+	name, description, category, brand, imageURL string
+	price                                        float64
+	tags                                         []string
+	createdAt                                    time.Time
+	// popularity drives sales and orders, quality drives review ratings
+	popularity, quality float64
+}
+
+type warehouse struct {
+	code, name, address, city, state, country string
+}
+
+type stockLevel struct {
+	product, warehouse, quantity int
+	restockedAt                  time.Time
+}
+
+type inventoryRow struct {
+	product, quantity, lowStockThreshold int
+	location                             string
+	lastRestocked                        time.Time
+}
+
+type sale struct {
+	product, quantity int
+	revenue           float64
+	date              time.Time
+}
+
+type order struct {
+	user, warehouse       int
+	items                 []orderItem
+	total                 float64
+	status, paymentMethod string
+	createdAt, updatedAt  time.Time
+}
+
+type orderItem struct {
+	product, quantity int
+	price             float64
+}
+
+type review struct {
+	id            primitive.ObjectID
+	user, product int
+	rating        int
+	comment       string
+	verified      bool
+	createdAt     time.Time
+}
+
+type wishlistItem struct {
+	id            primitive.ObjectID
+	user, product int
+	priceAtAdd    float64
+	addedAt       time.Time
+}
+
+// idEpoch stamps generated ObjectIDs, so IDs don't change with the day the
+// seed runs
+var idEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type generator struct {
+	rng  *rand.Rand
+	opts Options
+	// today anchors every date, so sales history always ends yesterday
+	today time.Time
+	ids   uint32
+}
+
+func generate(opts Options, today time.Time) *dataset {
+	g := &generator{rng: rand.New(rand.NewSource(opts.Seed)), opts: opts, today: today}
+	d := &dataset{warehouses: warehouses}
+	d.users = g.users()
+	d.categories = g.categories()
+	d.products = g.products(d.categories)
+	d.stock, d.inventory = g.stock(d.products)
+	d.sales = g.sales(d.products)
+	d.orders = g.orders(d.users, d.products)
+	d.reviews = g.reviews(d.users, d.products, d.orders)
+	d.wishlists = g.wishlists(d.users, d.products)
+	return d
+}
+
+// objectID makes a valid, increasing ObjectID from the random source
+func (g *generator) objectID() primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[:4], uint32(idEpoch.Unix())+g.ids)
+	g.ids++
+	binary.BigEndian.PutUint64(id[4:], g.rng.Uint64())
+	return id
+}
+
+// daysAgo is a random time within the last n days
+func (g *generator) daysAgo(n int) time.Time {
+	return g.today.Add(-time.Duration(g.rng.Int63n(int64(n)*int64(24*time.Hour)) + 1))
+}
+
+// after is a random time between t and today
+func (g *generator) after(t time.Time) time.Time {
+	span := g.today.Sub(t)
+	if span <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(g.rng.Int63n(int64(span))))
+}
+
+func (g *generator) pick(options []string) string {
+	return options[g.rng.Intn(len(options))]
+}
+
+// weighted picks a key of weights with probability proportional to its
+// weight. Keys are sorted so the choice only depends on the random source.
+func (g *generator) weighted(weights map[string]int) string {
+	keys := make([]string, 0, len(weights))
+	total := 0
+	for k, w := range weights {
+		keys = append(keys, k)
+		total += w
+	}
+	sort.Strings(keys)
+	n := g.rng.Intn(total)
+	for _, k := range keys {
+		if n -= weights[k]; n < 0 {
+			return k
+		}
+	}
+	return keys[len(keys)-1]
+}
+
+func (g *generator) users() []user {
+	users := make([]user, g.opts.Users)
+	for i := range users {
+		first, last := g.pick(firstNames), g.pick(lastNames)
+		c := cities[g.rng.Intn(len(cities))]
+		users[i] = user{
+			name:      first + " " + last,
+			email:     fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), i+1),
+			password:  "password123",
+			address:   fmt.Sprintf("%d %s, %s, %s", 1+g.rng.Intn(9999), g.pick(streets), c.city, c.state),
+			phone:     fmt.Sprintf("+1-555-%04d", g.rng.Intn(10000)),
+			state:     c.state,
+			createdAt: g.daysAgo(2 * g.opts.Days),
+		}
+	}
+	return users
+}
+
+func (g *generator) categories() []category {
+	categories := make([]category, g.opts.Categories)
+	for i := range categories {
+		c := catalog[i%len(catalog)]
+		name := c.name
+		if i >= len(catalog) {
+			name = fmt.Sprintf("%s %d", c.name, i/len(catalog)+1)
+		}
+		categories[i] = category{
+			id:          g.objectID(),
+			name:        name,
+			description: c.description,
+			imageURL:    "https://example.com/images/categories/" + slug(name) + ".jpg",
+			createdAt:   g.daysAgo(2 * g.opts.Days),
+		}
+	}
+	return categories
+}
+
+func (g *generator) products(categories []category) []product {
+	if len(categories) == 0 {
+		return nil
+	}
+	products := make([]product, g.opts.Products)
+	for i := range products {
+		ci := g.rng.Intn(len(categories))
+		c := catalog[ci%len(catalog)]
+		noun, adjective, brand := g.pick(c.nouns), g.pick(adjectives), g.pick(brands)
+		price := math.Floor(c.minPrice+g.rng.Float64()*(c.maxPrice-c.minPrice)) + 0.99
+		products[i] = product{
+			id:          g.objectID(),
+			name:        fmt.Sprintf("%s %s %s", brand, adjective, noun),
+			description: fmt.Sprintf("A %s %s from %s.", strings.ToLower(adjective), strings.ToLower(noun), brand),
+			category:    categories[ci].name,
+			brand:       brand,
+			imageURL:    fmt.Sprintf("https://example.com/images/products/%d.jpg", i+1),
+			price:       price,
+			tags:        []string{slug(categories[ci].name), slug(noun), strings.ToLower(adjective)},
+			createdAt:   g.daysAgo(2 * g.opts.Days),
+			popularity:  0.1 + 0.9*g.rng.Float64(),
+			quality:     2.5 + 2.5*g.rng.Float64(),
+		}
+	}
+	return products
+}
+
+// stock spreads each product over the warehouses, leaving some out of
+// stock, and derives the inventory totals the application keeps in sync
+func (g *generator) stock(products []product) ([]stockLevel, []inventoryRow) {
+	var levels []stockLevel
+	inventory := make([]inventoryRow, len(products))
+	for p := range products {
+		row := inventoryRow{product: p, lowStockThreshold: 5 + 5*g.rng.Intn(4)}
+		most := -1
+		for w := range warehouses {
+			quantity := 0
+			if g.rng.Float64() < 0.8 {
+				quantity = g.rng.Intn(int(20 + 180*products[p].popularity))
+			}
+			if quantity == 0 {
+				continue
+			}
+			level := stockLevel{product: p, warehouse: w, quantity: quantity, restockedAt: g.daysAgo(30)}
+			levels = append(levels, level)
+			row.quantity += quantity
+			if quantity > most {
+				most = quantity
+				row.location = warehouses[w].name
+			}
+			if level.restockedAt.After(row.lastRestocked) {
+				row.lastRestocked = level.restockedAt
+			}
+		}
+		if row.lastRestocked.IsZero() {
+			row.lastRestocked = g.daysAgo(g.opts.Days)
+		}
+		inventory[p] = row
+	}
+	return levels, inventory
+}
+
+// sales generates a daily sales history for the last Days days, busier for
+// more popular products
+func (g *generator) sales(products []product) []sale {
+	var sales []sale
+	for p, prod := range products {
+		for day := g.opts.Days; day >= 1; day-- {
+			if g.rng.Float64() >= 0.6*prod.popularity {
+				continue
+			}
+			quantity := 1 + g.rng.Intn(1+int(5*prod.popularity))
+			sales = append(sales, sale{
+				product:  p,
+				quantity: quantity,
+				revenue:  roundCents(float64(quantity) * prod.price),
+				date:     g.today.AddDate(0, 0, -day),
+			})
+		}
+	}
+	return sales
+}
+
+var orderStatuses = map[string]int{"delivered": 40, "shipped": 20, "processing": 15, "pending": 15, "cancelled": 10}
+
+var paymentMethods = []string{"credit_card", "debit_card", "paypal", "cash"}
+
+func (g *generator) orders(users []user, products []product) []order {
+	if len(users) == 0 || len(products) == 0 {
+		return nil
+	}
+	orders := make([]order, g.opts.Orders)
+	for i := range orders {
+		u := g.rng.Intn(len(users))
+		o := order{
+			user:          u,
+			warehouse:     g.nearestWarehouse(users[u].state),
+			status:        g.weighted(orderStatuses),
+			paymentMethod: g.pick(paymentMethods),
+			createdAt:     g.daysAgo(g.opts.Days),
+		}
+		o.updatedAt = g.after(o.createdAt)
+		seen := map[int]bool{}
+		for n := 1 + g.rng.Intn(4); len(o.items) < n && len(seen) < len(products); {
+			p := g.popularProduct(products)
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			item := orderItem{product: p, quantity: 1 + g.rng.Intn(3), price: products[p].price}
+			o.items = append(o.items, item)
+			o.total += float64(item.quantity) * item.price
+		}
+		o.total = roundCents(o.total)
+		orders[i] = o
+	}
+	return orders
+}
+
+// popularProduct picks a product, favouring popular ones
+func (g *generator) popularProduct(products []product) int {
+	for {
+		p := g.rng.Intn(len(products))
+		if g.rng.Float64() < products[p].popularity {
+			return p
+		}
+	}
+}
+
+// nearestWarehouse is a warehouse in state, or any warehouse if none is
+func (g *generator) nearestWarehouse(state string) int {
+	for i, w := range warehouses {
+		if w.state == state {
+			return i
+		}
+	}
+	return g.rng.Intn(len(warehouses))
+}
+
+// reviews writes one review per (user, product) pair, starting with
+// products the user received so that verified purchases are well
+// represented
+func (g *generator) reviews(users []user, products []product, orders []order) []review {
+	type pair struct{ user, product int }
+	var delivered []pair
+	bought := map[pair]time.Time{}
+	for _, o := range orders {
+		if o.status != "delivered" {
+			continue
+		}
+		for _, item := range o.items {
+			p := pair{o.user, item.product}
+			if _, ok := bought[p]; !ok {
+				delivered = append(delivered, p)
+				bought[p] = o.updatedAt
+			}
+		}
+	}
+	g.rng.Shuffle(len(delivered), func(i, j int) { delivered[i], delivered[j] = delivered[j], delivered[i] })
+
+	want := g.opts.Reviews
+	if max := len(users) * len(products); want > max {
+		want = max
+	}
+	reviews := make([]review, 0, want)
+	seen := map[pair]bool{}
+	for i := 0; len(reviews) < want; i++ {
+		var p pair
+		if i < len(delivered) && i < want/2 {
+			p = delivered[i]
+		} else {
+			p = pair{g.rng.Intn(len(users)), g.popularProduct(products)}
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		rating := int(math.Round(products[p.product].quality + g.rng.NormFloat64()))
+		rating = int(math.Max(1, math.Min(5, float64(rating))))
+		deliveredAt, verified := bought[p]
+		createdAt := g.daysAgo(g.opts.Days)
+		if verified {
+			createdAt = g.after(deliveredAt)
+		}
+		reviews = append(reviews, review{
+			id:        g.objectID(),
+			user:      p.user,
+			product:   p.product,
+			rating:    rating,
+			comment:   g.pick(comments[rating]),
+			verified:  verified,
+			createdAt: createdAt,
+		})
+	}
+	return reviews
+}
+
+// wishlists adds unique (user, product) entries. Some were added at a higher
+// price, so they show up as price drops.
+func (g *generator) wishlists(users []user, products []product) []wishlistItem {
+	type pair struct{ user, product int }
+	want := g.opts.Wishlists
+	if max := len(users) * len(products); want > max {
+		want = max
+	}
+	items := make([]wishlistItem, 0, want)
+	seen := map[pair]bool{}
+	for len(items) < want {
+		p := pair{g.rng.Intn(len(users)), g.popularProduct(products)}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		price := products[p.product].price
+		if g.rng.Float64() < 0.3 {
+			price = roundCents(price * (1.05 + 0.2*g.rng.Float64()))
+		}
+		items = append(items, wishlistItem{
+			id:         g.objectID(),
+			user:       p.user,
+			product:    p.product,
+			priceAtAdd: price,
+			addedAt:    g.daysAgo(g.opts.Days),
+		})
+	}
+	return items
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func slug(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, " ", "-"))
+}
@@ -0,0 +1,125 @@
+package seed
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testToday = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	opts := DefaultOptions()
+	if !reflect.DeepEqual(generate(opts, testToday), generate(opts, testToday)) {
+		t.Errorf("the same options generated different datasets")
+	}
+
+	a, b := generate(opts, testToday), generate(opts, testToday.AddDate(0, 0, 7))
+	if a.products[0].id != b.products[0].id || a.users[0].email != b.users[0].email {
+		t.Errorf("IDs or records changed with the day the seed ran")
+	}
+
+	opts.Seed++
+	if reflect.DeepEqual(generate(DefaultOptions(), testToday), generate(opts, testToday)) {
+		t.Errorf("different seeds generated the same dataset")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	opts := DefaultOptions()
+	d := generate(opts, testToday)
+
+	if len(d.users) != opts.Users || len(d.categories) != opts.Categories || len(d.products) != opts.Products ||
+		len(d.orders) != opts.Orders || len(d.reviews) != opts.Reviews || len(d.wishlists) != opts.Wishlists {
+		t.Fatalf("generated %d users, %d categories, %d products, %d orders, %d reviews, %d wishlist items, want %+v",
+			len(d.users), len(d.categories), len(d.products), len(d.orders), len(d.reviews), len(d.wishlists), opts)
+	}
+
+	emails := map[string]bool{}
+	for _, u := range d.users {
+		if emails[u.email] {
+			t.Errorf("duplicate email %s", u.email)
+		}
+		emails[u.email] = true
+	}
+
+	// Inventory totals match the per-warehouse stock
+	totals := make([]int, len(d.products))
+	for _, level := range d.stock {
+		if level.quantity <= 0 {
+			t.Errorf("stock level %+v isn't positive", level)
+		}
+		totals[level.product] += level.quantity
+	}
+	for p, row := range d.inventory {
+		if row.product != p || row.quantity != totals[p] {
+			t.Errorf("inventory row %+v, want product %d with %d", row, p, totals[p])
+		}
+	}
+
+	for _, s := range d.sales {
+		if !s.date.Before(testToday) || s.date.Before(testToday.AddDate(0, 0, -opts.Days)) {
+			t.Errorf("sale dated %s is outside the last %d days", s.date, opts.Days)
+		}
+	}
+
+	delivered := map[[2]int]bool{}
+	for _, o := range d.orders {
+		if len(o.items) == 0 {
+			t.Errorf("order %+v has no items", o)
+		}
+		total := 0.0
+		for _, item := range o.items {
+			total += float64(item.quantity) * item.price
+			if o.status == "delivered" {
+				delivered[[2]int{o.user, item.product}] = true
+			}
+		}
+		if math.Abs(total-o.total) > 0.005 {
+			t.Errorf("order total %v, items add up to %v", o.total, total)
+		}
+		if o.updatedAt.Before(o.createdAt) {
+			t.Errorf("order updated at %s, before it was created at %s", o.updatedAt, o.createdAt)
+		}
+	}
+
+	// One review per user and product, verified exactly when delivered
+	reviewed := map[[2]int]bool{}
+	for _, r := range d.reviews {
+		pair := [2]int{r.user, r.product}
+		if reviewed[pair] {
+			t.Errorf("user %d reviewed product %d twice", r.user, r.product)
+		}
+		reviewed[pair] = true
+		if r.rating < 1 || r.rating > 5 {
+			t.Errorf("review rating %d", r.rating)
+		}
+		if r.verified != delivered[pair] {
+			t.Errorf("review by %d of %d verified = %v, want %v", r.user, r.product, r.verified, delivered[pair])
+		}
+		if r.createdAt.After(testToday) {
+			t.Errorf("review created at %s, after today", r.createdAt)
+		}
+	}
+
+	wishlisted := map[[2]int]bool{}
+	for _, w := range d.wishlists {
+		pair := [2]int{w.user, w.product}
+		if wishlisted[pair] {
+			t.Errorf("user %d wishlisted product %d twice", w.user, w.product)
+		}
+		wishlisted[pair] = true
+		if w.priceAtAdd < d.products[w.product].price {
+			t.Errorf("wishlist price %v is below the product's price %v", w.priceAtAdd, d.products[w.product].price)
+		}
+	}
+}
+
+func TestGenerateCapsPairs(t *testing.T) {
+	opts := Options{Seed: 1, Users: 2, Categories: 1, Products: 2, Reviews: 10, Wishlists: 10, Orders: 3, Days: 7}
+	d := generate(opts, testToday)
+	if len(d.reviews) != 4 || len(d.wishlists) != 4 {
+		t.Errorf("generated %d reviews and %d wishlist items, want 4 of each", len(d.reviews), len(d.wishlists))
+	}
+}
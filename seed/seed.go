@@ -0,0 +1,440 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"sample-application/handlers"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Options sizes the generated dataset. The same options always generate
+// the same records, including Mongo ObjectIDs; dates are relative to the
+// day the seed runs.
+type Options struct {
+	Seed       int64
+	Users      int
+	Categories int
+	Products   int
+	Reviews    int
+	Wishlists  int
+	Orders     int
+	// Days of sales and order history
+	Days int
+}
+
+func DefaultOptions() Options {
+	return Options{Seed: 42, Users: 50, Categories: 8, Products: 200, Reviews: 500, Wishlists: 150, Orders: 300, Days: 90}
+}
+
+// Stores are the databases a seed writes to
+type Stores struct {
+	Postgres *sql.DB
+	MySQL    *sql.DB
+	Mongo    *mongo.Database
+}
+
+// Application tables and collections, emptied by Reset. Migration
+// bookkeeping is kept, so the schemas stay current.
+var (
+	postgresTables   = []string{"order_items", "orders", "cart", "users", "idempotency_keys"}
+	mysqlTables      = []string{"stock_movements", "stock_transfers", "warehouse_stock", "warehouses", "sales_analytics", "inventory"}
+	mongoCollections = []string{"products", "categories", "reviews", "review_votes", "review_reports", "wishlist", "price_drop_events"}
+)
+
+// actor is recorded on the ledger entries for seeded stock
+const actor = "seed"
+
+var errNotEmpty = errors.New("databases already hold data; seed with -reset to replace it")
+
+// Reset deletes all application data from every store
+func Reset(ctx context.Context, s Stores) error {
+	_, err := s.Postgres.ExecContext(ctx, "TRUNCATE "+strings.Join(postgresTables, ", ")+" RESTART IDENTITY CASCADE")
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+
+	// TRUNCATE refuses tables referenced by foreign keys unless checks are
+	// off, which only lasts for the session
+	conn, err := s.MySQL.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("mysql: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return fmt.Errorf("mysql: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+	for _, table := range mysqlTables {
+		if _, err := conn.ExecContext(ctx, "TRUNCATE TABLE "+table); err != nil {
+			return fmt.Errorf("mysql: %w", err)
+		}
+	}
+
+	// Deleting the documents rather than dropping the collections keeps
+	// the indexes migrations created
+	for _, collection := range mongoCollections {
+		if _, err := s.Mongo.Collection(collection).DeleteMany(ctx, bson.M{}); err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+	}
+	return nil
+}
+
+// Summary counts the records a seed wrote
+type Summary struct {
+	Users, Categories, Products, Warehouses, Inventory, Sales, Orders, OrderItems, Reviews, Wishlists int
+}
+
+// Run generates the dataset for opts and writes it. It refuses to write
+// into stores that already hold users, products or inventory, since the
+// generated IDs and emails would collide.
+func Run(ctx context.Context, s Stores, opts Options) (Summary, error) {
+	if err := checkEmpty(ctx, s); err != nil {
+		return Summary{}, err
+	}
+	d := generate(opts, time.Now().UTC().Truncate(24*time.Hour))
+
+	userIDs, err := writeUsers(ctx, s.Postgres, d.users)
+	if err != nil {
+		return Summary{}, fmt.Errorf("postgres: %w", err)
+	}
+	if err := writeCatalog(ctx, s.Mongo, d); err != nil {
+		return Summary{}, fmt.Errorf("mongodb: %w", err)
+	}
+	warehouseIDs, err := writeInventory(ctx, s.MySQL, d)
+	if err != nil {
+		return Summary{}, fmt.Errorf("mysql: %w", err)
+	}
+	items, err := writeOrders(ctx, s.Postgres, d, userIDs, warehouseIDs)
+	if err != nil {
+		return Summary{}, fmt.Errorf("postgres: %w", err)
+	}
+	if err := writeUserContent(ctx, s.Mongo, d, userIDs); err != nil {
+		return Summary{}, fmt.Errorf("mongodb: %w", err)
+	}
+
+	return Summary{
+		Users:      len(d.users),
+		Categories: len(d.categories),
+		Products:   len(d.products),
+		Warehouses: len(d.warehouses),
+		Inventory:  len(d.inventory),
+		Sales:      len(d.sales),
+		Orders:     len(d.orders),
+		OrderItems: items,
+		Reviews:    len(d.reviews),
+		Wishlists:  len(d.wishlists),
+	}, nil
+}
+
+func checkEmpty(ctx context.Context, s Stores) error {
+	var exists bool
+	if err := s.Postgres.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users)`).Scan(&exists); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if !exists {
+		if err := s.MySQL.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM inventory)`).Scan(&exists); err != nil {
+			return fmt.Errorf("mysql: %w", err)
+		}
+	}
+	if !exists {
+		n, err := s.Mongo.Collection("products").EstimatedDocumentCount(ctx)
+		if err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+		exists = n > 0
+	}
+	if exists {
+		return errNotEmpty
+	}
+	return nil
+}
+
+// writeUsers inserts the users and returns their IDs, by index
+func writeUsers(ctx context.Context, db *sql.DB, users []user) ([]int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(users))
+	for i, u := range users {
+		err := tx.QueryRowContext(ctx, `INSERT INTO users (name, email, password, address, phone, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6) RETURNING id`, u.name, u.email, u.password, u.address, u.phone, u.createdAt).Scan(&ids[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
+
+func writeCatalog(ctx context.Context, db *mongo.Database, d *dataset) error {
+	categories := make([]interface{}, len(d.categories))
+	for i, c := range d.categories {
+		categories[i] = bson.M{
+			"_id":         c.id,
+			"name":        c.name,
+			"description": c.description,
+			"image_url":   c.imageURL,
+			"version":     1,
+			"created_at":  c.createdAt,
+			"updated_at":  c.createdAt,
+		}
+	}
+	if err := insertMany(ctx, db.Collection("categories"), categories); err != nil {
+		return err
+	}
+
+	// Rating aggregates are left to `ratings repair`, which derives them
+	// from the reviews
+	products := make([]interface{}, len(d.products))
+	for i, p := range d.products {
+		products[i] = bson.M{
+			"_id":         p.id,
+			"name":        p.name,
+			"description": p.description,
+			"price":       p.price,
+			"category":    p.category,
+			"brand":       p.brand,
+			"image_url":   p.imageURL,
+			"tags":        p.tags,
+			"version":     1,
+			"created_at":  p.createdAt,
+			"updated_at":  p.createdAt,
+		}
+	}
+	return insertMany(ctx, db.Collection("products"), products)
+}
+
+// writeInventory writes the warehouses, per-warehouse stock with its
+// opening ledger entries, inventory totals and sales history. It returns
+// the warehouse IDs, by index.
+func writeInventory(ctx context.Context, db *sql.DB, d *dataset) ([]int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The migrations create DEFAULT, so an existing warehouse is reused
+	ids := make([]int, len(d.warehouses))
+	for i, w := range d.warehouses {
+		result, err := tx.ExecContext(ctx, `INSERT INTO warehouses (code, name, address, city, state, country, active) VALUES (?, ?, ?, ?, ?, ?, TRUE)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), name = VALUES(name), address = VALUES(address), city = VALUES(city), state = VALUES(state), country = VALUES(country), active = TRUE`,
+			w.code, w.name, w.address, w.city, w.state, w.country)
+		if err != nil {
+			return nil, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = int(id)
+	}
+
+	stock := make([][]interface{}, len(d.stock))
+	movements := make([][]interface{}, len(d.stock))
+	for i, s := range d.stock {
+		productID := d.products[s.product].id.Hex()
+		stock[i] = []interface{}{productID, ids[s.warehouse], s.quantity}
+		movements[i] = []interface{}{productID, ids[s.warehouse], handlers.MovementRestock, s.quantity, s.quantity, "opening stock", actor, s.restockedAt}
+	}
+	if err := insertRows(ctx, tx, "warehouse_stock (product_id, warehouse_id, quantity)", stock); err != nil {
+		return nil, err
+	}
+	if err := insertRows(ctx, tx, "stock_movements (product_id, warehouse_id, movement_type, quantity, balance_after, reason, actor, created_at)", movements); err != nil {
+		return nil, err
+	}
+
+	inventory := make([][]interface{}, len(d.inventory))
+	for i, row := range d.inventory {
+		inventory[i] = []interface{}{d.products[row.product].id.Hex(), row.quantity, row.location, row.lastRestocked, row.lowStockThreshold}
+	}
+	if err := insertRows(ctx, tx, "inventory (product_id, quantity, warehouse_location, last_restocked, low_stock_threshold)", inventory); err != nil {
+		return nil, err
+	}
+
+	sales := make([][]interface{}, len(d.sales))
+	for i, s := range d.sales {
+		sales[i] = []interface{}{d.products[s.product].id.Hex(), s.quantity, s.revenue, s.date.Format("2006-01-02")}
+	}
+	if err := insertRows(ctx, tx, "sales_analytics (product_id, quantity_sold, revenue, sale_date)", sales); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// writeOrders inserts the orders and their items, returning the number of
+// items written
+func writeOrders(ctx context.Context, db *sql.DB, d *dataset, userIDs, warehouseIDs []int) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	items := 0
+	for _, o := range d.orders {
+		u := d.users[o.user]
+		var id int
+		err := tx.QueryRowContext(ctx, `INSERT INTO orders (user_id, total_amount, status, payment_method, shipping_address, warehouse_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			userIDs[o.user], o.total, o.status, o.paymentMethod, u.address, warehouseIDs[o.warehouse], o.createdAt, o.updatedAt).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		for _, item := range o.items {
+			_, err := tx.ExecContext(ctx, `INSERT INTO order_items (order_id, product_id, quantity, price, created_at) VALUES ($1, $2, $3, $4, $5)`,
+				id, d.products[item.product].id.Hex(), item.quantity, item.price, o.createdAt)
+			if err != nil {
+				return 0, err
+			}
+			items++
+		}
+	}
+	return items, tx.Commit()
+}
+
+// writeUserContent inserts the reviews and wishlists, which refer to
+// Postgres users
+func writeUserContent(ctx context.Context, db *mongo.Database, d *dataset, userIDs []int) error {
+	reviews := make([]interface{}, len(d.reviews))
+	for i, r := range d.reviews {
+		reviews[i] = bson.M{
+			"_id":               r.id,
+			"product_id":        d.products[r.product].id.Hex(),
+			"user_id":           userIDs[r.user],
+			"rating":            r.rating,
+			"comment":           r.comment,
+			"helpful":           0,
+			"not_helpful":       0,
+			"helpful_score":     0.0,
+			"verified_purchase": r.verified,
+			"status":            handlers.ReviewApproved,
+			"report_count":      0,
+			"created_at":        r.createdAt,
+			"updated_at":        r.createdAt,
+		}
+	}
+	if err := insertMany(ctx, db.Collection("reviews"), reviews); err != nil {
+		return err
+	}
+
+	wishlist := make([]interface{}, len(d.wishlists))
+	for i, w := range d.wishlists {
+		wishlist[i] = bson.M{
+			"_id":          w.id,
+			"user_id":      userIDs[w.user],
+			"product_id":   d.products[w.product].id.Hex(),
+			"price_at_add": w.priceAtAdd,
+			"added_at":     w.addedAt,
+		}
+	}
+	return insertMany(ctx, db.Collection("wishlist"), wishlist)
+}
+
+func insertMany(ctx context.Context, collection *mongo.Collection, docs []interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := collection.InsertMany(ctx, docs)
+	return err
+}
+
+// insertRows inserts rows into a MySQL table in multi-row batches. into is
+// the table name followed by its column list.
+func insertRows(ctx context.Context, tx *sql.Tx, into string, rows [][]interface{}) error {
+	const batch = 500
+	for start := 0; start < len(rows); start += batch {
+		end := start + batch
+		if end > len(rows) {
+			end = len(rows)
+		}
+		placeholders := "(?" + strings.Repeat(", ?", len(rows[start])-1) + ")"
+		values := make([]string, 0, end-start)
+		var args []interface{}
+		for _, row := range rows[start:end] {
+			values = append(values, placeholders)
+			args = append(args, row...)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+into+" VALUES "+strings.Join(values, ", "), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunCommand implements `seed [-reset] [-seed n] [size flags]`
+func RunCommand(ctx context.Context, args []string, s Stores, out io.Writer) error {
+	opts := DefaultOptions()
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(out)
+	reset := fs.Bool("reset", false, "delete all application data first")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed; the same seed and sizes give the same data")
+	fs.IntVar(&opts.Users, "users", opts.Users, "number of users")
+	fs.IntVar(&opts.Categories, "categories", opts.Categories, "number of categories")
+	fs.IntVar(&opts.Products, "products", opts.Products, "number of products")
+	fs.IntVar(&opts.Reviews, "reviews", opts.Reviews, "number of reviews, at most one per user and product")
+	fs.IntVar(&opts.Wishlists, "wishlists", opts.Wishlists, "number of wishlist entries, at most one per user and product")
+	fs.IntVar(&opts.Orders, "orders", opts.Orders, "number of orders")
+	fs.IntVar(&opts.Days, "days", opts.Days, "days of sales and order history")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: seed [-reset] [-seed n] [-users n] [-categories n] [-products n] [-reviews n] [-wishlists n] [-orders n] [-days n]")
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	if *reset {
+		if err := Reset(ctx, s); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "reset: deleted all application data")
+	}
+	summary, err := Run(ctx, s, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "postgres: %d users, %d orders with %d items\n", summary.Users, summary.Orders, summary.OrderItems)
+	fmt.Fprintf(out, "mysql: %d warehouses, %d inventory rows, %d days of sales (%d rows)\n", summary.Warehouses, summary.Inventory, opts.Days, summary.Sales)
+	fmt.Fprintf(out, "mongodb: %d categories, %d products, %d reviews, %d wishlist entries\n", summary.Categories, summary.Products, summary.Reviews, summary.Wishlists)
+	return nil
+}
+
+func (o Options) validate() error {
+	var errs []error
+	for _, size := range []struct {
+		name string
+		n    int
+	}{{"users", o.Users}, {"categories", o.Categories}, {"products", o.Products}, {"reviews", o.Reviews}, {"wishlists", o.Wishlists}, {"orders", o.Orders}} {
+		if size.n < 0 {
+			errs = append(errs, fmt.Errorf("-%s must not be negative", size.name))
+		}
+	}
+	if o.Days < 1 {
+		errs = append(errs, errors.New("-days must be at least 1"))
+	}
+	if o.Products > 0 && o.Categories == 0 {
+		errs = append(errs, errors.New("products need at least one category"))
+	}
+	if (o.Orders > 0 || o.Reviews > 0 || o.Wishlists > 0) && (o.Users == 0 || o.Products == 0) {
+		errs = append(errs, errors.New("orders, reviews and wishlists need at least one user and product"))
+	}
+	return errors.Join(errs...)
+}
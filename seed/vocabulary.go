@@ -0,0 +1,53 @@
+package seed
+
+// The word lists the generator draws from. Changing them changes the
+// dataset every seed produces.
+
+type catalogEntry struct {
+	name, description  string
+	nouns              []string
+	minPrice, maxPrice float64
+}
+
+var catalog = []catalogEntry{
+	{"Electronics", "Phones, audio, computers and accessories", []string{"Headphones", "Speaker", "Smartwatch", "Tablet", "Charger", "Keyboard", "Monitor"}, 15, 900},
+	{"Books", "Fiction, non-fiction and reference", []string{"Novel", "Cookbook", "Biography", "Atlas", "Poetry Collection", "Field Guide"}, 5, 60},
+	{"Clothing", "Apparel for every season", []string{"Jacket", "T-Shirt", "Sweater", "Jeans", "Raincoat", "Hoodie"}, 10, 250},
+	{"Home", "Furniture, kitchenware and decor", []string{"Lamp", "Cookware Set", "Blanket", "Vase", "Coffee Maker", "Rug"}, 10, 400},
+	{"Sports", "Equipment and gear for staying active", []string{"Yoga Mat", "Running Shoes", "Water Bottle", "Tennis Racket", "Backpack", "Dumbbells"}, 8, 300},
+	{"Toys", "Games and toys for all ages", []string{"Puzzle", "Board Game", "Building Set", "Plush Bear", "Kite"}, 5, 120},
+	{"Beauty", "Skincare, haircare and fragrance", []string{"Moisturizer", "Shampoo", "Perfume", "Face Mask", "Hair Dryer"}, 5, 150},
+	{"Garden", "Tools and supplies for outdoor spaces", []string{"Hose", "Planter", "Pruning Shears", "Bird Feeder", "Lawn Chair"}, 8, 200},
+}
+
+var adjectives = []string{"Classic", "Compact", "Deluxe", "Eco", "Essential", "Premium", "Pro", "Smart", "Ultra", "Vintage"}
+
+var brands = []string{"BrandA", "BrandB", "BrandC", "BrandD", "BrandE", "Acme", "Northwind", "Contoso"}
+
+var firstNames = []string{"Alice", "Bob", "Carol", "David", "Emma", "Farah", "George", "Hana", "Ivan", "Julia", "Kenji", "Laura", "Miguel", "Nora", "Omar", "Priya", "Quinn", "Rosa", "Sam", "Tara"}
+
+var lastNames = []string{"Anderson", "Brown", "Chen", "Davis", "Garcia", "Johnson", "Kim", "Lopez", "Miller", "Nguyen", "Patel", "Smith", "Taylor", "Williams"}
+
+var streets = []string{"Main St", "Oak Ave", "Maple Dr", "Cedar Ln", "Elm St", "Park Blvd", "Lake Rd", "Hill St"}
+
+var cities = []struct{ city, state string }{
+	{"Columbus", "OH"}, {"Cleveland", "OH"}, {"Newark", "NJ"}, {"Trenton", "NJ"}, {"Reno", "NV"},
+	{"Las Vegas", "NV"}, {"Austin", "TX"}, {"Denver", "CO"}, {"Seattle", "WA"}, {"Chicago", "IL"},
+}
+
+// warehouses are written with their codes, so DEFAULT remains the
+// warehouse restocks go to
+var warehouses = []warehouse{
+	{"DEFAULT", "Default warehouse", "100 Distribution Way", "Columbus", "OH", "USA"},
+	{"EAST-1", "East Coast Fulfilment", "25 Harbor Rd", "Newark", "NJ", "USA"},
+	{"WEST-1", "West Coast Fulfilment", "8 Desert Pkwy", "Reno", "NV", "USA"},
+}
+
+// comments holds review texts by star rating
+var comments = map[int][]string{
+	1: {"Broke after a week.", "Not as described, returning it.", "Very disappointed with the quality."},
+	2: {"Works, but feels cheap.", "Below my expectations.", "Had some issues out of the box."},
+	3: {"Does the job.", "Average for the price.", "Okay, nothing special."},
+	4: {"Good value, would buy again.", "Works well, minor quibbles.", "Solid product overall."},
+	5: {"Excellent, exactly what I needed!", "Fantastic quality.", "Highly recommend it."},
+}
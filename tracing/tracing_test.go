@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// initInMemory installs a tracer provider that records spans synchronously
+// into an in-memory exporter, restoring the previous globals after the test
+func initInMemory(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+// stubDriver is a database/sql driver answering every query with no rows
+type stubDriver struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error) { return stubConn{}, nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(string) (driver.Stmt, error) { return stubStmt{}, nil }
+func (stubConn) Close() error                        { return nil }
+func (stubConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+type stubStmt struct{}
+
+func (stubStmt) Close() error                               { return nil }
+func (stubStmt) NumInput() int                              { return -1 }
+func (stubStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (stubStmt) Query([]driver.Value) (driver.Rows, error)  { return stubRows{}, nil }
+
+type stubRows struct{}
+
+func (stubRows) Columns() []string         { return []string{"id"} }
+func (stubRows) Close() error              { return nil }
+func (stubRows) Next([]driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("tracing-stub", stubDriver{})
+}
+
+func TestMiddlewareNestsDatabaseSpansUnderRequest(t *testing.T) {
+	exporter := initInMemory(t)
+
+	db, err := otelsql.Open("tracing-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	monitor := MongoMonitor()
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/api/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		rows, err := db.QueryContext(ctx, "SELECT id FROM products WHERE id = $1", mux.Vars(r)["id"])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rows.Close()
+
+		command, _ := bson.Marshal(bson.D{{Key: "find", Value: "reviews"}})
+		monitor.Started(ctx, &event.CommandStartedEvent{Command: command, DatabaseName: "ecommerce", CommandName: "find", RequestID: 1, ConnectionID: "mongo:27017"})
+		monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 1, ConnectionID: "mongo:27017"}})
+	}).Methods(http.MethodGet)
+
+	// The caller's trace is continued, so the request span has it as parent
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/products/42", nil)
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), parent), propagation.HeaderCarrier(req.Header))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	var request *tracetest.SpanStub
+	for i := range spans {
+		if spans[i].Name == "GET /api/products/{id}" {
+			request = &spans[i]
+		}
+	}
+	if request == nil {
+		t.Fatalf("no request span among %d spans", len(spans))
+	}
+	if request.SpanKind != trace.SpanKindServer {
+		t.Errorf("request span kind = %v, want server", request.SpanKind)
+	}
+	if request.Parent.SpanID() != parent.SpanID() || request.SpanContext.TraceID() != parent.TraceID() {
+		t.Errorf("request span parent = %v, want the incoming traceparent", request.Parent.SpanID())
+	}
+
+	children := map[string]bool{}
+	for _, s := range spans {
+		if s.Parent.SpanID() == request.SpanContext.SpanID() {
+			if s.SpanContext.TraceID() != parent.TraceID() {
+				t.Errorf("span %q is in trace %v, want %v", s.Name, s.SpanContext.TraceID(), parent.TraceID())
+			}
+			children[s.Name] = true
+		}
+	}
+	for _, name := range []string{"sql.stmt.query", "reviews.find"} {
+		if !children[name] {
+			t.Errorf("no %q span under the request span; children: %v", name, children)
+		}
+	}
+}